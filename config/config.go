@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"github.com/spf13/viper"
+	"time"
 )
 
 var configPath = "."
@@ -13,7 +14,19 @@ func SetConfigPath(p string) {
 
 type Config struct {
 	Postgres
-	Redis RedisConfig
+	Redis     RedisConfig
+	FX        FXConfig
+	Holds     HoldsConfig
+	Schedules SchedulesConfig
+	Snapshots SnapshotsConfig
+	Reconcile ReconcileConfig
+	// Limits maps a currency to the outflow limits of every account in it.
+	// Accounts can override them; a limit left out does not apply.
+	Limits map[string]LimitConfig
+	// Fees maps a movement type (withdraw, transfer, capture) and a currency to the
+	// fee charged on it. Movements without a fee schedule are free.
+	Fees     map[string]map[string]FeeConfig
+	Interest InterestConfig
 }
 
 type Postgres struct {
@@ -23,6 +36,8 @@ type Postgres struct {
 	Password string
 	Dbname   string
 	SSLMode  string
+	// Seed loads the demo users after migrating. Never in production.
+	Seed bool
 }
 
 type RedisConfig struct {
@@ -31,6 +46,77 @@ type RedisConfig struct {
 	DB       int
 }
 
+type FXConfig struct {
+	// Rates maps a pair like "USD/EUR" to the price of one USD in EUR.
+	Rates map[string]string
+	// QuoteTTL is how long a quoted rate can be used for a conversion.
+	QuoteTTL time.Duration
+}
+
+type HoldsConfig struct {
+	// TTL is how long a hold reserves funds unless captured or voided.
+	TTL time.Duration
+	// SweepInterval is how often expired holds are closed.
+	SweepInterval time.Duration
+}
+
+type SchedulesConfig struct {
+	// PollInterval is how often due schedules are paid.
+	PollInterval time.Duration
+}
+
+type InterestConfig struct {
+	// Rates maps a currency to the annual rate, in percent, earned by every
+	// account in it. Accounts can override it.
+	Rates map[string]string
+	// Payout is a cron expression (UTC) of when accrued interest is paid.
+	Payout string
+	// PollInterval is how often interest is accrued and paid.
+	PollInterval time.Duration
+}
+
+type SnapshotsConfig struct {
+	// PollInterval is how often the closing balances of the days that
+	// ended are snapshot.
+	PollInterval time.Duration
+}
+
+type ReconcileConfig struct {
+	// Interval is how often the ledger is checked for drift.
+	Interval time.Duration
+	// RepairCache drops cached balances that disagree with the ledger.
+	RepairCache bool
+}
+
+type LimitConfig struct {
+	// PerTransaction, Daily and Monthly are amounts; Daily and Monthly cap
+	// the withdrawals and outgoing transfers of a UTC day and month.
+	PerTransaction string
+	Daily          string
+	Monthly        string
+	// DailyCount and MonthlyCount cap how many there are.
+	DailyCount   int
+	MonthlyCount int
+}
+
+type FeeConfig struct {
+	// Flat is charged on every movement, plus Percent of its amount.
+	Flat    string
+	Percent string
+	// Tiers replace Flat and Percent for the amounts they cover.
+	Tiers []FeeTier
+	// Min and Max bound the fee.
+	Min string
+	Max string
+}
+
+type FeeTier struct {
+	// UpTo is the largest amount the tier covers; empty covers all amounts.
+	UpTo    string
+	Flat    string
+	Percent string
+}
+
 func NewConfig() (*Config, error) {
 	viper.AddConfigPath(configPath)
 	viper.SetConfigName("config")
@@ -1,41 +1,291 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"embed"
-	_ "embed"
 	"github.com/pkg/errors"
-	"io"
 	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 //go:embed migrations/*.sql
 var migrationFiles embed.FS
 
-func (d DB) Migrate() error {
-	err := fs.WalkDir(migrationFiles, ".", func(path string, dir fs.DirEntry, err error) error {
+//go:embed seeds/*.sql
+var seedFiles embed.FS
+
+// migrationLock is the advisory lock held while migrating, so instances
+// starting together apply each migration once.
+const migrationLock int64 = 7_424_687_152
+
+// sqlComment matches a line comment of SQL.
+var sqlComment = regexp.MustCompile(`--[^\n]*`)
+
+// migrationName matches migration files: a version, a name and the
+// direction, e.g. 0002_exact_money.up.sql.
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema: the SQL that applies it and the
+// SQL that undoes it.
+type Migration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"-"`
+	Down    string `json:"-"`
+}
+
+// MigrationStatus is a migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// loadMigrations reads the migrations in fsys ordered by version. Every
+// version needs an up file; a down file is optional, but like the up file
+// it must hold more than comments.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		m := migrationName.FindStringSubmatch(file)
+		if m == nil {
+			return nil, errors.Errorf("migration %s is not named like 0001_name.up.sql", file)
+		}
+		version, _ := strconv.Atoi(m[1])
+		content, err := fs.ReadFile(fsys, file)
 		if err != nil {
-			return err
+			return nil, errors.WithStack(err)
 		}
 
-		if dir.IsDir() {
-			return nil
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
 		}
-		//println(path)
-		var f fs.File
-		f, err = migrationFiles.Open(path)
-		if err != nil {
-			return errors.WithStack(err)
+		if migration.Name != m[2] {
+			return nil, errors.Errorf("migration %d is named both %s and %s", version, migration.Name, m[2])
+		}
+		if strings.TrimSpace(sqlComment.ReplaceAllString(string(content), "")) == "" {
+			return nil, errors.Errorf("migration %s has no statements", file)
+		}
+		if m[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
 		}
-		defer f.Close()
+	}
 
-		content, _ := io.ReadAll(f)
-		_, err = d.Exec(string(content))
-		if err != nil {
-			return err
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, errors.Errorf("migration %d_%s has no up file", m.Version, m.Name)
 		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func embeddedMigrations() ([]Migration, error) {
+	fsys, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return loadMigrations(fsys)
+}
+
+// Migrate applies the migrations the database does not have yet.
+func (d DB) Migrate() error {
+	_, err := d.MigrateUp(context.Background())
+	return err
+}
+
+// MigrateUp applies, in version order, every migration not recorded in
+// schema_migrations, each in a transaction of its own, and returns those
+// it applied.
+func (d DB) MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
 
+	applied := []Migration{}
+	err = d.withMigrationLock(ctx, func(conn *sql.Conn, done map[int]time.Time) error {
+		for _, m := range migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			err := migrate(ctx, conn, m, m.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, m)
+		}
 		return nil
 	})
+	return applied, err
+}
 
-	return err
+// MigrateDown undoes the last steps applied migrations, latest first, and
+// returns those it undid. A migration without a down file cannot be undone.
+func (d DB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	undone := []Migration{}
+	err = d.withMigrationLock(ctx, func(conn *sql.Conn, done map[int]time.Time) error {
+		for i := len(migrations) - 1; i >= 0 && len(undone) < steps; i-- {
+			m := migrations[i]
+			if _, ok := done[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return errors.Errorf("migration %d_%s cannot be undone", m.Version, m.Name)
+			}
+			if err := migrate(ctx, conn, m, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version); err != nil {
+				return err
+			}
+			undone = append(undone, m)
+		}
+		return nil
+	})
+	return undone, err
+}
+
+// MigrationStatus lists every migration and when it was applied. It only
+// reads, so it neither waits for a migration running meanwhile nor creates
+// schema_migrations.
+func (d DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := map[int]time.Time{}
+	var exists bool
+	if err = d.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		if done, err = appliedMigrations(ctx, d); err != nil {
+			return nil, err
+		}
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := done[m.Version]; ok {
+			s.AppliedAt = &at
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// withMigrationLock runs f on a connection holding the migration lock,
+// with the versions applied so far.
+func (d DB) withMigrationLock(ctx context.Context, f func(conn *sql.Conn, done map[int]time.Time) error) error {
+	conn, err := d.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return err
+	}
+	defer func() { _, _ = conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock) }()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    INT PRIMARY KEY,
+    name       VARCHAR(100) NOT NULL,
+    applied_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`)
+	if err != nil {
+		return err
+	}
+
+	done, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return f(conn, done)
+}
+
+// queryer is a *sql.DB or a *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations and
+// when they were applied.
+func appliedMigrations(ctx context.Context, q queryer) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		done[version] = at
+	}
+	return done, rows.Err()
+}
+
+// migrate runs script and records it in schema_migrations with record, in
+// one transaction.
+func migrate(ctx context.Context, conn *sql.Conn, m Migration, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return errors.Wrapf(err, "migration %d_%s", m.Version, m.Name)
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Seed loads the demo data of seeds/*.sql, in name order. Seeds are
+// idempotent and meant for development only; the schema must be migrated.
+func (d DB) Seed() error {
+	files, err := fs.Glob(seedFiles, "seeds/*.sql")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, file := range files {
+		content, err := seedFiles.ReadFile(file)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, err = d.Exec(string(content)); err != nil {
+			return errors.Wrapf(err, "seed %s", path.Base(file))
+		}
+	}
+	return nil
 }
@@ -1,8 +1,10 @@
 package db
 
 import (
+	"context"
 	"github.com/bitmyth/walletserivce/config"
 	"testing"
+	"testing/fstest"
 )
 
 func TestDB_Migrate(t *testing.T) {
@@ -13,4 +15,79 @@ func TestDB_Migrate(t *testing.T) {
 		t.Error(err)
 		return
 	}
+
+	ctx := context.Background()
+	if applied, err := db.MigrateUp(ctx); err != nil || len(applied) != 0 {
+		t.Error("expect nothing left to apply, got", applied, err)
+	}
+
+	undone, err := db.MigrateDown(ctx, 1)
+	if err != nil || len(undone) != 1 {
+		t.Fatal("expect the latest migration undone, got", undone, err)
+	}
+	status, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := status[len(status)-1]
+	if latest.Version != undone[0].Version || latest.AppliedAt != nil || status[0].AppliedAt == nil {
+		t.Error("expect all but the latest migration applied, got", status)
+	}
+
+	if applied, err := db.MigrateUp(ctx); err != nil || len(applied) != 1 || applied[0].Version != latest.Version {
+		t.Error("expect the latest migration applied again, got", applied, err)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(fstest.MapFS{
+		"0010_later.up.sql":   {Data: []byte("SELECT 10")},
+		"0002_second.up.sql":  {Data: []byte("SELECT 2")},
+		"0001_first.up.sql":   {Data: []byte("SELECT 1")},
+		"0001_first.down.sql": {Data: []byte("SELECT -1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	if len(versions) != 3 || versions[0] != 1 || versions[1] != 2 || versions[2] != 10 {
+		t.Error("expect migrations ordered by version, got", versions)
+	}
+	if migrations[0].Name != "first" || migrations[0].Down != "SELECT -1" || migrations[1].Down != "" {
+		t.Error("expect up and down paired, got", migrations)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"misnamed":  {"schema.sql": {Data: []byte("SELECT 1")}},
+		"down only": {"0001_first.down.sql": {Data: []byte("SELECT 1")}},
+		"two names": {"0001_first.up.sql": {Data: []byte("SELECT 1")}, "0001_other.down.sql": {Data: []byte("SELECT 1")}},
+		"empty up":  {"0001_first.up.sql": {Data: []byte("")}},
+		"comment only down": {
+			"0001_first.up.sql":   {Data: []byte("SELECT 1")},
+			"0001_first.down.sql": {Data: []byte("-- nothing to undo\n\n")},
+		},
+	} {
+		if _, err = loadMigrations(fsys); err == nil {
+			t.Error(name, "expect an error")
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := embeddedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("expect version %d, got %d_%s", i+1, m.Version, m.Name)
+		}
+		// loadMigrations refuses files without statements
+		if m.Down == "" {
+			t.Errorf("expect %d_%s to have a down migration", m.Version, m.Name)
+		}
+	}
 }
@@ -0,0 +1,27 @@
+-- The schema from before migrations were versioned: a FLOAT balance on each
+-- user and one signed transactions row per movement. The migrations after
+-- this one bring such a database, or a new one, up to date. A database set
+-- up by a later unversioned release already has users and is left as it is,
+-- so every migration must tolerate finding its changes already made.
+DO
+$$
+    BEGIN
+        IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'users') THEN
+            CREATE TABLE users
+            (
+                id       SERIAL PRIMARY KEY,
+                username VARCHAR(50) UNIQUE NOT NULL,
+                balance  FLOAT DEFAULT 0.0
+            );
+
+            CREATE TABLE transactions
+            (
+                id               SERIAL PRIMARY KEY,
+                user_id          INT REFERENCES users (id) ON DELETE CASCADE,
+                amount           FLOAT       NOT NULL,
+                transaction_type VARCHAR(10) NOT NULL,
+                created_at       TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            );
+        END IF;
+    END
+$$;
@@ -0,0 +1,22 @@
+-- Money back to FLOAT, as it was stored before.
+DO
+$$
+    BEGIN
+        IF (SELECT data_type
+            FROM information_schema.columns
+            WHERE table_name = 'users'
+              AND column_name = 'balance') = 'numeric' THEN
+            ALTER TABLE users
+                ALTER COLUMN balance TYPE FLOAT USING balance::float,
+                ALTER COLUMN balance SET DEFAULT 0.0;
+        END IF;
+
+        IF (SELECT data_type
+            FROM information_schema.columns
+            WHERE table_name = 'transactions'
+              AND column_name = 'amount') = 'numeric' THEN
+            ALTER TABLE transactions
+                ALTER COLUMN amount TYPE FLOAT USING amount::float;
+        END IF;
+    END
+$$;
@@ -0,0 +1,78 @@
+-- Earlier versions stored money as FLOAT. Convert existing columns to exact
+-- NUMERIC at the minor unit of each row's currency (2 places for USD, 0 for
+-- JPY); rows from before currencies are USD. A value with more digits than
+-- its currency has cannot be converted without changing it, so the
+-- migration fails instead and lists such rows, to be corrected first.
+DO
+$$
+    DECLARE
+        -- the exponent of the currency code %s, as in wallet/currency.go
+        exponent CONSTANT TEXT := $e$CASE upper(%s) WHEN 'JPY' THEN 0 WHEN 'KRW' THEN 0 WHEN 'KWD' THEN 3 WHEN 'BHD' THEN 3 ELSE 2 END$e$;
+        balances  BOOLEAN;
+        amounts   BOOLEAN;
+        code      TEXT    := quote_literal('USD');
+        total     INT;
+        rejected  INT     := 0;
+        listed    TEXT;
+        inexact   TEXT[]  := '{}';
+    BEGIN
+        balances := (SELECT data_type
+                     FROM information_schema.columns
+                     WHERE table_name = 'users'
+                       AND column_name = 'balance') = 'double precision';
+        amounts := (SELECT data_type
+                    FROM information_schema.columns
+                    WHERE table_name = 'transactions'
+                      AND column_name = 'amount') = 'double precision';
+        IF EXISTS (SELECT 1
+                   FROM information_schema.columns
+                   WHERE table_name = 'transactions'
+                     AND column_name = 'currency') THEN
+            code := 'currency';
+        END IF;
+
+        IF balances THEN
+            SELECT max(n), string_agg(format('users %s: %s', id, balance), ', ' ORDER BY id)
+            INTO total, listed
+            FROM (SELECT id, balance, count(*) OVER () AS n
+                  FROM users
+                  WHERE balance::numeric <> round(balance::numeric, 2)
+                  ORDER BY id
+                  LIMIT 20) r;
+            IF listed IS NOT NULL THEN
+                rejected := rejected + total;
+                inexact := inexact || listed;
+            END IF;
+        END IF;
+
+        IF amounts THEN
+            EXECUTE format($q$SELECT max(n), string_agg(format('transactions %%s: %%s %%s', id, amount, cur), ', ' ORDER BY id)
+                FROM (SELECT id, amount, %1$s AS cur, count(*) OVER () AS n
+                      FROM transactions
+                      WHERE amount::numeric <> round(amount::numeric, %2$s)
+                      ORDER BY id
+                      LIMIT 20) r$q$, code, format(exponent, code))
+                INTO total, listed;
+            IF listed IS NOT NULL THEN
+                rejected := rejected + total;
+                inexact := inexact || listed;
+            END IF;
+        END IF;
+
+        IF rejected > 0 THEN
+            RAISE EXCEPTION '% amounts have more digits than their currency, e.g. %', rejected, array_to_string(inexact, ', ')
+                USING HINT = 'Correct them, then migrate again.';
+        END IF;
+
+        IF balances THEN
+            ALTER TABLE users
+                ALTER COLUMN balance TYPE NUMERIC(20, 4) USING round(balance::numeric, 2),
+                ALTER COLUMN balance SET DEFAULT 0;
+        END IF;
+
+        IF amounts THEN
+            EXECUTE format('ALTER TABLE transactions ALTER COLUMN amount TYPE NUMERIC(20, 4) USING round(amount::numeric, %s)',
+                           format(exponent, code));
+        END IF;
+    END
+$$;
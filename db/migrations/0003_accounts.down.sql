@@ -0,0 +1,15 @@
+-- Back to a single balance on users, the USD one. Balances in other
+-- currencies are lost, and so is the currency of transactions.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS balance NUMERIC(20, 4) DEFAULT 0;
+
+UPDATE users u
+SET balance = a.balance
+FROM accounts a
+WHERE a.user_id = u.id
+  AND a.currency = 'USD';
+
+ALTER TABLE IF EXISTS transactions
+    DROP COLUMN IF EXISTS currency;
+
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,47 @@
+-- An account holds one currency, either for a user or for the system
+-- (cash-in, cash-out, fees, ...). balance is only maintained for user
+-- accounts; a system account's balance is the sum of its postings.
+CREATE TABLE IF NOT EXISTS accounts
+(
+    id       SERIAL PRIMARY KEY,
+    user_id  INT REFERENCES users (id) ON DELETE CASCADE,
+    system   VARCHAR(20),
+    currency CHAR(3)        NOT NULL,
+    balance  NUMERIC(20, 4) NOT NULL DEFAULT 0,
+    UNIQUE (user_id, currency),
+    UNIQUE (system, currency),
+    CHECK ((user_id IS NULL) <> (system IS NULL))
+);
+
+DO
+$$
+    BEGIN
+        IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'accounts_balance_non_negative') THEN
+            ALTER TABLE accounts
+                ADD CONSTRAINT accounts_balance_non_negative CHECK (user_id IS NULL OR balance >= 0);
+        END IF;
+    END
+$$;
+
+-- Move the single balance users had into accounts as their USD balance,
+-- and tag existing transactions as USD.
+ALTER TABLE IF EXISTS transactions
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+DO
+$$
+    BEGIN
+        IF EXISTS (SELECT 1
+                   FROM information_schema.columns
+                   WHERE table_name = 'users'
+                     AND column_name = 'balance') THEN
+            INSERT INTO accounts (user_id, currency, balance)
+            SELECT id, 'USD', COALESCE(balance, 0)
+            FROM users
+            ON CONFLICT (user_id, currency) DO NOTHING;
+
+            ALTER TABLE users
+                DROP COLUMN balance;
+        END IF;
+    END
+$$;
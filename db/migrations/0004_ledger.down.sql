@@ -0,0 +1,28 @@
+-- Back to one signed transactions row per user account and posting, in the
+-- order they were posted. Entries opening legacy balances are left out: the
+-- balances stay in accounts, and migrating up posts them again.
+CREATE TABLE IF NOT EXISTS transactions
+(
+    id               SERIAL PRIMARY KEY,
+    user_id          INT REFERENCES users (id) ON DELETE CASCADE,
+    amount           NUMERIC(20, 4) NOT NULL,
+    currency         CHAR(3)        NOT NULL DEFAULT 'USD',
+    transaction_type VARCHAR(20)    NOT NULL,
+    created_at       TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO transactions (user_id, amount, currency, transaction_type, created_at)
+SELECT a.user_id, p.amount, p.currency, e.entry_type, e.created_at
+FROM postings p
+         JOIN accounts a ON a.id = p.account_id
+         JOIN journal_entries e ON e.id = p.entry_id
+WHERE a.user_id IS NOT NULL
+  AND e.entry_type <> 'opening'
+ORDER BY p.id;
+
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP FUNCTION IF EXISTS check_entry_balanced();
+
+-- system accounts only ever held postings
+DELETE FROM accounts WHERE system IS NOT NULL;
@@ -0,0 +1,126 @@
+-- Money moves in a double-entry ledger: a journal entry per movement, with
+-- postings to the accounts it moves money between.
+CREATE TABLE IF NOT EXISTS journal_entries
+(
+    id         SERIAL PRIMARY KEY,
+    entry_type VARCHAR(20) NOT NULL,
+    rate       NUMERIC(20, 10),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS postings
+(
+    id         SERIAL PRIMARY KEY,
+    entry_id   INT            NOT NULL REFERENCES journal_entries (id) ON DELETE CASCADE,
+    account_id INT            NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+    currency   CHAR(3)        NOT NULL,
+    amount     NUMERIC(20, 4) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS postings_entry_id ON postings (entry_id);
+CREATE INDEX IF NOT EXISTS postings_account_id ON postings (account_id);
+
+-- The postings of a journal entry must sum to zero in every currency. The
+-- check is deferred to commit so an entry can be written posting by posting.
+CREATE OR REPLACE FUNCTION check_entry_balanced() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF EXISTS (SELECT 1
+               FROM postings
+               WHERE entry_id = NEW.entry_id
+               GROUP BY currency
+               HAVING SUM(amount) <> 0) THEN
+        RAISE EXCEPTION 'journal entry % does not balance', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DO
+$$
+    BEGIN
+        IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'postings_balanced') THEN
+            CREATE CONSTRAINT TRIGGER postings_balanced
+                AFTER INSERT OR UPDATE
+                ON postings
+                DEFERRABLE INITIALLY DEFERRED
+                FOR EACH ROW
+            EXECUTE FUNCTION check_entry_balanced();
+        END IF;
+    END
+$$;
+
+-- Earlier versions wrote one signed transactions row per user, with no
+-- counter-party. Replay every legacy row as a journal entry (keeping its id)
+-- against the opening account. Whatever part of a balance the history does
+-- not explain, like seed data, is posted as an opening entry too.
+ALTER TABLE IF EXISTS transactions
+    ADD COLUMN IF NOT EXISTS rate NUMERIC(20, 10);
+
+DO
+$$
+    DECLARE
+        legacy BOOLEAN := false;
+        r      RECORD;
+        entry  INT;
+    BEGIN
+        IF EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'transactions') THEN
+            INSERT INTO accounts (user_id, currency)
+            SELECT DISTINCT user_id, currency
+            FROM transactions
+            WHERE user_id IS NOT NULL
+            ON CONFLICT (user_id, currency) DO NOTHING;
+
+            INSERT INTO accounts (system, currency)
+            SELECT DISTINCT 'opening', currency
+            FROM transactions
+            ON CONFLICT (system, currency) DO NOTHING;
+
+            INSERT INTO journal_entries (id, entry_type, rate, created_at)
+            SELECT id, transaction_type, rate, created_at
+            FROM transactions
+            WHERE user_id IS NOT NULL;
+
+            PERFORM setval(pg_get_serial_sequence('journal_entries', 'id'),
+                           GREATEST((SELECT MAX(id) FROM journal_entries), 1));
+
+            INSERT INTO postings (entry_id, account_id, currency, amount)
+            SELECT t.id, a.id, t.currency, t.amount
+            FROM transactions t
+                     JOIN accounts a ON a.user_id = t.user_id AND a.currency = t.currency
+            UNION ALL
+            SELECT t.id, a.id, t.currency, -t.amount
+            FROM transactions t
+                     JOIN accounts a ON a.system = 'opening' AND a.currency = t.currency
+            WHERE t.user_id IS NOT NULL;
+
+            DROP TABLE transactions;
+            legacy := true;
+        END IF;
+
+        IF legacy THEN
+            INSERT INTO accounts (system, currency)
+            SELECT DISTINCT 'opening', currency
+            FROM accounts
+            WHERE user_id IS NOT NULL
+            ON CONFLICT (system, currency) DO NOTHING;
+
+            FOR r IN SELECT a.id, a.currency, a.balance - COALESCE(SUM(p.amount), 0) AS amount
+                     FROM accounts a
+                              LEFT JOIN postings p ON p.account_id = a.id
+                     WHERE a.user_id IS NOT NULL
+                     GROUP BY a.id
+                     HAVING a.balance <> COALESCE(SUM(p.amount), 0)
+                LOOP
+                    INSERT INTO journal_entries (entry_type) VALUES ('opening') RETURNING id INTO entry;
+                    INSERT INTO postings (entry_id, account_id, currency, amount)
+                    SELECT entry, r.id, r.currency, r.amount
+                    UNION ALL
+                    SELECT entry, id, r.currency, -r.amount
+                    FROM accounts
+                    WHERE system = 'opening'
+                      AND currency = r.currency;
+                END LOOP;
+        END IF;
+    END
+$$;
@@ -0,0 +1,11 @@
+-- Outcome of requests sent with an Idempotency-Key. status_code is NULL
+-- while the first request is still running.
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    key          VARCHAR(255) PRIMARY KEY,
+    request_hash CHAR(64)  NOT NULL,
+    status_code  INT,
+    response     BYTEA,
+    locked_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,5 @@
+-- Accounts are active until frozen or closed.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS status     VARCHAR(10) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'frozen', 'closed')),
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
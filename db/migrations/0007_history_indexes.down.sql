@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS journal_entries_created_at;
+DROP INDEX IF EXISTS postings_account_id_id;
+CREATE INDEX IF NOT EXISTS postings_account_id ON postings (account_id);
@@ -0,0 +1,4 @@
+-- history pages walk an account's postings by id, see wallet/history.go
+DROP INDEX IF EXISTS postings_account_id;
+CREATE INDEX IF NOT EXISTS postings_account_id_id ON postings (account_id, id);
+CREATE INDEX IF NOT EXISTS journal_entries_created_at ON journal_entries (created_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS journal_entries_pending;
+
+ALTER TABLE journal_entries
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS completed_at,
+    DROP COLUMN IF EXISTS failed_at,
+    DROP COLUMN IF EXISTS reversed_at;
@@ -0,0 +1,22 @@
+-- An entry is completed when written, unless it is a movement that starts
+-- pending and is settled (completed) or failed later. Completed entries can
+-- be reversed. Each transition is timestamped.
+DO
+$$
+    BEGIN
+        IF NOT EXISTS (SELECT 1
+                       FROM information_schema.columns
+                       WHERE table_name = 'journal_entries'
+                         AND column_name = 'status') THEN
+            ALTER TABLE journal_entries
+                ADD COLUMN status       VARCHAR(10) NOT NULL DEFAULT 'completed'
+                    CHECK (status IN ('pending', 'completed', 'failed', 'reversed')),
+                ADD COLUMN completed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+                ADD COLUMN failed_at    TIMESTAMP,
+                ADD COLUMN reversed_at  TIMESTAMP;
+            UPDATE journal_entries SET completed_at = created_at;
+        END IF;
+    END
+$$;
+
+CREATE INDEX IF NOT EXISTS journal_entries_pending ON journal_entries (id) WHERE status = 'pending';
@@ -0,0 +1,19 @@
+-- A hold reserves amount of a user account until it is captured, voided
+-- or expires. Active holds reduce the available balance; money only moves
+-- when a hold is captured, through the journal entry capture_entry_id.
+CREATE TABLE IF NOT EXISTS holds
+(
+    id               SERIAL PRIMARY KEY,
+    account_id       INT            NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+    amount           NUMERIC(20, 4) NOT NULL CHECK (amount > 0),
+    captured         NUMERIC(20, 4),
+    capture_entry_id INT REFERENCES journal_entries (id),
+    status           VARCHAR(10)    NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'captured', 'voided', 'expired')),
+    expires_at       TIMESTAMP      NOT NULL,
+    created_at       TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    closed_at        TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS holds_active_account_id ON holds (account_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS holds_active_expires_at ON holds (expires_at) WHERE status = 'active';
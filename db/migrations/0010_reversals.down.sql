@@ -0,0 +1,2 @@
+ALTER TABLE journal_entries
+    DROP COLUMN IF EXISTS reverses_id;
@@ -0,0 +1,6 @@
+-- A reversal (or partial refund) is an entry of its own, compensating the
+-- entry it reverses_id.
+ALTER TABLE journal_entries
+    ADD COLUMN IF NOT EXISTS reverses_id INT REFERENCES journal_entries (id);
+
+CREATE INDEX IF NOT EXISTS journal_entries_reverses_id ON journal_entries (reverses_id) WHERE reverses_id IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE journal_entries
+    DROP COLUMN IF EXISTS transfer_id;
+
+DROP TABLE IF EXISTS transfers;
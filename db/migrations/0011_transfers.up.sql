@@ -0,0 +1,23 @@
+-- A transfer between two users. Its journal entry points back at it
+-- through transfer_id; amount is debited from the sender in currency and
+-- to_amount credited to the receiver in to_currency.
+CREATE TABLE IF NOT EXISTS transfers
+(
+    id           SERIAL PRIMARY KEY,
+    from_user_id INT            NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    to_user_id   INT            NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount       NUMERIC(20, 4) NOT NULL CHECK (amount > 0),
+    currency     CHAR(3)        NOT NULL,
+    to_amount    NUMERIC(20, 4) NOT NULL CHECK (to_amount > 0),
+    to_currency  CHAR(3)        NOT NULL,
+    memo         VARCHAR(140),
+    reference    VARCHAR(64),
+    created_at   TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS transfers_reference ON transfers (reference) WHERE reference IS NOT NULL;
+
+ALTER TABLE journal_entries
+    ADD COLUMN IF NOT EXISTS transfer_id INT REFERENCES transfers (id);
+
+CREATE INDEX IF NOT EXISTS journal_entries_transfer_id ON journal_entries (transfer_id) WHERE transfer_id IS NOT NULL;
@@ -0,0 +1,43 @@
+-- A standing order paying amount from one user to another: once at
+-- next_run_at when neither cron nor interval_seconds is set, else on every
+-- occurrence of either. due_at is the occurrence being paid; a payment
+-- failing for lack of funds is retried up to max_retries times.
+CREATE TABLE IF NOT EXISTS schedules
+(
+    id                     SERIAL PRIMARY KEY,
+    from_user_id           INT            NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    to_user_id             INT            NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount                 NUMERIC(20, 4) NOT NULL CHECK (amount > 0),
+    currency               CHAR(3)        NOT NULL,
+    memo                   VARCHAR(140),
+    cron                   VARCHAR(100),
+    interval_seconds       INT CHECK (interval_seconds > 0),
+    max_retries            INT            NOT NULL DEFAULT 3,
+    retry_interval_seconds INT            NOT NULL DEFAULT 3600,
+    status                 VARCHAR(10)    NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'paused', 'completed', 'failed', 'cancelled')),
+    due_at                 TIMESTAMP,
+    next_run_at            TIMESTAMP,
+    attempts               INT            NOT NULL DEFAULT 0,
+    created_at             TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS schedules_active_next_run_at ON schedules (next_run_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS schedules_from_user_id ON schedules (from_user_id);
+
+-- Every attempt at paying a schedule, with the transfer it made or why it
+-- could not.
+CREATE TABLE IF NOT EXISTS schedule_runs
+(
+    id          SERIAL PRIMARY KEY,
+    schedule_id INT         NOT NULL REFERENCES schedules (id) ON DELETE CASCADE,
+    due_at      TIMESTAMP   NOT NULL,
+    attempt     INT         NOT NULL,
+    status      VARCHAR(10) NOT NULL CHECK (status IN ('succeeded', 'retrying', 'failed')),
+    transfer_id INT REFERENCES transfers (id),
+    code        VARCHAR(50),
+    error       TEXT,
+    created_at  TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS schedule_runs_schedule_id ON schedule_runs (schedule_id, id);
@@ -0,0 +1,12 @@
+-- Outflow limits of one account, overriding the configured ones of its
+-- currency. A NULL limit falls back to the configured one.
+CREATE TABLE IF NOT EXISTS account_limits
+(
+    account_id      INT PRIMARY KEY REFERENCES accounts (id) ON DELETE CASCADE,
+    per_transaction NUMERIC(20, 4),
+    daily           NUMERIC(20, 4),
+    monthly         NUMERIC(20, 4),
+    daily_count     INT,
+    monthly_count   INT,
+    updated_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,6 @@
+-- A fee is an entry of its own, debiting the payer to the fees account,
+-- linked to the withdrawal or transfer it is charged for.
+ALTER TABLE journal_entries
+    ADD COLUMN IF NOT EXISTS fee_for_id INT REFERENCES journal_entries (id);
+
+CREATE INDEX IF NOT EXISTS journal_entries_fee_for_id ON journal_entries (fee_for_id) WHERE fee_for_id IS NOT NULL;
@@ -0,0 +1,24 @@
+-- An annual interest rate in percent of one account, overriding the
+-- configured one of its currency.
+CREATE TABLE IF NOT EXISTS interest_rates
+(
+    account_id INT PRIMARY KEY REFERENCES accounts (id) ON DELETE CASCADE,
+    rate       NUMERIC(9, 6) NOT NULL CHECK (rate >= 0),
+    updated_at TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Interest an account earned on its balance at the end of day, at rate.
+-- It is accrued but unpaid until a payout, the interest entry payout_id,
+-- pays it out with the rest.
+CREATE TABLE IF NOT EXISTS interest_accruals
+(
+    account_id INT             NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+    day        DATE            NOT NULL,
+    balance    NUMERIC(20, 4)  NOT NULL,
+    rate       NUMERIC(9, 6)   NOT NULL,
+    amount     NUMERIC(24, 10) NOT NULL,
+    payout_id  INT REFERENCES journal_entries (id),
+    PRIMARY KEY (account_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS interest_accruals_unpaid ON interest_accruals (account_id) WHERE payout_id IS NULL;
@@ -0,0 +1,11 @@
+-- The closing balance of an account on day: the sum of its postings
+-- completed before the next day began. Balances at a point in time start
+-- from the latest snapshot before it.
+CREATE TABLE IF NOT EXISTS balance_snapshots
+(
+    account_id INT            NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+    day        DATE           NOT NULL,
+    balance    NUMERIC(20, 4) NOT NULL,
+    created_at TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (account_id, day)
+);
@@ -0,0 +1,4 @@
+-- The request holding a claim on an Idempotency-Key, so only it can store
+-- the outcome, even after its claim was taken over.
+ALTER TABLE idempotency_keys
+    ADD COLUMN IF NOT EXISTS owner CHAR(32) NOT NULL DEFAULT '';
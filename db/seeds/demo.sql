@@ -0,0 +1,27 @@
+INSERT INTO users (username) VALUES ('user1'), ('user2') ON CONFLICT (username) DO NOTHING;
+
+-- Demo users start with a 100 USD deposit.
+DO
+$$
+    DECLARE
+        u       RECORD;
+        cash_in INT;
+        account INT;
+        entry   INT;
+    BEGIN
+        INSERT INTO accounts (system, currency) VALUES ('cash-in', 'USD') ON CONFLICT (system, currency) DO NOTHING;
+        SELECT id INTO cash_in FROM accounts WHERE system = 'cash-in' AND currency = 'USD';
+
+        FOR u IN SELECT id
+                 FROM users
+                 WHERE username IN ('user1', 'user2')
+                   AND NOT EXISTS (SELECT 1 FROM accounts a WHERE a.user_id = users.id AND a.currency = 'USD')
+            LOOP
+                INSERT INTO accounts (user_id, currency, balance) VALUES (u.id, 'USD', 100) RETURNING id INTO account;
+                INSERT INTO journal_entries (entry_type) VALUES ('deposit') RETURNING id INTO entry;
+                INSERT INTO postings (entry_id, account_id, currency, amount)
+                VALUES (entry, account, 'USD', 100),
+                       (entry, cash_in, 'USD', -100);
+            END LOOP;
+    END
+$$;
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/bitmyth/walletserivce/config"
 	"github.com/bitmyth/walletserivce/db"
+	"github.com/bitmyth/walletserivce/fx"
 	"github.com/bitmyth/walletserivce/wallet"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -14,6 +15,7 @@ type Factory interface {
 	DB() (*db.DB, error)
 	Redis() (*db.Redis, error)
 	Logger() *zap.SugaredLogger
+	FX() *fx.Service
 	WalletController() *wallet.Controller
 	RegisterRoutes(router *gin.Engine)
 }
@@ -23,6 +25,8 @@ type Default struct {
 	config           *config.Config
 	db               *db.DB
 	redis            *db.Redis
+	rates            fx.RateProvider
+	fx               *fx.Service
 	walletController *wallet.Controller
 }
 
@@ -37,6 +41,13 @@ func (d *Default) WalletController() *wallet.Controller {
 	return d.walletController
 }
 
+func (d *Default) FX() *fx.Service {
+	if d.fx == nil {
+		d.fx = fx.NewService(d, d.rates)
+	}
+	return d.fx
+}
+
 func New() (Factory, error) {
 	f := &Default{
 		logger: logger(),
@@ -48,6 +59,12 @@ func New() (Factory, error) {
 	}
 	f.config = c
 
+	f.rates, err = fx.NewConfigProvider(c.FX.Rates)
+	if err != nil {
+		f.Logger().Error(err)
+		return nil, err
+	}
+
 	return f, nil
 }
 
@@ -96,6 +113,7 @@ func logger() *zap.SugaredLogger {
 type TestingFactory struct {
 	logger           *zap.SugaredLogger
 	config           *config.Config
+	rates            fx.RateProvider
 	walletController *wallet.Controller
 }
 
@@ -103,6 +121,10 @@ func (t TestingFactory) RegisterRoutes(router *gin.Engine) {
 	t.WalletController().RegisterRoutes(router)
 }
 
+func (t TestingFactory) FX() *fx.Service {
+	return fx.NewService(t, t.rates)
+}
+
 func (t TestingFactory) WalletController() *wallet.Controller {
 	if t.walletController == nil {
 		t.walletController = wallet.NewController(t)
@@ -120,6 +142,11 @@ func NewTesting() (Factory, error) {
 	}
 	f.config = c
 
+	f.rates, err = fx.NewConfigProvider(c.FX.Rates)
+	if err != nil {
+		return nil, err
+	}
+
 	return f, nil
 }
 
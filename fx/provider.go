@@ -0,0 +1,57 @@
+package fx
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+var ErrNoRate = errors.New("no exchange rate for currency pair")
+
+// RateProvider prices one unit of currency from in currency to.
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (decimal.Decimal, error)
+}
+
+// inversePlaces is the precision of rates derived by inverting a configured one.
+const inversePlaces = 10
+
+// ConfigProvider serves fixed rates taken from the fx.rates config section.
+// A pair configured one way is also quoted the other way by inversion.
+type ConfigProvider struct {
+	rates map[string]decimal.Decimal
+}
+
+// NewConfigProvider parses rates keyed by "FROM/TO", e.g. "USD/EUR": "0.92".
+func NewConfigProvider(rates map[string]string) (*ConfigProvider, error) {
+	p := &ConfigProvider{rates: map[string]decimal.Decimal{}}
+	for pair, value := range rates {
+		from, to, ok := strings.Cut(strings.ToUpper(pair), "/")
+		if !ok || from == "" || to == "" {
+			return nil, errors.Errorf("fx rate %q: pair must look like USD/EUR", pair)
+		}
+		rate, err := decimal.NewFromString(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fx rate %q", pair)
+		}
+		if !rate.IsPositive() {
+			return nil, errors.Errorf("fx rate %q: must be positive", pair)
+		}
+		p.rates[from+"/"+to] = rate
+	}
+	return p, nil
+}
+
+func (p *ConfigProvider) Rate(_ context.Context, from, to string) (decimal.Decimal, error) {
+	if from == to {
+		return decimal.NewFromInt(1), nil
+	}
+	if rate, ok := p.rates[from+"/"+to]; ok {
+		return rate, nil
+	}
+	if rate, ok := p.rates[to+"/"+from]; ok {
+		return decimal.NewFromInt(1).DivRound(rate, inversePlaces), nil
+	}
+	return decimal.Decimal{}, errors.Wrapf(ErrNoRate, "%s/%s", from, to)
+}
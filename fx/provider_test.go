@@ -0,0 +1,43 @@
+package fx_test
+
+import (
+	"context"
+	"errors"
+	"github.com/bitmyth/walletserivce/fx"
+	"testing"
+)
+
+func TestConfigProvider_Rate(t *testing.T) {
+	p, err := fx.NewConfigProvider(map[string]string{"usd/eur": "0.8"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rate, err := p.Rate(context.Background(), "USD", "EUR")
+	if err != nil || rate.String() != "0.8" {
+		t.Error("unexpected USD/EUR rate", rate, err)
+	}
+
+	rate, err = p.Rate(context.Background(), "EUR", "USD")
+	if err != nil || rate.String() != "1.25" {
+		t.Error("unexpected EUR/USD rate", rate, err)
+	}
+
+	_, err = p.Rate(context.Background(), "USD", "JPY")
+	if !errors.Is(err, fx.ErrNoRate) {
+		t.Error("expect ErrNoRate, got", err)
+	}
+}
+
+func TestNewConfigProviderInvalid(t *testing.T) {
+	for _, rates := range []map[string]string{
+		{"USDEUR": "0.8"},
+		{"USD/EUR": "abc"},
+		{"USD/EUR": "-1"},
+	} {
+		if _, err := fx.NewConfigProvider(rates); err == nil {
+			t.Error("expect return err for", rates)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package fx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/bitmyth/walletserivce/config"
+	"github.com/bitmyth/walletserivce/db"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+var ErrQuoteNotFound = errors.New("fx quote not found or expired")
+
+// DefaultQuoteTTL applies when fx.quoteTTL is not configured.
+const DefaultQuoteTTL = 30 * time.Second
+
+type dependency interface {
+	Config() *config.Config
+	Redis() (*db.Redis, error)
+	Logger() *zap.SugaredLogger
+}
+
+// Quote locks the rate of a currency pair until ExpiresAt.
+type Quote struct {
+	ID        string          `json:"id"`
+	From      string          `json:"from"`
+	To        string          `json:"to"`
+	Rate      decimal.Decimal `json:"rate"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+type Service struct {
+	factory  dependency
+	provider RateProvider
+}
+
+func NewService(f dependency, provider RateProvider) *Service {
+	return &Service{factory: f, provider: provider}
+}
+
+func quoteKey(id string) string {
+	return "fx:quote:" + id
+}
+
+func (s *Service) ttl() time.Duration {
+	if ttl := s.factory.Config().FX.QuoteTTL; ttl > 0 {
+		return ttl
+	}
+	return DefaultQuoteTTL
+}
+
+// Quote fetches the current rate of from/to and stores it in Redis, where it
+// stays usable for the quote TTL.
+func (s *Service) Quote(ctx context.Context, from, to string) (Quote, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+
+	rate, err := s.provider.Rate(ctx, from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return Quote{}, err
+	}
+
+	ttl := s.ttl()
+	q := Quote{
+		ID:        hex.EncodeToString(id),
+		From:      from,
+		To:        to,
+		Rate:      rate,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}
+
+	rdb, err := s.factory.Redis()
+	if err != nil {
+		return Quote{}, err
+	}
+	b, _ := json.Marshal(q)
+	if err = rdb.Set(ctx, quoteKey(q.ID), b, ttl).Err(); err != nil {
+		return Quote{}, err
+	}
+
+	return q, nil
+}
+
+// Lookup returns a quote that has not expired yet.
+func (s *Service) Lookup(ctx context.Context, id string) (Quote, error) {
+	rdb, err := s.factory.Redis()
+	if err != nil {
+		return Quote{}, err
+	}
+
+	b, err := rdb.Get(ctx, quoteKey(id)).Bytes()
+	if err == redis.Nil {
+		return Quote{}, errors.Wrapf(ErrQuoteNotFound, "%q", id)
+	}
+	if err != nil {
+		return Quote{}, err
+	}
+
+	var q Quote
+	if err = json.Unmarshal(b, &q); err != nil {
+		return Quote{}, err
+	}
+	return q, nil
+}
@@ -0,0 +1,52 @@
+package fx_test
+
+import (
+	"context"
+	"errors"
+	"github.com/bitmyth/walletserivce/config"
+	"github.com/bitmyth/walletserivce/factory"
+	"github.com/bitmyth/walletserivce/fx"
+	"testing"
+)
+
+var f factory.Factory
+
+func TestMain(m *testing.M) {
+	config.SetConfigPath("../")
+
+	var err error
+	f, err = factory.New()
+	if err != nil {
+		return
+	}
+
+	m.Run()
+}
+
+func TestService_Quote(t *testing.T) {
+	provider, _ := fx.NewConfigProvider(map[string]string{"USD/EUR": "0.9"})
+	s := fx.NewService(f, provider)
+
+	quote, err := s.Quote(context.Background(), "usd", "eur")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if quote.From != "USD" || quote.To != "EUR" || quote.Rate.String() != "0.9" {
+		t.Error("unexpected quote", quote)
+	}
+
+	found, err := s.Lookup(context.Background(), quote.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !found.Rate.Equal(quote.Rate) {
+		t.Error("lookup returned another rate", found.Rate)
+	}
+
+	_, err = s.Lookup(context.Background(), "notfound")
+	if !errors.Is(err, fx.ErrQuoteNotFound) {
+		t.Error("expect ErrQuoteNotFound, got", err)
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"encoding/json"
+	"flag"
 	"github.com/bitmyth/walletserivce/factory"
 	"github.com/bitmyth/walletserivce/route"
+	"github.com/bitmyth/walletserivce/wallet"
 	"log"
 	"net/http"
+	"os"
 )
 
 func main() {
@@ -15,6 +20,15 @@ func main() {
 	}
 	logger := f.Logger()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			os.Exit(reconcile(f, os.Args[2:]))
+		case "migrate":
+			os.Exit(migrate(f, os.Args[2:]))
+		}
+	}
+
 	db, err := f.DB()
 	if err != nil {
 		logger.Error(err)
@@ -25,6 +39,20 @@ func main() {
 		logger.Error(err)
 		return
 	}
+	if f.Config().Seed {
+		if err = db.Seed(); err != nil {
+			logger.Error(err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go wallet.NewService(f).SweepHolds(ctx)
+	go wallet.NewService(f).RunSchedules(ctx)
+	go wallet.NewService(f).RunInterest(ctx)
+	go wallet.NewService(f).RunSnapshots(ctx)
+	go wallet.NewService(f).RunReconciliation(ctx)
 
 	router := route.Router(f)
 	f.RegisterRoutes(router)
@@ -32,3 +60,74 @@ func main() {
 	logger.Infoln("http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", router))
 }
+
+// reconcile checks the ledger once and prints the report as JSON. It
+// returns the exit status: 1 when discrepancies were found, 2 when the
+// check could not run.
+func reconcile(f factory.Factory, args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := flags.Bool("repair-cache", false, "drop cached balances that disagree with the ledger")
+	_ = flags.Parse(args)
+
+	report, err := wallet.NewService(f).Reconcile(context.Background(), *repair)
+	if err != nil {
+		f.Logger().Error(err)
+		return 2
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	if err = out.Encode(report); err != nil {
+		f.Logger().Error(err)
+		return 2
+	}
+	if len(report.Discrepancies) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// migrate applies, undoes or lists the schema migrations, or loads the demo
+// data: migrate [up | down [-steps n] | status | seed]. It prints what it
+// did as JSON and returns the exit status.
+func migrate(f factory.Factory, args []string) int {
+	action := "up"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	flags := flag.NewFlagSet("migrate "+action, flag.ExitOnError)
+	steps := flags.Int("steps", 1, "how many migrations down undoes")
+	_ = flags.Parse(args)
+
+	db, err := f.DB()
+	if err != nil {
+		f.Logger().Error(err)
+		return 2
+	}
+
+	ctx := context.Background()
+	var result any
+	switch action {
+	case "up":
+		result, err = db.MigrateUp(ctx)
+	case "down":
+		result, err = db.MigrateDown(ctx, *steps)
+	case "status":
+		result, err = db.MigrationStatus(ctx)
+	case "seed":
+		err = db.Seed()
+		result = "seeded"
+	default:
+		f.Logger().Errorf("unknown migrate action %q, want up, down, status or seed", action)
+		return 2
+	}
+	if err != nil {
+		f.Logger().Error(err)
+		return 1
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	_ = out.Encode(result)
+	return 0
+}
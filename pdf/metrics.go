@@ -0,0 +1,55 @@
+package pdf
+
+// widths are the advance widths, in thousandths of the font size, of the
+// printable ASCII characters ' ' through '~' in the standard fonts, from
+// their Adobe font metrics.
+var widths = [][95]int{
+	Helvetica: {
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	},
+	HelveticaBold: {
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	},
+}
+
+// otherWidth is assumed for characters outside printable ASCII.
+const otherWidth = 556
+
+// Width returns how wide s is set in font at size, in points.
+func Width(font Font, size float64, s string) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			total += widths[font][r-' ']
+		} else {
+			total += otherWidth
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Fit shortens s with a trailing "..." until it is at most width points
+// wide set in font at size.
+func Fit(font Font, size float64, s string, width float64) string {
+	if Width(font, size, s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if short := string(runes) + "..."; Width(font, size, short) <= width {
+			return short
+		}
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package pdf
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// golden compares got with testdata/name, or rewrites it with -update.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the output, run go test -update to review the change", path)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc := New(A4Width, A4Height)
+	doc.SetTitle("Golden (test)")
+	first := doc.AddPage()
+	first.Fill(40, 760, 200, 20, 0.9)
+	first.Text(50, 766, HelveticaBold, 14, "Hello, PDF")
+	first.Line(50, 750, 300, 750, 0.5)
+	first.TextRight(300, 730, Helvetica, 10, "1,234.50")
+	first.Text(50, 710, Helvetica, 10, `back\slash (parens) café €`)
+	doc.AddPage().Text(50, 766, Helvetica, 10, "Page two")
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "document.pdf", buf.Bytes())
+}
+
+func TestWidth(t *testing.T) {
+	cases := []struct {
+		font Font
+		s    string
+		want float64
+	}{
+		{Helvetica, "", 0},
+		{Helvetica, "0.00", 10 * (556 + 278 + 556 + 556) / 1000.0},
+		{HelveticaBold, "Wi", 10 * (944 + 278) / 1000.0},
+	}
+	for _, c := range cases {
+		if got := Width(c.font, 10, c.s); got != c.want {
+			t.Errorf("%q: expect %v, got %v", c.s, c.want, got)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	if got := Fit(Helvetica, 10, "short", 100); got != "short" {
+		t.Error("expect short text kept, got", got)
+	}
+	got := Fit(Helvetica, 10, "a rather long memo that does not fit", 60)
+	if Width(Helvetica, 10, got) > 60 || got[len(got)-3:] != "..." {
+		t.Error("expect the text cut to fit, got", got)
+	}
+	if got = Fit(Helvetica, 10, "wide", 1); got != "" {
+		t.Error("expect nothing fits, got", got)
+	}
+}
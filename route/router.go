@@ -19,12 +19,12 @@ func checkDB(f factory.Factory) func(c *gin.Context) {
 	}
 }
 
+// checkRedis only warns when Redis is down: it caches and counts, and what
+// needs it falls back to the database.
 func checkRedis(f factory.Factory) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		if _, err := f.Redis(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "redis connection failed"})
-			c.Abort()
-			return
+			f.Logger().Warnw("redis connection failed", "path", c.Request.URL.Path, "error", err)
 		}
 		c.Next()
 	}
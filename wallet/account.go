@@ -0,0 +1,36 @@
+package wallet
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+)
+
+// A wallet is active when created. Frozen wallets can still receive money
+// but cannot be debited; closed wallets can do neither.
+const (
+	StatusActive = "active"
+	StatusFrozen = "frozen"
+	StatusClosed = "closed"
+)
+
+// checkStatus share-locks the user row until tx ends, so the wallet cannot
+// be frozen or closed while money is moving, and reports whether it may be
+// debited, or only credited.
+func checkStatus(tx *sql.Tx, username string, debit bool) error {
+	var status string
+	err := tx.QueryRow("SELECT status FROM users WHERE username = $1 FOR SHARE", username).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status == StatusClosed:
+		return errors.Wrapf(ErrAccountClosed, "%q", username)
+	case status == StatusFrozen && debit:
+		return errors.Wrapf(ErrAccountFrozen, "%q", username)
+	}
+	return nil
+}
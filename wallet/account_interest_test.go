@@ -0,0 +1,98 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func getInterest(t *testing.T, username string) wallet.AccountInterest {
+	resp := call(http.MethodGet, "/accounts/"+username+"/interest", "")
+	if resp.Code != http.StatusOK {
+		t.Fatal("expect interest, got", resp.Code, resp.Body.String())
+	}
+	var interest wallet.AccountInterest
+	_ = json.Unmarshal(resp.Body.Bytes(), &interest)
+	return interest
+}
+
+func TestInterestCatchUpAndPayout(t *testing.T) {
+	ctx := context.Background()
+	svc := wallet.NewService(f)
+	username := holdUser(t, "1000")
+
+	// the deposit was made 10 days ago and interest never ran since
+	db, _ := f.DB()
+	_, err := db.Exec(`UPDATE journal_entries SET created_at = created_at - INTERVAL '10 days', completed_at = completed_at - INTERVAL '10 days'
+WHERE id IN (SELECT p.entry_id FROM postings p JOIN accounts a ON a.id = p.account_id JOIN users u ON u.id = a.user_id WHERE u.username = $1)`, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0.1 a day on 1000
+	resp := call(http.MethodPut, "/accounts/"+username+"/interest", `{"rate":3.65}`)
+	if resp.Code != http.StatusOK {
+		t.Fatal("expect rate set, got", resp.Code, resp.Body.String())
+	}
+
+	now := time.Now().UTC()
+	yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+	for run := 0; run < 2; run++ {
+		if _, err = svc.AccrueInterest(ctx, now); err != nil {
+			t.Fatal(err)
+		}
+		interest := getInterest(t, username)
+		if !interest.Accrued.Equal(wallet.MustParseMoney("1")) || interest.AccruedThrough == nil || *interest.AccruedThrough != yesterday {
+			t.Fatalf("run %d: expect 10 days accrued through %s, got %+v", run, yesterday, interest)
+		}
+	}
+
+	previous := f.Config().Interest.Payout
+	f.Config().Interest.Payout = "@daily"
+	defer func() { f.Config().Interest.Payout = previous }()
+
+	for run := 0; run < 2; run++ {
+		if _, err = svc.PayInterest(ctx, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	interest := getInterest(t, username)
+	if !interest.Paid.Equal(wallet.MustParseMoney("1")) || !interest.Accrued.IsZero() {
+		t.Error("expect 1 paid once, got", interest)
+	}
+	balance, _ := svc.GetBalance(ctx, username, "USD")
+	if !balance.Balance.Equal(wallet.MustParseMoney("1001")) {
+		t.Error("expect interest credited, got", balance)
+	}
+
+	resp = call(http.MethodGet, fmt.Sprintf("/transactions/%s?type=interest", username), "")
+	var page wallet.HistoryPage
+	_ = json.Unmarshal(resp.Body.Bytes(), &page)
+	if len(page.Transactions) != 1 || !page.Transactions[0].Amount.Equal(wallet.MustParseMoney("1")) {
+		t.Error("expect one interest transaction, got", resp.Body.String())
+	}
+}
+
+func TestInterestRate(t *testing.T) {
+	previous := f.Config().Interest.Rates
+	f.Config().Interest.Rates = map[string]string{"usd": "2"}
+	defer func() { f.Config().Interest.Rates = previous }()
+	username := holdUser(t, "1")
+
+	if interest := getInterest(t, username); !interest.Rate.Equal(decimal.NewFromInt(2)) {
+		t.Error("expect the configured rate, got", interest.Rate)
+	}
+	for body, code := range map[string]int{`{"rate":-1}`: http.StatusBadRequest, `{"rate":101}`: http.StatusBadRequest, `{}`: http.StatusUnprocessableEntity} {
+		if resp := call(http.MethodPut, "/accounts/"+username+"/interest", body); resp.Code != code {
+			t.Error(body, "expect", code, "got", resp.Code, resp.Body.String())
+		}
+	}
+	if resp := call(http.MethodGet, "/accounts/notfound/interest", ""); resp.Code != http.StatusNotFound {
+		t.Error("expect 404, got", resp.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package wallet_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"testing"
+)
+
+func TestAccountLimits(t *testing.T) {
+	username := holdUser(t, "100")
+	path := fmt.Sprintf("/accounts/%s/limits", username)
+
+	resp := call(http.MethodPut, path, `{"daily":10,"daily_count":2}`)
+	if resp.Code != http.StatusOK {
+		t.Fatal("expect limits set, got", resp.Code, resp.Body.String())
+	}
+
+	resp = call(http.MethodPost, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":6}`, username))
+	if resp.Code != http.StatusOK {
+		t.Fatal("expect withdrawal within limits, got", resp.Code, resp.Body.String())
+	}
+
+	var rejected map[string]string
+	resp = call(http.MethodPost, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":6}`, username))
+	_ = json.Unmarshal(resp.Body.Bytes(), &rejected)
+	if resp.Code != http.StatusUnprocessableEntity || rejected["code"] != "limit_exceeded" ||
+		rejected["limit"] != "daily" || rejected["remaining"] != "4" {
+		t.Error("expect daily limit exceeded with 4 remaining, got", resp.Code, resp.Body.String())
+	}
+
+	// transfers count against the same limits
+	resp = call(http.MethodPost, "/transfer", fmt.Sprintf(`{"from":%q,"to":"user1","amount":4}`, username))
+	if resp.Code != http.StatusOK {
+		t.Fatal("expect transfer within limits, got", resp.Code, resp.Body.String())
+	}
+	resp = call(http.MethodPost, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":1}`, username))
+	_ = json.Unmarshal(resp.Body.Bytes(), &rejected)
+	if resp.Code != http.StatusUnprocessableEntity || rejected["code"] != "limit_exceeded" {
+		t.Error("expect a daily limit exceeded, got", resp.Code, resp.Body.String())
+	}
+
+	resp = call(http.MethodGet, path, "")
+	var limits wallet.AccountLimits
+	_ = json.Unmarshal(resp.Body.Bytes(), &limits)
+	if resp.Code != http.StatusOK || !limits.Used.Daily.Equal(wallet.MustParseMoney("10")) || limits.Used.DailyCount != 2 {
+		t.Error("expect 10 used in 2 outflows, got", resp.Code, resp.Body.String())
+	}
+	if limits.Remaining.Daily == nil || !limits.Remaining.Daily.IsZero() || limits.Remaining.DailyCount == nil || *limits.Remaining.DailyCount != 0 {
+		t.Error("expect nothing remaining today, got", resp.Body.String())
+	}
+
+	if resp := call(http.MethodGet, "/accounts/notfound/limits", ""); resp.Code != http.StatusNotFound {
+		t.Error("expect 404, got", resp.Code)
+	}
+}
+
+func TestAccountLimitsCaptureAndFail(t *testing.T) {
+	username := holdUser(t, "100")
+	path := fmt.Sprintf("/accounts/%s/limits", username)
+
+	if resp := call(http.MethodPut, path, `{"daily":10}`); resp.Code != http.StatusOK {
+		t.Fatal("expect limits set, got", resp.Code, resp.Body.String())
+	}
+
+	// a capture is an outflow
+	hold := placeHold(t, username, "20")
+	resp := call(http.MethodPost, fmt.Sprintf("/holds/%d/capture", hold.ID), `{"amount":8}`)
+	if resp.Code != http.StatusOK {
+		t.Fatal("expect capture within limits, got", resp.Code, resp.Body.String())
+	}
+	hold = placeHold(t, username, "20")
+	resp = call(http.MethodPost, fmt.Sprintf("/holds/%d/capture", hold.ID), `{"amount":3}`)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect the capture over the daily limit refused, got", resp.Code, resp.Body.String())
+	}
+	call(http.MethodPost, fmt.Sprintf("/holds/%d/void", hold.ID), "")
+
+	// a failed withdrawal no longer counts
+	receipt := pendingMovement(t, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":2,"pending":true}`, username))
+	if resp := call(http.MethodPost, fmt.Sprintf("/transactions/%d/fail", receipt.TransactionID), ""); resp.Code != http.StatusOK {
+		t.Fatal("expect the withdrawal failed, got", resp.Code, resp.Body.String())
+	}
+
+	resp = call(http.MethodGet, path, "")
+	var limits wallet.AccountLimits
+	_ = json.Unmarshal(resp.Body.Bytes(), &limits)
+	if resp.Code != http.StatusOK || !limits.Used.Daily.Equal(wallet.MustParseMoney("8")) || limits.Used.DailyCount != 1 {
+		t.Error("expect 8 used in 1 outflow, got", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,69 @@
+package wallet_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func call(method, path, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	return resp
+}
+
+func TestAccountLifecycle(t *testing.T) {
+	username := fmt.Sprintf("acct%d", time.Now().UnixNano())
+	money := fmt.Sprintf(`{"username":%q,"amount":5}`, username)
+
+	steps := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/accounts", fmt.Sprintf(`{"username":%q}`, username), http.StatusCreated},
+		{http.MethodPost, "/accounts", fmt.Sprintf(`{"username":%q}`, username), http.StatusConflict},
+		{http.MethodGet, "/accounts/" + username, "", http.StatusOK},
+		{http.MethodPost, "/deposit", money, http.StatusOK},
+		{http.MethodPost, "/accounts/" + username + "/freeze", "", http.StatusOK},
+		{http.MethodPost, "/accounts/" + username + "/freeze", "", http.StatusConflict},
+		// frozen wallets take deposits but refuse debits
+		{http.MethodPost, "/deposit", money, http.StatusOK},
+		{http.MethodPost, "/withdraw", money, http.StatusConflict},
+		{http.MethodPost, "/transfer", fmt.Sprintf(`{"from":%q,"to":"user1","amount":1}`, username), http.StatusConflict},
+		{http.MethodPost, "/accounts/" + username + "/unfreeze", "", http.StatusOK},
+		{http.MethodPost, "/accounts/" + username + "/close", "", http.StatusConflict},
+		{http.MethodPost, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":10}`, username), http.StatusOK},
+		{http.MethodPost, "/accounts/" + username + "/close", "", http.StatusOK},
+		{http.MethodPost, "/deposit", money, http.StatusConflict},
+		{http.MethodPost, "/transfer", fmt.Sprintf(`{"from":"user1","to":%q,"amount":1}`, username), http.StatusConflict},
+		{http.MethodPost, "/accounts/" + username + "/unfreeze", "", http.StatusConflict},
+	}
+
+	for i, step := range steps {
+		resp := call(step.method, step.path, step.body)
+		if resp.Code != step.code {
+			t.Errorf("step %d %s %s: expect %d, got %d %s", i, step.method, step.path, step.code, resp.Code, resp.Body.String())
+		}
+	}
+}
+
+func TestAccountNotFound(t *testing.T) {
+	for _, path := range []string{"/accounts/notfound", "/accounts/notfound/freeze"} {
+		method := http.MethodGet
+		if strings.HasSuffix(path, "freeze") {
+			method = http.MethodPost
+		}
+		if resp := call(method, path, ""); resp.Code != http.StatusNotFound {
+			t.Error(path, "expect 404, got", resp.Code)
+		}
+	}
+
+	resp := call(http.MethodPost, "/deposit", `{"username":"notfound","amount":1}`)
+	if resp.Code != http.StatusNotFound {
+		t.Error("expect 404, got", resp.Code)
+	}
+}
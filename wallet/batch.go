@@ -0,0 +1,208 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// A batch is atomic unless asked to be best effort: then transfers that
+// cannot be made are reported and the rest still go through.
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+// BatchTransferRequest lists transfers to make in one go. From is the payer
+// of every transfer that does not name its own.
+type BatchTransferRequest struct {
+	From      string            `json:"from" binding:"omitempty,username"`
+	Mode      string            `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	Transfers []TransferRequest `json:"transfers" binding:"required,min=1,max=1000,dive"`
+}
+
+// UnmarshalJSON fills in the batch payer before the transfers are validated.
+func (r *BatchTransferRequest) UnmarshalJSON(b []byte) error {
+	type plain BatchTransferRequest
+	if err := json.Unmarshal(b, (*plain)(r)); err != nil {
+		return err
+	}
+	for i := range r.Transfers {
+		if r.Transfers[i].From == "" {
+			r.Transfers[i].From = r.From
+		}
+	}
+	return nil
+}
+
+// BatchResult is the outcome of the transfer at Index of a batch: the
+// transfer made, or why it was not.
+type BatchResult struct {
+	Index    int       `json:"index"`
+	Transfer *Transfer `json:"transfer,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Code     string    `json:"code,omitempty"`
+}
+
+// BatchTransfer is the outcome of a batch, transfer by transfer.
+type BatchTransfer struct {
+	Mode      string        `json:"mode"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+func (b *BatchTransfer) fail(i int, err error) {
+	_, code, _ := domainError(err)
+	b.Results[i] = BatchResult{Index: i, Error: err.Error(), Code: code}
+	b.Failed++
+}
+
+// lockParties locks the users and accounts of a batch up front, by id, so
+// batches paying the same users in a different order cannot deadlock.
+func lockParties(tx *sql.Tx, legs []leg) error {
+	var usernames []string
+	for _, l := range legs {
+		usernames = append(usernames, l.From, l.To)
+	}
+
+	_, err := tx.Exec("SELECT 1 FROM users WHERE username = ANY($1) ORDER BY id FOR SHARE", pq.Array(usernames))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`SELECT 1 FROM accounts a
+JOIN users u ON u.id = a.user_id
+WHERE u.username = ANY($1)
+ORDER BY a.id
+FOR UPDATE OF a`, pq.Array(usernames))
+	return err
+}
+
+// BatchTransfer makes all transfers of req in one database transaction.
+// In atomic mode the first transfer that cannot be made fails the batch,
+// wrapped with its index. In best effort mode it is reported in its result
+// and the batch goes on; only errors that are not domain errors fail it.
+func (s Service) BatchTransfer(ctx context.Context, req BatchTransferRequest, hook beforeCommit) (BatchTransfer, error) {
+	mode := req.Mode
+	if mode == "" {
+		mode = BatchAtomic
+	}
+
+	// check every transfer and price the conversions before locking anything
+	legs := make([]leg, 0, len(req.Transfers))
+	index := make([]int, 0, len(req.Transfers))
+	rejected := map[int]error{}
+	for i, r := range req.Transfers {
+		l, err := s.prepare(ctx, r)
+		if err != nil && mode == BatchAtomic {
+			return BatchTransfer{}, errors.WithMessagef(err, "transfers[%d]", i)
+		}
+		if err != nil {
+			rejected[i] = err
+			continue
+		}
+		legs = append(legs, l)
+		index = append(index, i)
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return BatchTransfer{}, err
+	}
+
+	var tx *sql.Tx
+
+	defer func() {
+		if err != nil && tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var result BatchTransfer
+	var keys []string
+	lim := s.limiter(ctx)
+	defer func() {
+		if err != nil {
+			lim.undo()
+		}
+	}()
+
+	steps := []func(){
+		func() { tx, err = db.BeginTx(ctx, nil) },
+		func() {
+			result = BatchTransfer{Mode: mode, Results: make([]BatchResult, len(req.Transfers))}
+			keys = nil
+			for i, rejection := range rejected {
+				result.fail(i, rejection)
+			}
+		},
+		func() { err = lockParties(tx, legs) },
+		func() {
+			for n, l := range legs {
+				i := index[n]
+				if err = postLeg(tx, lim, mode, l, i, &result); err != nil {
+					return
+				}
+				if result.Results[i].Transfer != nil {
+					keys = append(keys, l.keys()...)
+				}
+			}
+		},
+		func() { err = hook(tx, result) },
+		func() { err = tx.Commit() },
+	}
+
+	run := func() error {
+		for _, step := range steps {
+			if step(); err != nil {
+				break
+			}
+		}
+		return err
+	}
+	rollback := func() {
+		_ = tx.Rollback()
+		lim.undo()
+	}
+	if err = s.retry("batch transfer", run, rollback); err != nil {
+		return BatchTransfer{}, err
+	}
+
+	s.forget(ctx, keys...)
+
+	return result, nil
+}
+
+// postLeg posts the transfer at index i of a batch and records its result.
+// In best effort mode a leg failing for a domain reason is rolled back to
+// a savepoint, so the legs before it stand.
+func postLeg(tx *sql.Tx, lim *limiter, mode string, l leg, i int, result *BatchTransfer) error {
+	if mode == BatchAtomic {
+		transfer, err := l.post(tx, lim)
+		if err != nil {
+			return errors.WithMessagef(err, "transfers[%d]", i)
+		}
+		result.Results[i] = BatchResult{Index: i, Transfer: &transfer}
+		result.Succeeded++
+		return nil
+	}
+
+	if _, err := tx.Exec("SAVEPOINT batch_leg"); err != nil {
+		return err
+	}
+	transfer, err := l.post(tx, lim)
+	if _, _, ok := domainError(err); ok {
+		result.fail(i, err)
+		_, err = tx.Exec("ROLLBACK TO SAVEPOINT batch_leg")
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	result.Results[i] = BatchResult{Index: i, Transfer: &transfer}
+	result.Succeeded++
+	_, err = tx.Exec("RELEASE SAVEPOINT batch_leg")
+	return err
+}
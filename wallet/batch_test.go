@@ -0,0 +1,80 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// batchBody makes a fresh payee for every user3 in body.
+func batchBody(body string) string {
+	payee := fmt.Sprintf("batch%d", time.Now().UnixNano())
+	call(http.MethodPost, "/accounts", fmt.Sprintf(`{"username":%q}`, payee))
+	return strings.ReplaceAll(body, "user3", payee)
+}
+
+func TestBatchTransferAtomic(t *testing.T) {
+	svc := wallet.NewService(f)
+	call(http.MethodPost, "/deposit", `{"username":"user1","amount":10}`)
+	before, _ := svc.GetBalance(context.Background(), "user1", "USD")
+
+	resp := call(http.MethodPost, "/transfers/batch", batchBody(`{"from":"user1","transfers":[{"to":"user2","amount":1},{"to":"user3","amount":2,"memo":"june"}]}`))
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+	var batch wallet.BatchTransfer
+	_ = json.Unmarshal(resp.Body.Bytes(), &batch)
+	if batch.Mode != wallet.BatchAtomic || batch.Succeeded != 2 || batch.Failed != 0 || batch.Results[1].Transfer.Memo != "june" {
+		t.Error("unexpected batch", resp.Body.String())
+	}
+	after, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !before.Balance.Sub(after.Balance).Equal(wallet.MustParseMoney("3")) {
+		t.Error("the batch should debit 3", before, after)
+	}
+
+	// the second leg cannot be paid, so neither is
+	resp = call(http.MethodPost, "/transfers/batch", batchBody(`{"from":"user1","transfers":[{"to":"user2","amount":1},{"to":"user3","amount":1000000}]}`))
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect 422, got", resp.Code, resp.Body.String())
+	}
+	unchanged, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !unchanged.Balance.Equal(after.Balance) {
+		t.Error("a failed batch must not move money", after, unchanged)
+	}
+}
+
+func TestBatchTransferBestEffort(t *testing.T) {
+	svc := wallet.NewService(f)
+	call(http.MethodPost, "/deposit", `{"username":"user1","amount":5}`)
+	before, _ := svc.GetBalance(context.Background(), "user1", "USD")
+
+	resp := call(http.MethodPost, "/transfers/batch", batchBody(`{"mode":"best_effort","from":"user1","transfers":[
+		{"to":"user2","amount":1},
+		{"to":"user3","amount":1000000},
+		{"to":"notfound","amount":1},
+		{"to":"user3","amount":2}]}`))
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+	var batch wallet.BatchTransfer
+	_ = json.Unmarshal(resp.Body.Bytes(), &batch)
+	if batch.Succeeded != 2 || batch.Failed != 2 {
+		t.Fatal("expect 2 of 4 transfers made", resp.Body.String())
+	}
+	for i, code := range []string{"", "insufficient_funds", "account_not_found", ""} {
+		r := batch.Results[i]
+		if r.Index != i || r.Code != code || (code == "") != (r.Transfer != nil) {
+			t.Error(i, "unexpected result", r)
+		}
+	}
+
+	after, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !before.Balance.Sub(after.Balance).Equal(wallet.MustParseMoney("3")) {
+		t.Error("only the transfers made should debit", before, after)
+	}
+}
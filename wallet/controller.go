@@ -1,9 +1,13 @@
 package wallet
 
 import (
-	"database/sql"
+	"context"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type Controller struct {
@@ -12,6 +16,7 @@ type Controller struct {
 }
 
 func NewController(f dependency) *Controller {
+	registerValidators()
 	return &Controller{
 		factory: f,
 		service: NewService(f),
@@ -19,255 +24,603 @@ func NewController(f dependency) *Controller {
 }
 
 type Request struct {
-	Username string
-	Amount   float64
+	Username string `json:"username" binding:"required,username"`
+	Amount   Money  `json:"amount" binding:"money"`
+	Currency string `json:"currency" binding:"omitempty,currency"`
+	// Pending records the movement as pending, to be settled or failed
+	// later through /transactions/:id/settle and /transactions/:id/fail.
+	Pending bool `json:"pending"`
+}
+
+func (r Request) status() string {
+	if r.Pending {
+		return TransactionPending
+	}
+	return TransactionCompleted
 }
 
 func (c Controller) Deposit(ctx *gin.Context) {
 	var req Request
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
+
+	receipt, err := c.service.Deposit(ctx, req, remember(ctx, http.StatusOK))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, receipt)
+}
+
+func (c Controller) Withdraw(ctx *gin.Context) {
+	var req Request
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
+
+	receipt, err := c.service.Withdraw(ctx, req, remember(ctx, http.StatusOK))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, receipt)
+}
+
+type TransferRequest struct {
+	From     string `json:"from" binding:"required,username"`
+	To       string `json:"to" binding:"required,username"`
+	Amount   Money  `json:"amount" binding:"money"`
+	Currency string `json:"currency" binding:"omitempty,currency"`
+	// ToCurrency is the currency the receiver is credited in. It defaults to
+	// Currency; any other value asks for a conversion at the rate locked by
+	// QuoteID (see POST /fx/quotes).
+	ToCurrency string `json:"to_currency" binding:"omitempty,currency"`
+	QuoteID    string `json:"quote_id"`
+	// Memo is shown to both users; Reference is the caller's own id for
+	// the transfer.
+	Memo      string `json:"memo" binding:"omitempty,max=140"`
+	Reference string `json:"reference" binding:"omitempty,max=64"`
+}
+
+func (c Controller) Transfer(ctx *gin.Context) {
+	var req TransferRequest
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
+
+	transfer, err := c.service.Transfer(ctx, req, remember(ctx, http.StatusOK))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, transfer)
+}
+
+// BatchTransfer makes a list of transfers in one go and reports on each.
+func (c Controller) BatchTransfer(ctx *gin.Context) {
+	var req BatchTransferRequest
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
+
+	batch, err := c.service.BatchTransfer(ctx, req, remember(ctx, http.StatusOK))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, batch)
+}
+
+// GetTransfer returns a transfer with both its legs.
+func (c Controller) GetTransfer(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrTransferNotFound)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	transfer, err := c.service.GetTransfer(ctx, id)
+	if c.handleError(ctx, err) {
 		return
 	}
 
-	username := req.Username
-	amount := req.Amount
+	ctx.JSON(http.StatusOK, transfer)
+}
 
-	db, _ := c.factory.DB()
+// GetBalance returns every balance of a user, or only the one selected by the
+// currency query parameter. With an at query parameter (RFC 3339) it returns
+// the ledger balances at that time instead.
+func (c Controller) GetBalance(ctx *gin.Context) {
+	username := ctx.Param("username")
 
-	var tx *sql.Tx
-	var err error
+	if at, ok := ctx.GetQuery("at"); ok {
+		c.getBalanceAt(ctx, username, at)
+		return
+	}
 
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
+	if code, ok := ctx.GetQuery("currency"); ok {
+		currency, err := ParseCurrency(code)
+		if c.handleError(ctx, err) {
+			return
 		}
-	}()
-
-	steps := []func(){
-		func() {
-			tx, err = db.Begin()
-		},
-		func() {
-			_, err = tx.Exec("UPDATE users SET balance = balance + $1 WHERE username = $2", amount, username)
-		},
-		func() {
-			err = c.logTransaction(tx, username, amount, "deposit")
-		},
-		func() {
-			err = tx.Commit()
-		},
-	}
-
-	for _, step := range steps {
-		step()
+
+		balance, err := c.service.GetBalance(ctx, username, currency.Code)
 		if c.handleError(ctx, err) {
 			return
 		}
+
+		ctx.JSON(http.StatusOK, balance)
+		return
+	}
+
+	balances, err := c.service.GetBalances(ctx, username)
+	if c.handleError(ctx, err) {
+		return
 	}
 
-	rdb, _ := c.factory.Redis()
-	rdb.Del(ctx, username)
+	ctx.JSON(http.StatusOK, gin.H{"balances": balances})
+}
 
-	balance, err := c.service.GetBalance(ctx, username)
+func (c Controller) getBalanceAt(ctx *gin.Context, username, at string) {
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		c.handleError(ctx, errors.Wrapf(ErrInvalidRequest, "at %q is not an RFC 3339 time", at))
+		return
+	}
+
+	if code, ok := ctx.GetQuery("currency"); ok {
+		currency, err := ParseCurrency(code)
+		if c.handleError(ctx, err) {
+			return
+		}
+
+		balance, err := c.service.GetBalanceAt(ctx, username, currency.Code, t)
+		if c.handleError(ctx, err) {
+			return
+		}
+
+		ctx.JSON(http.StatusOK, balance)
+		return
+	}
+
+	balances, err := c.service.GetBalancesAt(ctx, username, t)
 	if c.handleError(ctx, err) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"balance": balance})
+	ctx.JSON(http.StatusOK, gin.H{"balances": balances})
 }
 
-func (c Controller) Withdraw(ctx *gin.Context) {
-	var req Request
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// GetTransactionHistory returns a page of a user's transactions, selected
+// and ordered by the query parameters of HistoryQuery.
+func (c Controller) GetTransactionHistory(ctx *gin.Context) {
+	var q HistoryQuery
+	if c.handleError(ctx, bindQuery(ctx, &q)) {
 		return
 	}
 
-	balance, err := c.service.GetBalance(ctx, req.Username)
+	page, err := c.service.GetTransactions(ctx, ctx.Param("username"), q)
 	if c.handleError(ctx, err) {
 		return
 	}
-	if balance < req.Amount {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "insufficient balance"})
+
+	ctx.JSON(http.StatusOK, page)
+}
+
+// statementMediaTypes are the media types a statement can be negotiated
+// with through the Accept header, the first being the default.
+var statementMediaTypes = []string{"text/csv", "application/x-ndjson", "application/jsonl", "application/x-ofx", "application/pdf"}
+
+var statementMediaFormats = map[string]string{
+	"text/csv":             "csv",
+	"application/x-ndjson": "jsonl",
+	"application/jsonl":    "jsonl",
+	"application/x-ofx":    "ofx",
+	"application/pdf":      "pdf",
+}
+
+// GetStatement streams the statement of a user for a period, in the format
+// of the format query parameter, or else the one the Accept header prefers.
+func (c Controller) GetStatement(ctx *gin.Context) {
+	var q StatementQuery
+	if c.handleError(ctx, bindQuery(ctx, &q)) {
+		return
+	}
+	if q.Format == "" {
+		q.Format = statementMediaFormats[ctx.NegotiateFormat(statementMediaTypes...)]
+		if q.Format == "" {
+			c.handleError(ctx, errors.Wrapf(ErrNotAcceptable, "Accept %q", ctx.GetHeader("Accept")))
+			return
+		}
+	}
+
+	username := ctx.Param("username")
+	w := statementResponse{StatementWriter: NewStatementWriter(q.Format, ctx.Writer), ctx: ctx, format: q.Format}
+	err := c.service.WriteStatement(ctx, username, q, w)
+	if err != nil && ctx.Writer.Written() {
+		// the statement is partly sent, too late to answer with an error
+		c.factory.Logger().Errorw("statement failed", "path", ctx.Request.URL.Path, "error", err)
+		ctx.Abort()
 		return
 	}
+	if err != nil {
+		// nothing left the buffers yet: answer with the error instead
+		ctx.Writer.Header().Del("Content-Type")
+		ctx.Writer.Header().Del("Content-Disposition")
+	}
+	c.handleError(ctx, err)
+}
+
+// statementResponse sends the response headers once the statement begins.
+type statementResponse struct {
+	StatementWriter
+	ctx    *gin.Context
+	format string
+}
 
-	var tx *sql.Tx
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
+func (w statementResponse) Begin(head Statement) error {
+	filename := fmt.Sprintf("statement-%s-%s-%s-%s.%s", head.Username, head.Currency.Code, head.From.Format("20060102"), head.To.Format("20060102"), w.format)
+	w.ctx.Header("Content-Type", StatementFormats[w.format])
+	w.ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.ctx.Status(http.StatusOK)
+	return w.StatementWriter.Begin(head)
+}
+
+// pathID parses the :id path parameter; anything but a positive number is
+// notFound.
+func pathID(ctx *gin.Context, notFound error) (int, error) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, errors.Wrapf(notFound, "%q", ctx.Param("id"))
+	}
+	return id, nil
+}
+
+// finishTransaction returns a handler settling or failing a pending
+// transaction.
+func (c Controller) finishTransaction(finish func(context.Context, int) (Movement, error)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := pathID(ctx, ErrTransactionNotFound)
+		if c.handleError(ctx, err) {
+			return
 		}
-	}()
-
-	db, _ := c.factory.DB()
-
-	steps := []func(){
-		func() {
-			tx, err = db.Begin()
-		},
-		func() {
-			_, err = db.Exec("UPDATE users SET balance = balance - $1 WHERE username = $2", req.Amount, req.Username)
-		},
-		func() {
-			err = c.logTransaction(tx, req.Username, -req.Amount, "withdraw")
-		},
-		func() {
-			err = tx.Commit()
-		},
-	}
-
-	for _, step := range steps {
-		step()
+
+		movement, err := finish(ctx, id)
 		if c.handleError(ctx, err) {
 			return
 		}
+
+		ctx.JSON(http.StatusOK, movement)
 	}
+}
 
-	rdb, _ := c.factory.Redis()
+// ReverseTransaction refunds a completed transaction, in full or in part.
+func (c Controller) ReverseTransaction(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrTransactionNotFound)
+	if c.handleError(ctx, err) {
+		return
+	}
 
-	rdb.Del(ctx, req.Username)
+	var req ReverseRequest
+	// an empty body refunds all that is left
+	if ctx.Request.ContentLength != 0 && c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
 
-	ctx.JSON(http.StatusOK, gin.H{"balance": balance - req.Amount})
+	reversal, err := c.service.Reverse(ctx, id, req, remember(ctx, http.StatusCreated))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, reversal)
 }
 
-type TransferRequest struct {
-	From   string  `json:"from"`
-	To     string  `json:"to"`
-	Amount float64 `json:"amount"`
+// CreateSchedule sets up a standing order.
+func (c Controller) CreateSchedule(ctx *gin.Context) {
+	var req ScheduleRequest
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
+
+	schedule, err := c.service.CreateSchedule(ctx, req)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, schedule)
 }
 
-func (c Controller) Transfer(ctx *gin.Context) {
-	var req TransferRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+func (c Controller) GetSchedule(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrScheduleNotFound)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	schedule, err := c.service.GetSchedule(ctx, id)
+	if c.handleError(ctx, err) {
 		return
 	}
 
-	balance, err := c.service.GetBalance(ctx, req.From)
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+// GetSchedules lists the schedules a user pays.
+func (c Controller) GetSchedules(ctx *gin.Context) {
+	schedules, err := c.service.GetSchedules(ctx, ctx.Param("username"))
 	if c.handleError(ctx, err) {
 		return
 	}
 
-	if balance < req.Amount {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "insufficient balance"})
+	ctx.JSON(http.StatusOK, schedules)
+}
+
+// UpdateSchedule changes the amount or memo of a schedule, or pauses and
+// resumes it.
+func (c Controller) UpdateSchedule(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrScheduleNotFound)
+	if c.handleError(ctx, err) {
 		return
 	}
 
-	var tx *sql.Tx
+	var upd ScheduleUpdate
+	if c.handleError(ctx, bind(ctx, &upd)) {
+		return
+	}
 
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
-		}
-	}()
-
-	steps := []func(){
-		// start a transaction
-		func() {
-			db, _ := c.factory.DB()
-			tx, err = db.Begin()
-		},
-		// lock sender and receiver balance
-		func() {
-			_, err = tx.Exec("SELECT balance FROM users WHERE username = $1 OR username = $2 FOR UPDATE", req.From, req.To)
-		},
-		// withdraw from sender
-		func() {
-			_, err = tx.Exec("UPDATE users SET balance = balance - $1 WHERE username = $2", req.Amount, req.From)
-		},
-		// deposit to receiver
-		func() {
-			_, err = tx.Exec("UPDATE users SET balance = balance + $1 WHERE username = $2", req.Amount, req.To)
-		},
-		// log transactions for both users
-		func() { err = c.logTransaction(tx, req.From, -req.Amount, "transfer") },
-		func() { err = c.logTransaction(tx, req.To, req.Amount, "transfer") },
-		func() { err = tx.Commit() },
-	}
-
-	for _, step := range steps {
-		step()
-		if c.handleError(ctx, err) {
-			return
-		}
+	schedule, err := c.service.UpdateSchedule(ctx, id, upd)
+	if c.handleError(ctx, err) {
+		return
 	}
 
-	rdb, _ := c.factory.Redis()
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+func (c Controller) CancelSchedule(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrScheduleNotFound)
+	if c.handleError(ctx, err) {
+		return
+	}
 
-	// del cache for both users
-	rdb.Del(ctx, req.From, req.To)
+	schedule, err := c.service.CancelSchedule(ctx, id)
+	if c.handleError(ctx, err) {
+		return
+	}
 
-	ctx.Status(http.StatusOK)
+	ctx.JSON(http.StatusOK, schedule)
 }
 
-func (c Controller) GetBalance(ctx *gin.Context) {
-	username := ctx.Param("username")
+// GetScheduleRuns lists every attempt at paying a schedule.
+func (c Controller) GetScheduleRuns(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrScheduleNotFound)
+	if c.handleError(ctx, err) {
+		return
+	}
 
-	balance, err := c.service.GetBalance(ctx, username)
+	runs, err := c.service.GetScheduleRuns(ctx, id)
 	if c.handleError(ctx, err) {
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"balance": balance})
+	ctx.JSON(http.StatusOK, runs)
 }
 
-func (c Controller) GetTransactionHistory(ctx *gin.Context) {
-	username := ctx.Param("username")
+// GetLimits returns the outflow limits of a user's account in the currency
+// query parameter, and what is left of them.
+func (c Controller) GetLimits(ctx *gin.Context) {
+	limits, err := c.service.GetLimits(ctx, ctx.Param("username"), ctx.Query("currency"))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, limits)
+}
 
-	db, _ := c.factory.DB()
+// SetLimits overrides the configured limits of a user's account.
+func (c Controller) SetLimits(ctx *gin.Context) {
+	var req LimitsRequest
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
 
-	rows, err := db.Query("SELECT id, user_id, amount, transaction_type, created_at FROM transactions WHERE user_id = (SELECT id FROM users WHERE username = $1)", username)
+	limits, err := c.service.SetLimits(ctx, ctx.Param("username"), req)
 	if c.handleError(ctx, err) {
 		return
 	}
-	defer rows.Close()
 
-	var transactions []Transaction
-	for rows.Next() {
-		var transaction Transaction
-		if err = rows.Scan(&transaction.ID, &transaction.UserID, &transaction.Amount, &transaction.TransactionType, &transaction.CreatedAt); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		transactions = append(transactions, transaction)
+	ctx.JSON(http.StatusOK, limits)
+}
+
+// GetInterest returns the interest rate of a user's account in the currency
+// query parameter, and what it accrued and was paid.
+func (c Controller) GetInterest(ctx *gin.Context) {
+	interest, err := c.service.GetInterest(ctx, ctx.Param("username"), ctx.Query("currency"))
+	if c.handleError(ctx, err) {
+		return
 	}
 
-	ctx.JSON(http.StatusOK, transactions)
+	ctx.JSON(http.StatusOK, interest)
 }
 
-func (c Controller) logTransaction(tx *sql.Tx, username string, amount float64, transactionType string) error {
-	logger := c.factory.Logger()
+// SetInterestRate overrides the configured interest rate of a user's account.
+func (c Controller) SetInterestRate(ctx *gin.Context) {
+	var req InterestRequest
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
 
-	var userID int
-	err := tx.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&userID)
-	if err != nil {
-		logger.Error("error fetching user ID:", err)
-		return err
+	interest, err := c.service.SetInterestRate(ctx, ctx.Param("username"), req)
+	if c.handleError(ctx, err) {
+		return
 	}
 
-	_, err = tx.Exec("INSERT INTO transactions (user_id, amount, transaction_type) VALUES ($1, $2, $3)", userID, amount, transactionType)
-	if err != nil {
-		logger.Error("error logging transaction:", err)
-		return err
+	ctx.JSON(http.StatusOK, interest)
+}
+
+// QuoteFee returns the fee a withdrawal or transfer would be charged, from
+// the query parameters of FeeQuery.
+func (c Controller) QuoteFee(ctx *gin.Context) {
+	var q FeeQuery
+	if c.handleError(ctx, bindQuery(ctx, &q)) {
+		return
 	}
-	return nil
+
+	quote, err := c.service.QuoteFee(q)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, quote)
 }
 
-func (c Controller) RegisterRoutes(router *gin.Engine) {
-	router.POST("/deposit", c.Deposit)
-	router.POST("/withdraw", c.Withdraw)
-	router.POST("/transfer", c.Transfer)
-	router.GET("/balance/:username", c.GetBalance)
-	router.GET("/transactions/:username", c.GetTransactionHistory)
+// CreateQuote locks an exchange rate for a conversion to come.
+func (c Controller) CreateQuote(ctx *gin.Context) {
+	var req QuoteRequest
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
+
+	quote, err := c.service.Quote(ctx, req)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, quote)
 }
 
-func (c Controller) handleError(ctx *gin.Context, err error) bool {
-	l := c.factory.Logger()
-	if err != nil {
-		l.Error(err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return true
+// PlaceHold reserves funds for a later capture.
+func (c Controller) PlaceHold(ctx *gin.Context) {
+	var req HoldRequest
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
 	}
-	return false
+
+	hold, err := c.service.PlaceHold(ctx, req, remember(ctx, http.StatusCreated))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, hold)
+}
+
+func (c Controller) CaptureHold(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrHoldNotFound)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	var req CaptureRequest
+	// an empty body captures the whole hold
+	if ctx.Request.ContentLength != 0 && c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
+
+	hold, err := c.service.CaptureHold(ctx, id, req, remember(ctx, http.StatusOK))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, hold)
+}
+
+func (c Controller) VoidHold(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrHoldNotFound)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	hold, err := c.service.VoidHold(ctx, id)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, hold)
+}
+
+func (c Controller) GetHold(ctx *gin.Context) {
+	id, err := pathID(ctx, ErrHoldNotFound)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	hold, err := c.service.GetHold(ctx, id)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, hold)
+}
+
+type AccountRequest struct {
+	Username string `json:"username" binding:"required,username"`
+}
+
+// CreateAccount opens a wallet for a new username.
+func (c Controller) CreateAccount(ctx *gin.Context) {
+	var req AccountRequest
+	if c.handleError(ctx, bind(ctx, &req)) {
+		return
+	}
+
+	user, err := c.service.CreateUser(ctx, req.Username)
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, user)
+}
+
+func (c Controller) GetAccount(ctx *gin.Context) {
+	user, err := c.service.GetUser(ctx, ctx.Param("username"))
+	if c.handleError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+// setStatus returns a handler moving a wallet to status.
+func (c Controller) setStatus(status string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user, err := c.service.SetStatus(ctx, ctx.Param("username"), status)
+		if c.handleError(ctx, err) {
+			return
+		}
+
+		ctx.JSON(http.StatusOK, user)
+	}
+}
+
+func (c Controller) RegisterRoutes(router *gin.Engine) {
+	router.POST("/deposit", c.idempotent, c.Deposit)
+	router.POST("/withdraw", c.idempotent, c.Withdraw)
+	router.POST("/transfer", c.idempotent, c.Transfer)
+	router.POST("/transfers/batch", c.idempotent, c.BatchTransfer)
+	router.GET("/transfers/:id", c.GetTransfer)
+	router.GET("/fees/quote", c.QuoteFee)
+	router.POST("/fx/quotes", c.CreateQuote)
+	router.GET("/balance/:username", c.GetBalance)
+	router.GET("/transactions/:username", c.GetTransactionHistory)
+	router.POST("/transactions/:id/settle", c.finishTransaction(c.service.Settle))
+	router.POST("/transactions/:id/fail", c.finishTransaction(c.service.Fail))
+	router.POST("/transactions/:id/reverse", c.idempotent, c.ReverseTransaction)
+	router.POST("/holds", c.idempotent, c.PlaceHold)
+	router.GET("/holds/:id", c.GetHold)
+	router.POST("/holds/:id/capture", c.idempotent, c.CaptureHold)
+	router.POST("/holds/:id/void", c.VoidHold)
+	router.POST("/schedules", c.CreateSchedule)
+	router.GET("/schedules/:id", c.GetSchedule)
+	router.PATCH("/schedules/:id", c.UpdateSchedule)
+	router.DELETE("/schedules/:id", c.CancelSchedule)
+	router.GET("/schedules/:id/runs", c.GetScheduleRuns)
+	router.POST("/accounts", c.CreateAccount)
+	router.GET("/accounts/:username", c.GetAccount)
+	router.POST("/accounts/:username/freeze", c.setStatus(StatusFrozen))
+	router.POST("/accounts/:username/unfreeze", c.setStatus(StatusActive))
+	router.POST("/accounts/:username/close", c.setStatus(StatusClosed))
+	router.GET("/accounts/:username/schedules", c.GetSchedules)
+	router.GET("/accounts/:username/limits", c.GetLimits)
+	router.PUT("/accounts/:username/limits", c.SetLimits)
+	router.GET("/accounts/:username/interest", c.GetInterest)
+	router.GET("/accounts/:username/statement", c.GetStatement)
+	router.PUT("/accounts/:username/interest", c.SetInterestRate)
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/bitmyth/walletserivce/config"
 	"github.com/bitmyth/walletserivce/factory"
+	"github.com/bitmyth/walletserivce/fx"
 	"github.com/bitmyth/walletserivce/route"
 	"github.com/bitmyth/walletserivce/wallet"
 	"github.com/bitmyth/walletserivce/wallet/fixtures"
@@ -48,7 +49,7 @@ func TestMain(m *testing.M) {
 }
 
 func Test_Deposit(t *testing.T) {
-	req := wallet.Request{Username: "user1", Amount: 0.123456}
+	req := wallet.Request{Username: "user1", Amount: wallet.MustParseMoney("0.12")}
 	marshal, _ := json.Marshal(req)
 	body := strings.NewReader(string(marshal))
 	request := httptest.NewRequest(http.MethodPost, "/deposit", body)
@@ -61,6 +62,52 @@ func Test_Deposit(t *testing.T) {
 	t.Log(string(respBody))
 }
 
+func Test_DepositCurrency(t *testing.T) {
+	req := wallet.Request{Username: "user1", Amount: wallet.MustParseMoney("5.50"), Currency: "eur"}
+	marshal, _ := json.Marshal(req)
+	body := strings.NewReader(string(marshal))
+	request := httptest.NewRequest(http.MethodPost, "/deposit", body)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	respBody, _ := io.ReadAll(resp.Result().Body)
+	if resp.Code != http.StatusOK {
+		t.Error("code is not 200")
+	}
+	t.Log(string(respBody))
+
+	svc := wallet.NewService(f)
+	balance, err := svc.GetBalance(context.Background(), "user1", "EUR")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if balance.Balance.LessThan(wallet.MustParseMoney("5.50")) {
+		t.Error("EUR balance is wrong", balance)
+	}
+}
+
+func Test_DepositUnknownCurrency(t *testing.T) {
+	body := strings.NewReader(`{"username":"user1","amount":1,"currency":"XXX"}`)
+	request := httptest.NewRequest(http.MethodPost, "/deposit", body)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect unprocessable entity response")
+	}
+}
+
+func Test_DepositTooPrecise(t *testing.T) {
+	body := strings.NewReader(`{"username":"user1","amount":0.123456}`)
+	request := httptest.NewRequest(http.MethodPost, "/deposit", body)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	respBody, _ := io.ReadAll(resp.Result().Body)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect unprocessable entity response")
+	}
+	t.Log(string(respBody))
+}
+
 func Test_DepositBadRequest(t *testing.T) {
 	body := strings.NewReader(`{a:"2"}`)
 	request := httptest.NewRequest(http.MethodPost, "/deposit", body)
@@ -86,7 +133,7 @@ func Test_DepositDbError(t *testing.T) {
 }
 
 func Test_Withdraw(t *testing.T) {
-	req := wallet.Request{Username: "user1", Amount: 1.123456}
+	req := wallet.Request{Username: "user1", Amount: wallet.MustParseMoney("1.12")}
 	marshal, _ := json.Marshal(req)
 	body := strings.NewReader(string(marshal))
 	request := httptest.NewRequest(http.MethodPost, "/withdraw", body)
@@ -99,6 +146,61 @@ func Test_Withdraw(t *testing.T) {
 	t.Log(string(respBody))
 }
 
+func TestWithdrawConcurrent(t *testing.T) {
+	d, _ := f.DB()
+	_, err := d.Exec("INSERT INTO users (username) VALUES ('hammer') ON CONFLICT (username) DO NOTHING")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	body := strings.NewReader(`{"username":"hammer","amount":10}`)
+	request := httptest.NewRequest(http.MethodPost, "/deposit", body)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusOK {
+		t.Error("code is not 200", resp.Body.String())
+		return
+	}
+
+	balance := func() wallet.Money {
+		var b wallet.Money
+		_ = d.QueryRow("SELECT a.balance FROM accounts a JOIN users u ON u.id = a.user_id WHERE u.username = 'hammer' AND a.currency = 'USD'").Scan(&b)
+		return b
+	}
+	before := balance()
+
+	var mu sync.Mutex
+	succeeded := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request := httptest.NewRequest(http.MethodPost, "/withdraw", strings.NewReader(`{"username":"hammer","amount":1}`))
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, request)
+			if resp.Code == http.StatusOK {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else if resp.Code != http.StatusUnprocessableEntity {
+				t.Error("unexpected response", resp.Code, resp.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+
+	after := balance()
+	t.Log(before, after, succeeded)
+	if after.IsNegative() {
+		t.Error("balance went negative", after)
+	}
+	if !before.Sub(after).Equal(wallet.MustParseMoney(fmt.Sprint(succeeded))) {
+		t.Error("balance does not match successful withdrawals", before, after, succeeded)
+	}
+}
+
 func Test_WithdrawBadRequest(t *testing.T) {
 	body := strings.NewReader(`{a:"2"}`)
 	request := httptest.NewRequest(http.MethodPost, "/withdraw", body)
@@ -110,15 +212,15 @@ func Test_WithdrawBadRequest(t *testing.T) {
 }
 
 func Test_WithdrawMoreThanBalance(t *testing.T) {
-	req := wallet.Request{Username: "user1", Amount: 1000}
+	req := wallet.Request{Username: "user1", Amount: wallet.MustParseMoney("1000")}
 	marshal, _ := json.Marshal(req)
 	body := strings.NewReader(string(marshal))
 	request := httptest.NewRequest(http.MethodPost, "/withdraw", body)
 	resp := httptest.NewRecorder()
 	r.ServeHTTP(resp, request)
 	respBody, _ := io.ReadAll(resp.Result().Body)
-	if resp.Code != http.StatusBadRequest {
-		t.Error("expect bad request response")
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect unprocessable entity response")
 	}
 	t.Log(string(respBody))
 }
@@ -127,7 +229,7 @@ func Test_Transfer(t *testing.T) {
 	req := wallet.TransferRequest{
 		From:   "user1",
 		To:     "user2",
-		Amount: 1.123456,
+		Amount: wallet.MustParseMoney("1.12"),
 	}
 	marshal, _ := json.Marshal(req)
 	body := strings.NewReader(string(marshal))
@@ -141,32 +243,34 @@ func Test_Transfer(t *testing.T) {
 
 func TestTransferConcurrent(t *testing.T) {
 	a2b := func(group *sync.WaitGroup) {
-		req := wallet.TransferRequest{From: "user1", To: "user2", Amount: 0.12}
+		req := wallet.TransferRequest{From: "user1", To: "user2", Amount: wallet.MustParseMoney("0.12")}
 		marshal, _ := json.Marshal(req)
 		body := strings.NewReader(string(marshal))
 		request := httptest.NewRequest(http.MethodPost, "/transfer", body)
 		resp := httptest.NewRecorder()
 		r.ServeHTTP(resp, request)
 		respBody, _ := io.ReadAll(resp.Result().Body)
-		t.Log(resp.Code)
-		t.Log(string(respBody))
+		if resp.Code != http.StatusOK {
+			t.Error("crossing transfer failed", resp.Code, string(respBody))
+		}
 		group.Done()
 	}
 	b2a := func(group *sync.WaitGroup) {
-		req := wallet.TransferRequest{From: "user2", To: "user1", Amount: 0.12}
+		req := wallet.TransferRequest{From: "user2", To: "user1", Amount: wallet.MustParseMoney("0.12")}
 		marshal, _ := json.Marshal(req)
 		body := strings.NewReader(string(marshal))
 		request := httptest.NewRequest(http.MethodPost, "/transfer", body)
 		resp := httptest.NewRecorder()
 		r.ServeHTTP(resp, request)
 		respBody, _ := io.ReadAll(resp.Result().Body)
-		t.Log(resp.Code)
-		t.Log(string(respBody))
+		if resp.Code != http.StatusOK {
+			t.Error("crossing transfer failed", resp.Code, string(respBody))
+		}
 		group.Done()
 	}
 
 	svc := wallet.NewService(f)
-	balanceBefore, _ := svc.GetBalance(context.Background(), "user1")
+	balanceBefore, _ := svc.GetBalance(context.Background(), "user1", "USD")
 
 	var wg sync.WaitGroup
 	wg.Add(100)
@@ -176,9 +280,9 @@ func TestTransferConcurrent(t *testing.T) {
 	}
 	wg.Wait()
 
-	balanceAfter, _ := svc.GetBalance(context.Background(), "user1")
+	balanceAfter, _ := svc.GetBalance(context.Background(), "user1", "USD")
 	t.Log(balanceBefore, balanceAfter)
-	if balanceAfter != balanceBefore {
+	if !balanceAfter.Balance.Equal(balanceBefore.Balance) {
 		t.Error("balance should not change")
 	}
 }
@@ -193,11 +297,102 @@ func Test_TransferBadRequest(t *testing.T) {
 	}
 }
 
+func Test_TransferCrossCurrency(t *testing.T) {
+	req := wallet.TransferRequest{
+		From:       "user1",
+		To:         "user2",
+		Amount:     wallet.MustParseMoney("1"),
+		Currency:   "USD",
+		ToCurrency: "EUR",
+	}
+	marshal, _ := json.Marshal(req)
+	body := strings.NewReader(string(marshal))
+	request := httptest.NewRequest(http.MethodPost, "/transfer", body)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	respBody, _ := io.ReadAll(resp.Result().Body)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect unprocessable entity response")
+	}
+	t.Log(string(respBody))
+}
+
+func Test_TransferConversion(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/fx/quotes", strings.NewReader(`{"from":"USD","to":"EUR"}`))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusCreated {
+		t.Error("expect quote created, got", resp.Code, resp.Body.String())
+		return
+	}
+	var quote fx.Quote
+	_ = json.Unmarshal(resp.Body.Bytes(), &quote)
+
+	svc := wallet.NewService(f)
+	before, _ := svc.GetBalance(context.Background(), "user2", "EUR")
+
+	req := wallet.TransferRequest{
+		From:       "user1",
+		To:         "user2",
+		Amount:     wallet.MustParseMoney("10"),
+		Currency:   "USD",
+		ToCurrency: "EUR",
+		QuoteID:    quote.ID,
+	}
+	marshal, _ := json.Marshal(req)
+	request = httptest.NewRequest(http.MethodPost, "/transfer", strings.NewReader(string(marshal)))
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusOK {
+		t.Error("code is not 200", resp.Body.String())
+		return
+	}
+
+	eur, _ := wallet.LookupCurrency("EUR")
+	after, _ := svc.GetBalance(context.Background(), "user2", "EUR")
+	if !after.Balance.Sub(before.Balance).Equal(req.Amount.Convert(quote.Rate, eur)) {
+		t.Error("unexpected EUR credit", before, after)
+	}
+}
+
+func Test_CreateQuote(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/fx/quotes", strings.NewReader(`{"from":"USD","to":"EUR","amount":"10"}`))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusCreated {
+		t.Error("expect quote created, got", resp.Code, resp.Body.String())
+		return
+	}
+	var quote wallet.FXQuote
+	_ = json.Unmarshal(resp.Body.Bytes(), &quote)
+	eur, _ := wallet.ParseCurrency("EUR")
+	if quote.ID == "" || quote.Converted == nil || !quote.Converted.Equal(wallet.MustParseMoney("10").Convert(quote.Rate, eur)) {
+		t.Error("unexpected quote", resp.Body.String())
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/fx/quotes", strings.NewReader(`{"from":"USD","to":"XXX"}`))
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusUnprocessableEntity || !strings.Contains(resp.Body.String(), "validation_failed") {
+		t.Error("expect validation failed, got", resp.Code, resp.Body.String())
+	}
+}
+
+func Test_TransferConversionQuoteNotFound(t *testing.T) {
+	body := strings.NewReader(`{"from":"user1","to":"user2","amount":1,"currency":"USD","to_currency":"EUR","quote_id":"notfound"}`)
+	request := httptest.NewRequest(http.MethodPost, "/transfer", body)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect unprocessable entity response")
+	}
+}
+
 func Test_TransferFailed(t *testing.T) {
 	req := wallet.TransferRequest{
 		From:   "user1",
 		To:     "user2",
-		Amount: 1000,
+		Amount: wallet.MustParseMoney("1000"),
 	}
 	marshal, _ := json.Marshal(req)
 	body := strings.NewReader(string(marshal))
@@ -205,8 +400,8 @@ func Test_TransferFailed(t *testing.T) {
 	resp := httptest.NewRecorder()
 	r.ServeHTTP(resp, request)
 	respBody, _ := io.ReadAll(resp.Result().Body)
-	if resp.Code != http.StatusBadRequest {
-		t.Error("expect bad request response")
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect unprocessable entity response")
 	}
 	t.Log(string(respBody))
 }
@@ -220,6 +415,65 @@ func Test_TransactionHistory(t *testing.T) {
 	t.Log(string(respBody))
 }
 
+func Test_TransactionHistoryPages(t *testing.T) {
+	seen := map[int]bool{}
+	path := "/transactions/user1?limit=2"
+	for pages := 0; pages < 5; pages++ {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		if resp.Code != http.StatusOK {
+			t.Fatal("code is not 200", resp.Body.String())
+		}
+
+		var page wallet.HistoryPage
+		_ = json.Unmarshal(resp.Body.Bytes(), &page)
+		if len(page.Transactions) > 2 {
+			t.Error("page exceeds limit", len(page.Transactions))
+		}
+		for i, tx := range page.Transactions {
+			if seen[tx.PostingID] {
+				t.Error("transaction on two pages", tx.PostingID)
+			}
+			seen[tx.PostingID] = true
+			if i > 0 && tx.PostingID > page.Transactions[i-1].PostingID {
+				t.Error("page is not newest first")
+			}
+		}
+		if page.NextCursor == "" {
+			return
+		}
+		path = "/transactions/user1?limit=2&cursor=" + page.NextCursor
+	}
+}
+
+func Test_TransactionHistoryFilters(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/transactions/user1?type=transfer&counterparty=user2&min_amount=0.01&order=asc", nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+
+	var page wallet.HistoryPage
+	_ = json.Unmarshal(resp.Body.Bytes(), &page)
+	for i, tx := range page.Transactions {
+		if tx.TransactionType != "transfer" {
+			t.Error("unexpected type", tx.TransactionType)
+		}
+		if i > 0 && tx.PostingID < page.Transactions[i-1].PostingID {
+			t.Error("page is not oldest first")
+		}
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/transactions/user1?cursor=bogus", nil)
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	if resp.Code != http.StatusBadRequest {
+		t.Error("expect bad request for a bogus cursor, got", resp.Code)
+	}
+}
+
 func Test_TransactionHistoryBadRequest(t *testing.T) {
 	request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/transactions/%s", "notfound"), nil)
 	resp := httptest.NewRecorder()
@@ -239,6 +493,19 @@ func Test_Balance(t *testing.T) {
 	t.Log(string(respBody))
 }
 
+func Test_BalanceOfCurrency(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/balance/%s?currency=usd", "user1"), nil)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	respBody, _ := io.ReadAll(resp.Result().Body)
+	if resp.Code != http.StatusOK {
+		t.Error("should be ok")
+	}
+	if !strings.Contains(string(respBody), `"currency":"USD"`) {
+		t.Error("unexpected body", string(respBody))
+	}
+}
+
 func TestNewController(t *testing.T) {
 	controller := wallet.NewController(f)
 	if controller == nil {
@@ -247,7 +514,7 @@ func TestNewController(t *testing.T) {
 }
 
 func BenchmarkWithdraw(b *testing.B) {
-	req := wallet.Request{Username: "user1", Amount: 0.003456}
+	req := wallet.Request{Username: "user1", Amount: wallet.MustParseMoney("0.01")}
 	marshal, _ := json.Marshal(req)
 
 	for i := 0; i < b.N; i++ {
@@ -263,7 +530,7 @@ func BenchmarkWithdraw(b *testing.B) {
 	}
 }
 func BenchmarkDeposit(b *testing.B) {
-	req := wallet.Request{Username: "user1", Amount: 0.123456}
+	req := wallet.Request{Username: "user1", Amount: wallet.MustParseMoney("0.12")}
 	marshal, _ := json.Marshal(req)
 
 	for i := 0; i < b.N; i++ {
@@ -282,7 +549,7 @@ func BenchmarkTransfer(b *testing.B) {
 	req := wallet.TransferRequest{
 		From:   "user1",
 		To:     "user2",
-		Amount: 0.000123456,
+		Amount: wallet.MustParseMoney("0.01"),
 	}
 	marshal, _ := json.Marshal(req)
 	for i := 0; i < b.N; i++ {
@@ -293,3 +560,71 @@ func BenchmarkTransfer(b *testing.B) {
 		_, _ = io.ReadAll(resp.Result().Body)
 	}
 }
+
+func Test_DomainErrors(t *testing.T) {
+	cases := []struct {
+		path, body string
+		code       int
+		errCode    string
+	}{
+		{"/deposit", `{"username":"user1","amount":"abc"}`, http.StatusBadRequest, "invalid_amount"},
+		{"/withdraw", `{"username":"notfound","amount":1}`, http.StatusNotFound, "account_not_found"},
+		{"/transfer", `{"from":"user1","to":"notfound","amount":1}`, http.StatusNotFound, "account_not_found"},
+		{"/transfer", `{"from":"user1","to":"user1","amount":1}`, http.StatusUnprocessableEntity, "validation_failed"},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+
+		var body struct {
+			Code string `json:"code"`
+		}
+		_ = json.Unmarshal(resp.Body.Bytes(), &body)
+		if resp.Code != c.code || body.Code != c.errCode {
+			t.Errorf("%s %s: expect %d %s, got %d %s", c.path, c.body, c.code, c.errCode, resp.Code, resp.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/balance/notfound", "/balance/notfound?currency=USD", "/transactions/notfound"} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+		if resp.Code != http.StatusNotFound {
+			t.Error(path, "expect 404, got", resp.Code, resp.Body.String())
+		}
+	}
+}
+
+func Test_ValidationFailed(t *testing.T) {
+	cases := []struct {
+		path, body, field, rule string
+	}{
+		{"/deposit", `{"username":"user1","amount":-1}`, "amount", "money"},
+		{"/deposit", `{"username":"user1","amount":1e30}`, "amount", "money"},
+		{"/withdraw", `{"username":"user1","amount":0}`, "amount", "money"},
+		{"/withdraw", `{"username":"","amount":1}`, "username", "required"},
+		{"/deposit", `{"username":"user 1","amount":1}`, "username", "username"},
+		{"/deposit", `{"username":"user1","amount":1,"currency":"XXX"}`, "currency", "currency"},
+		{"/transfer", `{"from":"user1","to":"user1","amount":1}`, "to", "nefield"},
+		{"/accounts", `{}`, "username", "required"},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, request)
+
+		var body struct {
+			Code   string              `json:"code"`
+			Fields []wallet.FieldError `json:"fields"`
+		}
+		_ = json.Unmarshal(resp.Body.Bytes(), &body)
+		if resp.Code != http.StatusUnprocessableEntity || body.Code != "validation_failed" {
+			t.Errorf("%s %s: expect 422 validation_failed, got %d %s", c.path, c.body, resp.Code, resp.Body.String())
+			continue
+		}
+		if len(body.Fields) != 1 || body.Fields[0].Field != c.field || body.Fields[0].Rule != c.rule {
+			t.Errorf("%s %s: expect %s failing %s, got %v", c.path, c.body, c.field, c.rule, body.Fields)
+		}
+	}
+}
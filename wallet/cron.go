@@ -0,0 +1,134 @@
+package wallet
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSpec is a parsed five-field cron expression, minute hour day-of-month
+// month day-of-week, evaluated in UTC. Each field holds the values it
+// matches as bits.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// a day matches either day field when both are restricted, as in cron
+	domAny, dowAny bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// parseCron parses expr: five fields of '*', numbers, ranges a-b and lists
+// of those, each optionally stepped with /n, or one of the descriptors
+// @yearly, @monthly, @weekly, @daily and @hourly. Sunday is 0 or 7.
+func parseCron(expr string) (cronSpec, error) {
+	if d, ok := cronDescriptors[strings.TrimSpace(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, errors.Errorf("cron %q needs 5 fields", expr)
+	}
+
+	var c cronSpec
+	var err error
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		if *b.bits, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return cronSpec{}, errors.Wrapf(err, "cron %q", expr)
+		}
+	}
+	// 7 is another Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		span, stepText, stepped := strings.Cut(item, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step %q", item)
+			}
+		}
+
+		lo, hi := min, max
+		if span != "*" {
+			from, to, ranged := strings.Cut(span, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, errors.Errorf("invalid value %q", item)
+			}
+			hi = lo
+			if ranged {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, errors.Errorf("invalid range %q", item)
+				}
+			} else if stepped {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.Errorf("%q is outside %d-%d", item, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t the expression matches, or the zero
+// time when it matches none in the next five years, e.g. "0 0 31 2 *".
+func (c cronSpec) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,77 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	valid := []string{"* * * * *", "0 0 1 * *", "*/15 9-17 * * 1-5", "0,30 * * * 7", "5/10 * * * *", "@monthly"}
+	for _, expr := range valid {
+		if _, err := parseCron(expr); err != nil {
+			t.Errorf("parseCron(%q): %v", expr, err)
+		}
+	}
+	invalid := []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@never"}
+	for _, expr := range invalid {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) should fail", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 20, 30, 0, time.UTC)
+	cases := map[string]time.Time{
+		"* * * * *":         time.Date(2024, time.January, 31, 10, 21, 0, 0, time.UTC),
+		"0 0 1 * *":         time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"*/15 9-17 * * 1-5": time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC),
+		"0 12 29 2 *":       time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC),
+		// Sunday, or the 1st: whichever comes first
+		"0 0 1 * 7":  time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 5 * 0":  time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC),
+		"@yearly":    time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 31 2 *": {},
+	}
+	for expr, want := range cases {
+		c, err := parseCron(expr)
+		if err != nil {
+			t.Fatal(expr, err)
+		}
+		if got := c.next(from); !got.Equal(want) {
+			t.Errorf("next(%q) = %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestScheduleOccurrences(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	start := now.Add(-time.Hour)
+
+	due, _ := first(ScheduleRequest{Cron: "0 0 1 * *"}, now)
+	if want := time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC); !due.Equal(want) {
+		t.Error("monthly schedule: first", due, "want", want)
+	}
+	due, _ = first(ScheduleRequest{Cron: "0 12 * * *"}, now)
+	if !due.Equal(now) {
+		t.Error("a cron matching now is due now, got", due)
+	}
+	due, _ = first(ScheduleRequest{IntervalSeconds: 3600}, now)
+	if !due.Equal(now.Add(time.Hour)) {
+		t.Error("interval schedule: first", due)
+	}
+	due, _ = first(ScheduleRequest{StartAt: &start}, now)
+	if !due.Equal(start) {
+		t.Error("one-shot schedule: first", due)
+	}
+
+	hourly := schedule{Schedule: Schedule{IntervalSeconds: 3600}}
+	// the worker was down for a day: the missed occurrences are skipped
+	next, ok := hourly.following(now.Add(-24*time.Hour), now)
+	if !ok || !next.Equal(now.Add(time.Hour)) {
+		t.Error("interval schedule: following", next, ok)
+	}
+	if _, ok = (schedule{}).following(start, now); ok {
+		t.Error("a one-shot schedule has no following occurrence")
+	}
+}
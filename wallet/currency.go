@@ -0,0 +1,56 @@
+package wallet
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency. Exponent is the number of minor-unit
+// digits, e.g. 2 for USD (cents) and 0 for JPY.
+type Currency struct {
+	Code     string
+	Exponent int
+}
+
+var currencies = map[string]Currency{
+	"USD": {Code: "USD", Exponent: 2},
+	"EUR": {Code: "EUR", Exponent: 2},
+	"GBP": {Code: "GBP", Exponent: 2},
+	"CNY": {Code: "CNY", Exponent: 2},
+	"HKD": {Code: "HKD", Exponent: 2},
+	"SGD": {Code: "SGD", Exponent: 2},
+	"CHF": {Code: "CHF", Exponent: 2},
+	"JPY": {Code: "JPY", Exponent: 0},
+	"KRW": {Code: "KRW", Exponent: 0},
+	"KWD": {Code: "KWD", Exponent: 3},
+	"BHD": {Code: "BHD", Exponent: 3},
+}
+
+// DefaultCurrency is used when a request does not name a currency.
+var DefaultCurrency = currencies["USD"]
+
+func LookupCurrency(code string) (Currency, bool) {
+	c, ok := currencies[code]
+	return c, ok
+}
+
+// ParseCurrency resolves an ISO 4217 code case-insensitively. An empty code
+// means DefaultCurrency.
+func ParseCurrency(code string) (Currency, error) {
+	if code == "" {
+		return DefaultCurrency, nil
+	}
+	c, ok := LookupCurrency(strings.ToUpper(code))
+	if !ok {
+		return Currency{}, errors.Wrapf(ErrUnknownCurrency, "%q", code)
+	}
+	return c, nil
+}
+
+// Check reports whether m can be represented in minor units of c.
+func (c Currency) Check(m Money) error {
+	if m.Places() > c.Exponent {
+		return errors.Wrapf(ErrTooPrecise, "%s %s allows %d", m, c.Code, c.Exponent)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package wallet
+
+import (
+	"github.com/bitmyth/walletserivce/fx"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// Domain errors returned by the service. Callers match them with errors.Is;
+// they are usually wrapped with the offending username or value.
+var (
+	// fx errors surface through transfers and quotes
+	ErrNoRate        = fx.ErrNoRate
+	ErrQuoteNotFound = fx.ErrQuoteNotFound
+
+	ErrInvalidRequest      = errors.New("invalid request")
+	ErrInvalidMoney        = errors.New("invalid money amount")
+	ErrInvalidAmount       = errors.New("amount must be positive")
+	ErrTooPrecise          = errors.New("amount has more decimal places than the currency allows")
+	ErrUnknownCurrency     = errors.New("unknown currency")
+	ErrNotAcceptable       = errors.New("none of the accepted formats is available")
+	ErrSelfTransfer        = errors.New("cannot transfer to the same account")
+	ErrCrossCurrency       = errors.New("cross-currency transfer requires a conversion quote")
+	ErrQuoteMismatch       = errors.New("fx quote does not match the transfer currencies")
+	ErrTooSmall            = errors.New("amount is too small to convert")
+	ErrAccountNotFound     = errors.New("account not found")
+	ErrAccountExists       = errors.New("account already exists")
+	ErrAccountFrozen       = errors.New("account is frozen")
+	ErrAccountClosed       = errors.New("account is closed")
+	ErrNonZeroBalance      = errors.New("account balance is not zero")
+	ErrInvalidTransition   = errors.New("account status does not allow this change")
+	ErrInsufficientFunds   = errors.New("insufficient balance")
+	ErrLimitExceeded       = errors.New("limit exceeded")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrTransferNotFound    = errors.New("transfer not found")
+	ErrTransactionState    = errors.New("transaction status does not allow this change")
+	ErrNotReversible       = errors.New("transaction cannot be reversed")
+	ErrAlreadyReversed     = errors.New("transaction was already reversed")
+	ErrRefundExceeds       = errors.New("refund exceeds the refundable amount")
+	ErrHoldNotFound        = errors.New("hold not found")
+	ErrHoldClosed          = errors.New("hold is no longer active")
+	ErrCaptureExceedsHold  = errors.New("capture exceeds the held amount")
+	ErrScheduleNotFound    = errors.New("schedule not found")
+	ErrScheduleClosed      = errors.New("schedule is no longer active")
+	ErrConflict            = errors.New("request conflicts with a concurrent one, try again")
+	ErrUnbalanced          = errors.New("journal entry does not balance")
+	ErrIdempotencyInFlight = errors.New("a request with this Idempotency-Key is still in progress")
+	ErrIdempotencyMismatch = errors.New("Idempotency-Key was already used with a different request")
+	ErrPeriodTooLong       = errors.New("statement period is too long for this format")
+)
+
+// domainErrors gives every domain error an HTTP status and a stable code
+// clients can switch on. The first match wins.
+var domainErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	// the request itself is malformed
+	{ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{ErrInvalidMoney, http.StatusBadRequest, "invalid_amount"},
+	{ErrInvalidAmount, http.StatusBadRequest, "invalid_amount"},
+	{ErrTooPrecise, http.StatusBadRequest, "too_precise"},
+	{ErrUnknownCurrency, http.StatusBadRequest, "unknown_currency"},
+	{ErrNoRate, http.StatusBadRequest, "no_rate"},
+	{ErrNotAcceptable, http.StatusNotAcceptable, "not_acceptable"},
+	// it names something that does not exist
+	{ErrAccountNotFound, http.StatusNotFound, "account_not_found"},
+	{ErrTransactionNotFound, http.StatusNotFound, "transaction_not_found"},
+	{ErrTransferNotFound, http.StatusNotFound, "transfer_not_found"},
+	{ErrHoldNotFound, http.StatusNotFound, "hold_not_found"},
+	{ErrScheduleNotFound, http.StatusNotFound, "schedule_not_found"},
+	// it clashes with the current state of the wallet
+	{ErrAccountExists, http.StatusConflict, "account_exists"},
+	{ErrAccountFrozen, http.StatusConflict, "account_frozen"},
+	{ErrAccountClosed, http.StatusConflict, "account_closed"},
+	{ErrNonZeroBalance, http.StatusConflict, "non_zero_balance"},
+	{ErrInvalidTransition, http.StatusConflict, "invalid_transition"},
+	{ErrIdempotencyInFlight, http.StatusConflict, "idempotency_in_flight"},
+	{ErrTransactionState, http.StatusConflict, "invalid_transaction_state"},
+	{ErrNotReversible, http.StatusConflict, "not_reversible"},
+	{ErrAlreadyReversed, http.StatusConflict, "already_reversed"},
+	{ErrHoldClosed, http.StatusConflict, "hold_closed"},
+	{ErrScheduleClosed, http.StatusConflict, "schedule_closed"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	// it is well-formed but cannot be carried out
+	{ErrInsufficientFunds, http.StatusUnprocessableEntity, "insufficient_funds"},
+	{ErrLimitExceeded, http.StatusUnprocessableEntity, "limit_exceeded"},
+	{ErrSelfTransfer, http.StatusUnprocessableEntity, "self_transfer"},
+	{ErrCaptureExceedsHold, http.StatusUnprocessableEntity, "capture_exceeds_hold"},
+	{ErrRefundExceeds, http.StatusUnprocessableEntity, "refund_exceeds_refundable"},
+	{ErrCrossCurrency, http.StatusUnprocessableEntity, "quote_required"},
+	{ErrQuoteMismatch, http.StatusUnprocessableEntity, "quote_mismatch"},
+	{ErrQuoteNotFound, http.StatusUnprocessableEntity, "quote_not_found"},
+	{ErrTooSmall, http.StatusUnprocessableEntity, "too_small"},
+	{ErrIdempotencyMismatch, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{ErrPeriodTooLong, http.StatusUnprocessableEntity, "period_too_long"},
+}
+
+// errorResponse maps err to a status and JSON body. Domain errors keep their
+// message; anything else is internal and its details stay in the log.
+func errorResponse(err error) (int, gin.H) {
+	var invalid validator.ValidationErrors
+	if errors.As(err, &invalid) {
+		return http.StatusUnprocessableEntity, gin.H{"error": "request validation failed", "code": "validation_failed", "fields": fieldErrors(invalid)}
+	}
+	var limit *LimitError
+	if errors.As(err, &limit) {
+		return http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": "limit_exceeded", "limit": limit.Limit, "remaining": limit.Remaining}
+	}
+	if status, code, ok := domainError(err); ok {
+		return status, gin.H{"error": err.Error(), "code": code}
+	}
+	return http.StatusInternalServerError, gin.H{"error": "internal server error", "code": "internal"}
+}
+
+// domainError returns the status and code of err. It reports false unless
+// err is one of the domainErrors.
+func domainError(err error) (int, string, bool) {
+	for _, d := range domainErrors {
+		if errors.Is(err, d.err) {
+			return d.status, d.code, true
+		}
+	}
+	return 0, "", false
+}
+
+// handleError writes err as the response and aborts the request. It reports
+// whether there was an error.
+func (c Controller) handleError(ctx *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	status, body := errorResponse(err)
+	if status == http.StatusInternalServerError {
+		c.factory.Logger().Errorw("request failed", "path", ctx.Request.URL.Path, "error", err)
+	}
+	ctx.AbortWithStatusJSON(status, body)
+	return true
+}
@@ -0,0 +1,53 @@
+package wallet
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"net/http"
+	"testing"
+)
+
+func TestErrorResponse(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{errors.Wrapf(ErrAccountNotFound, "%q", "nobody"), http.StatusNotFound, "account_not_found"},
+		{errors.Wrap(ErrInsufficientFunds, "account 1"), http.StatusUnprocessableEntity, "insufficient_funds"},
+		{ErrAccountFrozen, http.StatusConflict, "account_frozen"},
+		{ErrInvalidAmount, http.StatusBadRequest, "invalid_amount"},
+		{ErrSelfTransfer, http.StatusUnprocessableEntity, "self_transfer"},
+		{ErrConflict, http.StatusConflict, "conflict"},
+		{ErrQuoteNotFound, http.StatusUnprocessableEntity, "quote_not_found"},
+		{errors.WithMessage(&LimitError{Limit: "daily"}, "transfers[1]"), http.StatusUnprocessableEntity, "limit_exceeded"},
+		{sql.ErrNoRows, http.StatusInternalServerError, "internal"},
+	}
+	for _, c := range cases {
+		status, body := errorResponse(c.err)
+		if status != c.status || body["code"] != c.code {
+			t.Errorf("errorResponse(%v) = %d %v, want %d %s", c.err, status, body["code"], c.status, c.code)
+		}
+	}
+}
+
+func TestErrorResponseHidesInternalErrors(t *testing.T) {
+	_, body := errorResponse(errors.New(`pq: relation "accounts" does not exist`))
+	if body["error"] != "internal server error" {
+		t.Error("internal error leaked:", body["error"])
+	}
+}
+
+func TestCheckAmount(t *testing.T) {
+	for _, amount := range []string{"0", "-1"} {
+		if _, err := checkAmount(MustParseMoney(amount), "USD"); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("checkAmount(%s) = %v, want ErrInvalidAmount", amount, err)
+		}
+	}
+	if _, err := checkAmount(MustParseMoney("1.001"), "USD"); !errors.Is(err, ErrTooPrecise) {
+		t.Error("expect ErrTooPrecise, got", err)
+	}
+	if c, err := checkAmount(MustParseMoney("1"), ""); err != nil || c != DefaultCurrency {
+		t.Error("expect default currency, got", c, err)
+	}
+}
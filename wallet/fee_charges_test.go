@@ -0,0 +1,110 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/config"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"testing"
+)
+
+// withFees charges fees for the rest of the test.
+func withFees(t *testing.T, fees map[string]map[string]config.FeeConfig) {
+	previous := f.Config().Fees
+	f.Config().Fees = fees
+	t.Cleanup(func() { f.Config().Fees = previous })
+}
+
+func TestFeeQuote(t *testing.T) {
+	withFees(t, map[string]map[string]config.FeeConfig{
+		"withdraw": {"usd": {Flat: "0.25", Percent: "1", Max: "10"}},
+	})
+
+	resp := call(http.MethodGet, "/fees/quote?type=withdraw&amount=100", "")
+	var quote wallet.FeeQuote
+	_ = json.Unmarshal(resp.Body.Bytes(), &quote)
+	if resp.Code != http.StatusOK || !quote.Fee.Equal(wallet.MustParseMoney("1.25")) || !quote.Total.Equal(wallet.MustParseMoney("101.25")) {
+		t.Error("expect a fee of 1.25, got", resp.Code, resp.Body.String())
+	}
+
+	// no schedule for transfers or EUR
+	for _, query := range []string{"type=transfer&amount=100", "type=withdraw&amount=100&currency=EUR"} {
+		resp = call(http.MethodGet, "/fees/quote?"+query, "")
+		_ = json.Unmarshal(resp.Body.Bytes(), &quote)
+		if resp.Code != http.StatusOK || !quote.Fee.IsZero() {
+			t.Error(query, "expect no fee, got", resp.Code, resp.Body.String())
+		}
+	}
+
+	for _, query := range []string{"type=deposit&amount=1", "type=withdraw", "type=withdraw&amount=1.001"} {
+		if resp = call(http.MethodGet, "/fees/quote?"+query, ""); resp.Code != http.StatusUnprocessableEntity {
+			t.Error(query, "expect 422, got", resp.Code, resp.Body.String())
+		}
+	}
+}
+
+func TestFeeCharged(t *testing.T) {
+	withFees(t, map[string]map[string]config.FeeConfig{
+		"withdraw": {"usd": {Flat: "1"}},
+		"transfer": {"usd": {Percent: "10"}},
+	})
+	username := holdUser(t, "20")
+
+	resp := call(http.MethodPost, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":5}`, username))
+	var receipt wallet.Receipt
+	_ = json.Unmarshal(resp.Body.Bytes(), &receipt)
+	if resp.Code != http.StatusOK || receipt.Fee == nil || !receipt.Fee.Equal(wallet.MustParseMoney("1")) || !receipt.Balance.Balance.Equal(wallet.MustParseMoney("14")) {
+		t.Fatal("expect 5 withdrawn and 1 charged, got", resp.Code, resp.Body.String())
+	}
+
+	resp = call(http.MethodPost, "/transfer", fmt.Sprintf(`{"from":%q,"to":"user1","amount":10}`, username))
+	var transfer wallet.Transfer
+	_ = json.Unmarshal(resp.Body.Bytes(), &transfer)
+	if resp.Code != http.StatusOK || !transfer.Fee.Equal(wallet.MustParseMoney("1")) {
+		t.Fatal("expect 1 charged on the transfer, got", resp.Code, resp.Body.String())
+	}
+
+	// the fee must be covered too: 3 left, 3 + 0.3 needed
+	resp = call(http.MethodPost, "/transfer", fmt.Sprintf(`{"from":%q,"to":"user1","amount":3}`, username))
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect insufficient funds, got", resp.Code, resp.Body.String())
+	}
+
+	resp = call(http.MethodGet, "/transactions/"+username+"?type=fee", "")
+	var page wallet.HistoryPage
+	_ = json.Unmarshal(resp.Body.Bytes(), &page)
+	if len(page.Transactions) != 2 {
+		t.Fatal("expect 2 fee lines, got", resp.Body.String())
+	}
+	for _, tx := range page.Transactions {
+		if tx.FeeFor == nil || !tx.Amount.Equal(wallet.MustParseMoney("-1")) {
+			t.Error("expect a fee of 1 linked to its movement, got", tx)
+		}
+	}
+	if *page.Transactions[0].FeeFor != transfer.TransactionID || *page.Transactions[1].FeeFor != receipt.TransactionID {
+		t.Error("expect fees for the transfer and the withdrawal, got", page.Transactions)
+	}
+}
+
+func TestFeeFollowsPendingWithdrawal(t *testing.T) {
+	withFees(t, map[string]map[string]config.FeeConfig{"withdraw": {"usd": {Flat: "1"}}})
+	username := holdUser(t, "10")
+
+	resp := call(http.MethodPost, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":5,"pending":true}`, username))
+	var receipt wallet.Receipt
+	_ = json.Unmarshal(resp.Body.Bytes(), &receipt)
+	if resp.Code != http.StatusOK || !receipt.Available.Equal(wallet.MustParseMoney("4")) {
+		t.Fatal("expect amount and fee reserved, got", resp.Code, resp.Body.String())
+	}
+
+	resp = call(http.MethodPost, fmt.Sprintf("/transactions/%d/fail", receipt.TransactionID), "")
+	if resp.Code != http.StatusOK {
+		t.Fatal("expect failed, got", resp.Code, resp.Body.String())
+	}
+	balance, _ := wallet.NewService(f).GetBalance(context.Background(), username, "USD")
+	if !balance.Available.Equal(wallet.MustParseMoney("10")) {
+		t.Error("expect the fee released with the withdrawal, got", balance)
+	}
+}
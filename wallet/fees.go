@@ -0,0 +1,170 @@
+package wallet
+
+import (
+	"database/sql"
+	"github.com/bitmyth/walletserivce/config"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// FeeQuery asks what a movement would be charged before it is made.
+type FeeQuery struct {
+	Type     string `form:"type" binding:"required,oneof=withdraw transfer capture"`
+	Amount   Money  `form:"amount" binding:"money"`
+	Currency string `form:"currency" binding:"omitempty,currency"`
+}
+
+// FeeQuote is the fee a movement of Amount is charged, and Total what it
+// debits from the payer.
+type FeeQuote struct {
+	Type     string `json:"type"`
+	Amount   Money  `json:"amount"`
+	Currency string `json:"currency"`
+	Fee      Money  `json:"fee"`
+	Total    Money  `json:"total"`
+}
+
+var hundred = decimal.NewFromInt(100)
+
+// feeTier prices the amounts up to upTo; a nil upTo covers them all.
+type feeTier struct {
+	upTo          *decimal.Decimal
+	flat, percent decimal.Decimal
+}
+
+// feeSchedule prices a movement: a flat part plus a percentage of its
+// amount, taken from the first tier covering the amount when there are
+// tiers, then kept within min and max.
+type feeSchedule struct {
+	feeTier
+	tiers    []feeTier
+	min, max *decimal.Decimal
+}
+
+func parseDecimal(text string) (*decimal.Decimal, error) {
+	if text == "" {
+		return nil, nil
+	}
+	d, err := decimal.NewFromString(text)
+	if err != nil || d.IsNegative() {
+		return nil, errors.Errorf("invalid amount %q", text)
+	}
+	return &d, nil
+}
+
+func parseFeeTier(upTo, flat, percent string) (feeTier, error) {
+	var t feeTier
+	var err error
+	if t.upTo, err = parseDecimal(upTo); err != nil {
+		return feeTier{}, err
+	}
+	for _, f := range []struct {
+		text string
+		dst  *decimal.Decimal
+	}{{flat, &t.flat}, {percent, &t.percent}} {
+		d, err := parseDecimal(f.text)
+		if err != nil {
+			return feeTier{}, err
+		}
+		if d != nil {
+			*f.dst = *d
+		}
+	}
+	return t, nil
+}
+
+func parseFeeSchedule(c config.FeeConfig) (feeSchedule, error) {
+	var f feeSchedule
+	var err error
+	if f.feeTier, err = parseFeeTier("", c.Flat, c.Percent); err != nil {
+		return feeSchedule{}, err
+	}
+	for _, t := range c.Tiers {
+		tier, err := parseFeeTier(t.UpTo, t.Flat, t.Percent)
+		if err != nil {
+			return feeSchedule{}, err
+		}
+		f.tiers = append(f.tiers, tier)
+	}
+	if f.min, err = parseDecimal(c.Min); err != nil {
+		return feeSchedule{}, err
+	}
+	if f.max, err = parseDecimal(c.Max); err != nil {
+		return feeSchedule{}, err
+	}
+	return f, nil
+}
+
+// fee prices amount, rounded to the minor unit of c.
+func (f feeSchedule) fee(amount Money, c Currency) Money {
+	price := f.feeTier
+	for _, t := range f.tiers {
+		if t.upTo == nil || amount.d.LessThanOrEqual(*t.upTo) {
+			price = t
+			break
+		}
+	}
+
+	fee := price.flat.Add(amount.d.Mul(price.percent).Div(hundred))
+	if f.min != nil && fee.LessThan(*f.min) {
+		fee = *f.min
+	}
+	if f.max != nil && fee.GreaterThan(*f.max) {
+		fee = *f.max
+	}
+	return Money{d: fee.Round(int32(c.Exponent))}
+}
+
+// fee returns the fee charged on a movement of type typ and amount in
+// currency: zero unless a fee schedule is configured for both.
+func (s Service) fee(typ string, amount Money, currency Currency) (Money, error) {
+	for t, schedules := range s.factory.Config().Fees {
+		// viper lowercases map keys
+		if !strings.EqualFold(t, typ) {
+			continue
+		}
+		for code, c := range schedules {
+			if !strings.EqualFold(code, currency.Code) {
+				continue
+			}
+			f, err := parseFeeSchedule(c)
+			if err != nil {
+				return Money{}, errors.Wrapf(err, "fees.%s.%s", t, code)
+			}
+			return f.fee(amount, currency), nil
+		}
+	}
+	return Money{}, nil
+}
+
+// QuoteFee prices a movement the way it would be charged if made now.
+func (s Service) QuoteFee(q FeeQuery) (FeeQuote, error) {
+	currency, err := checkAmount(q.Amount, q.Currency)
+	if err != nil {
+		return FeeQuote{}, err
+	}
+	fee, err := s.fee(q.Type, q.Amount, currency)
+	if err != nil {
+		return FeeQuote{}, err
+	}
+	return FeeQuote{Type: q.Type, Amount: q.Amount, Currency: currency.Code, Fee: fee, Total: q.Amount.Add(fee)}, nil
+}
+
+// chargeFee debits fee from the user account accountID to the fees account
+// with an entry of its own, linked to the entry forID it is charged for and
+// in the same status. A zero fee is not recorded.
+func chargeFee(tx *sql.Tx, forID, accountID int, fee Money, currency, status string) error {
+	if fee.IsZero() {
+		return nil
+	}
+	feesID, err := systemAccount(tx, SystemFees, currency)
+	if err != nil {
+		return err
+	}
+	_, err = post(tx, Entry{Type: "fee", Status: status, FeeFor: forID, Postings: []Posting{
+		{AccountID: accountID, Currency: currency, Amount: fee.Neg()},
+		{AccountID: feesID, Currency: currency, Amount: fee},
+	}})
+	return err
+}
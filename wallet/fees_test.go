@@ -0,0 +1,56 @@
+package wallet
+
+import (
+	"github.com/bitmyth/walletserivce/config"
+	"testing"
+)
+
+func TestFeeSchedule(t *testing.T) {
+	usd := currencies["USD"]
+	cases := []struct {
+		config config.FeeConfig
+		amount string
+		fee    string
+	}{
+		{config.FeeConfig{}, "100", "0"},
+		{config.FeeConfig{Flat: "0.25"}, "100", "0.25"},
+		{config.FeeConfig{Flat: "0.25", Percent: "1"}, "100", "1.25"},
+		// rounded to the cent
+		{config.FeeConfig{Percent: "1.5"}, "0.99", "0.01"},
+		{config.FeeConfig{Percent: "1", Min: "0.5"}, "10", "0.5"},
+		{config.FeeConfig{Percent: "1", Max: "10"}, "5000", "10"},
+	}
+	tiered := config.FeeConfig{
+		Tiers: []config.FeeTier{{UpTo: "100"}, {UpTo: "1000", Percent: "0.5"}, {Flat: "1", Percent: "0.25"}},
+		Max:   "20",
+	}
+	for amount, fee := range map[string]string{"50": "0", "100": "0", "100.01": "0.5", "1000": "5", "2000": "6", "100000": "20"} {
+		cases = append(cases, struct {
+			config config.FeeConfig
+			amount string
+			fee    string
+		}{tiered, amount, fee})
+	}
+
+	for _, c := range cases {
+		f, err := parseFeeSchedule(c.config)
+		if err != nil {
+			t.Fatal(c.config, err)
+		}
+		if fee := f.fee(MustParseMoney(c.amount), usd); !fee.Equal(MustParseMoney(c.fee)) {
+			t.Errorf("%+v on %s: expect %s, got %s", c.config, c.amount, c.fee, fee)
+		}
+	}
+
+	// JPY has no minor unit
+	f, _ := parseFeeSchedule(config.FeeConfig{Percent: "1"})
+	if fee := f.fee(MustParseMoney("250"), currencies["JPY"]); !fee.Equal(MustParseMoney("3")) {
+		t.Error("expect 3 JPY, got", fee)
+	}
+
+	for _, c := range []config.FeeConfig{{Flat: "x"}, {Percent: "-1"}, {Tiers: []config.FeeTier{{UpTo: "ten"}}}} {
+		if _, err := parseFeeSchedule(c); err == nil {
+			t.Errorf("%+v: expect an error", c)
+		}
+	}
+}
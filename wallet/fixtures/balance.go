@@ -1,6 +1,7 @@
 package fixtures
 
 import (
+	"context"
 	"github.com/bitmyth/walletserivce/db"
 	"log"
 )
@@ -14,18 +15,49 @@ func PreloadTestingData(f dependency) {
 	d, _ := f.DB()
 
 	_, _ = d.Exec("truncate table users CASCADE")
-	_, _ = d.Exec("truncate table transactions")
+	_, _ = d.Exec("truncate table journal_entries CASCADE")
 	// Prepare the SQL statement
-	insertSQL := `INSERT INTO users (username, balance) VALUES ($1, $2), ($3, $4)`
+	insertSQL := `INSERT INTO users (username) VALUES ($1), ($2)`
 
 	username1 := "user1"
-	balance1 := 100.0
+	balance1 := "100.00"
 	username2 := "user2"
-	balance2 := 100.0
+	balance2 := "100.00"
 
 	// Execute the SQL statement
-	_, err := d.Exec(insertSQL, username1, balance1, username2, balance2)
+	_, err := d.Exec(insertSQL, username1, username2)
 	if err != nil {
 		log.Fatal("error inserting rows:", err)
 	}
+
+	// every balance is explained by a deposit in the ledger
+	depositSQL := `WITH cash_in AS (
+    INSERT INTO accounts (system, currency) VALUES ('cash-in', 'USD')
+    ON CONFLICT (system, currency) DO UPDATE SET currency = EXCLUDED.currency
+    RETURNING id
+), account AS (
+    INSERT INTO accounts (user_id, currency, balance)
+    SELECT id, 'USD', $2::NUMERIC FROM users WHERE username = $1
+    RETURNING id
+), entry AS (
+    INSERT INTO journal_entries (entry_type) VALUES ('deposit') RETURNING id
+)
+INSERT INTO postings (entry_id, account_id, currency, amount)
+SELECT entry.id, account.id, 'USD', $2::NUMERIC FROM entry, account
+UNION ALL
+SELECT entry.id, cash_in.id, 'USD', -$2::NUMERIC FROM entry, cash_in`
+	for username, balance := range map[string]string{username1: balance1, username2: balance2} {
+		if _, err = d.Exec(depositSQL, username, balance); err != nil {
+			log.Fatal("error inserting rows:", err)
+		}
+	}
+
+	// drop balances cached by earlier runs
+	if r, err := f.Redis(); err == nil {
+		ctx := context.Background()
+		keys, _ := r.Keys(ctx, "balance:*").Result()
+		if len(keys) > 0 {
+			r.Del(ctx, keys...)
+		}
+	}
 }
@@ -0,0 +1,167 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryQuery selects a page of a user's transaction history. Pages are
+// ordered by posting id, which follows the order transactions were
+// recorded in, so a cursor stays valid while new transactions arrive.
+type HistoryQuery struct {
+	// Cursor is the next_cursor of the previous page; empty for the first.
+	Cursor string `form:"cursor"`
+	Limit  int    `form:"limit,default=20" binding:"min=1,max=100"`
+	// Order is desc (newest first) or asc.
+	Order    string `form:"order,default=desc" binding:"oneof=asc desc"`
+	Type     string `form:"type" binding:"omitempty,max=20"`
+	Status   string `form:"status" binding:"omitempty,oneof=pending completed failed reversed"`
+	Currency string `form:"currency" binding:"omitempty,currency"`
+	// MinAmount and MaxAmount bound the amount moved, whichever direction.
+	MinAmount *Money `form:"min_amount" binding:"omitempty,money"`
+	MaxAmount *Money `form:"max_amount" binding:"omitempty,money"`
+	// Since is inclusive, Until exclusive.
+	Since *time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until *time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	// Counterparty keeps transactions that moved money to or from this user.
+	Counterparty string `form:"counterparty" binding:"omitempty,username"`
+}
+
+// HistoryPage is one page of transactions. NextCursor is empty on the last page.
+type HistoryPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
+}
+
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		var id int
+		if id, err = strconv.Atoi(string(b)); err == nil && id > 0 {
+			return id, nil
+		}
+	}
+	return 0, errors.Wrapf(ErrInvalidRequest, "invalid cursor %q", cursor)
+}
+
+// historySQL builds the query for a page of q. It fetches one row more than
+// the page holds to learn whether another page follows.
+func historySQL(userID int, q HistoryQuery) (string, []any, error) {
+	where := []string{"a.user_id = $1"}
+	args := []any{userID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	order, cmp := "DESC", "<"
+	if q.Order == "asc" {
+		order, cmp = "ASC", ">"
+	}
+	if q.Cursor != "" {
+		id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		where = append(where, "p.id "+cmp+" "+arg(id))
+	}
+	if q.Type != "" {
+		where = append(where, "e.entry_type = "+arg(q.Type))
+	}
+	if q.Status != "" {
+		where = append(where, "e.status = "+arg(q.Status))
+	}
+	if q.Currency != "" {
+		where = append(where, "p.currency = "+arg(strings.ToUpper(q.Currency)))
+	}
+	if q.MinAmount != nil {
+		where = append(where, "ABS(p.amount) >= "+arg(*q.MinAmount))
+	}
+	if q.MaxAmount != nil {
+		where = append(where, "ABS(p.amount) <= "+arg(*q.MaxAmount))
+	}
+	if q.Since != nil {
+		where = append(where, "e.created_at >= "+arg(q.Since.UTC()))
+	}
+	if q.Until != nil {
+		where = append(where, "e.created_at < "+arg(q.Until.UTC()))
+	}
+	if q.Counterparty != "" {
+		where = append(where, `EXISTS (SELECT 1
+    FROM postings cp
+    JOIN accounts ca ON ca.id = cp.account_id
+    JOIN users cu ON cu.id = ca.user_id
+    WHERE cp.entry_id = p.entry_id AND ca.user_id <> a.user_id AND cu.username = `+arg(q.Counterparty)+`)`)
+	}
+
+	query := `SELECT p.entry_id, p.id, a.user_id, p.amount, p.currency, e.entry_type, e.rate, e.status, e.created_at, e.completed_at, e.failed_at, e.reversed_at,
+    e.reverses_id, (SELECT array_agg(r.id ORDER BY r.id) FROM journal_entries r WHERE r.reverses_id = e.id),
+    e.transfer_id, COALESCE(CASE WHEN t.from_user_id = a.user_id THEN tu.username ELSE fu.username END, ''), e.fee_for_id
+FROM postings p
+JOIN accounts a ON a.id = p.account_id
+JOIN journal_entries e ON e.id = p.entry_id
+LEFT JOIN transfers t ON t.id = e.transfer_id
+LEFT JOIN users fu ON fu.id = t.from_user_id
+LEFT JOIN users tu ON tu.id = t.to_user_id
+WHERE ` + strings.Join(where, " AND ") + `
+ORDER BY p.id ` + order + `
+LIMIT ` + arg(q.Limit+1)
+	return query, args, nil
+}
+
+// GetTransactions returns a page of the postings to all accounts of username.
+func (s Service) GetTransactions(ctx context.Context, username string, q HistoryQuery) (HistoryPage, error) {
+	d, err := s.factory.DB()
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	var userID int
+	err = d.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return HistoryPage{}, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	query, args, err := historySQL(userID, q)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	rows, err := d.QueryContext(ctx, query, args...)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	defer rows.Close()
+
+	page := HistoryPage{Transactions: []Transaction{}}
+	for rows.Next() {
+		var transaction Transaction
+		if err = rows.Scan(&transaction.ID, &transaction.PostingID, &transaction.UserID, &transaction.Amount, &transaction.Currency, &transaction.TransactionType, &transaction.Rate, &transaction.Status, &transaction.CreatedAt, &transaction.CompletedAt, &transaction.FailedAt, &transaction.ReversedAt, &transaction.ReversesID, pq.Array(&transaction.Reversals), &transaction.TransferID, &transaction.Counterparty, &transaction.FeeFor); err != nil {
+			return HistoryPage{}, err
+		}
+		page.Transactions = append(page.Transactions, transaction)
+	}
+	if err = rows.Err(); err != nil {
+		return HistoryPage{}, err
+	}
+
+	if len(page.Transactions) > q.Limit {
+		page.Transactions = page.Transactions[:q.Limit]
+		page.NextCursor = encodeCursor(page.Transactions[q.Limit-1].PostingID)
+	}
+	return page, nil
+}
@@ -0,0 +1,85 @@
+package wallet
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func bindHistory(query string) (HistoryQuery, error) {
+	registerValidators()
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/transactions/user1?"+query, nil)
+	var q HistoryQuery
+	return q, bindQuery(ctx, &q)
+}
+
+func TestBind_HistoryQuery(t *testing.T) {
+	q, err := bindHistory("")
+	if err != nil || q.Limit != 20 || q.Order != "desc" {
+		t.Error("unexpected defaults", q, err)
+	}
+
+	q, err = bindHistory("limit=5&order=asc&type=deposit&min_amount=1.5&max_amount=10&since=2024-01-01T00:00:00Z&counterparty=user2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.MinAmount == nil || !q.MinAmount.Equal(MustParseMoney("1.5")) || q.Since == nil || !q.Since.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("unexpected query", q)
+	}
+
+	for query, want := range map[string]string{
+		"limit=0":            "limit/min",
+		"limit=101":          "limit/max",
+		"order=up":           "order/oneof",
+		"min_amount=-1":      "min_amount/money",
+		"currency=XYZ":       "currency/currency",
+		"counterparty=a%20b": "counterparty/username",
+	} {
+		_, err := bindHistory(query)
+		if got := failed(err); got != want {
+			t.Errorf("%s failed %q, want %q", query, got, want)
+		}
+	}
+
+	if _, err = bindHistory("since=yesterday"); !errors.Is(err, ErrInvalidRequest) {
+		t.Error("expect ErrInvalidRequest, got", err)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	id, err := decodeCursor(encodeCursor(42))
+	if err != nil || id != 42 {
+		t.Error("cursor does not round-trip", id, err)
+	}
+	for _, cursor := range []string{"!!", encodeCursor(0), "YWJj"} {
+		if _, err := decodeCursor(cursor); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("decodeCursor(%q) = %v, want ErrInvalidRequest", cursor, err)
+		}
+	}
+}
+
+func TestHistorySQL(t *testing.T) {
+	min := MustParseMoney("1")
+	query, args, err := historySQL(7, HistoryQuery{Cursor: encodeCursor(100), Limit: 10, Order: "desc", Type: "transfer", MinAmount: &min, Counterparty: "user2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"a.user_id = $1", "p.id < $2", "e.entry_type = $3", "ABS(p.amount) >= $4", "cu.username = $5", "ORDER BY p.id DESC", "LIMIT $6"} {
+		if !strings.Contains(query, part) {
+			t.Errorf("query lacks %q:\n%s", part, query)
+		}
+	}
+	if len(args) != 6 || args[5] != 11 {
+		t.Error("unexpected args", args)
+	}
+
+	query, _, _ = historySQL(7, HistoryQuery{Cursor: encodeCursor(100), Limit: 10, Order: "asc"})
+	if !strings.Contains(query, "p.id > $2") || !strings.Contains(query, "ORDER BY p.id ASC") {
+		t.Error("ascending query is wrong:\n", query)
+	}
+}
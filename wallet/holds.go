@@ -0,0 +1,326 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// A hold is active until it is captured, voided or expires.
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+const (
+	// DefaultHoldTTL applies when holds.ttl is not configured.
+	DefaultHoldTTL = 7 * 24 * time.Hour
+	// DefaultSweepInterval applies when holds.sweepInterval is not configured.
+	DefaultSweepInterval = time.Minute
+)
+
+// HoldRequest reserves Amount of the user's balance.
+type HoldRequest struct {
+	Username string `json:"username" binding:"required,username"`
+	Amount   Money  `json:"amount" binding:"money"`
+	Currency string `json:"currency" binding:"omitempty,currency"`
+}
+
+// CaptureRequest debits Amount of a hold; the whole hold when Amount is not
+// given. The rest of the hold is released.
+type CaptureRequest struct {
+	Amount *Money `json:"amount" binding:"omitempty,money"`
+}
+
+const holdSQL = `SELECT h.id, u.username, a.currency, h.amount, h.captured, h.capture_entry_id, h.status, h.expires_at, h.created_at, h.closed_at
+FROM holds h
+JOIN accounts a ON a.id = h.account_id
+JOIN users u ON u.id = a.user_id
+WHERE h.id = $1`
+
+func scanHold(row *sql.Row) (Hold, error) {
+	var h Hold
+	err := row.Scan(&h.ID, &h.Username, &h.Currency, &h.Amount, &h.Captured, &h.TransactionID, &h.Status, &h.ExpiresAt, &h.CreatedAt, &h.ClosedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Hold{}, ErrHoldNotFound
+	}
+	return h, err
+}
+
+func (s Service) holdTTL() time.Duration {
+	if ttl := s.factory.Config().Holds.TTL; ttl > 0 {
+		return ttl
+	}
+	return DefaultHoldTTL
+}
+
+// PlaceHold reserves req.Amount of the user's available balance. No money
+// moves until the hold is captured.
+func (s Service) PlaceHold(ctx context.Context, req HoldRequest, hook beforeCommit) (Hold, error) {
+	currency, err := checkAmount(req.Amount, req.Currency)
+	if err != nil {
+		return Hold{}, err
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return Hold{}, err
+	}
+
+	var tx *sql.Tx
+
+	defer func() {
+		if err != nil && tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var accountID, holdID int
+	var hold Hold
+
+	steps := []func(){
+		func() { tx, err = db.BeginTx(ctx, nil) },
+		func() { err = checkStatus(tx, req.Username, true) },
+		func() { accountID, err = userAccount(tx, req.Username, currency.Code) },
+		// a hold is a debit that has not happened yet, so it needs the same
+		// funds check
+		func() { err = lockFunds(tx, accountID, req.Amount) },
+		func() {
+			err = tx.QueryRow("INSERT INTO holds (account_id, amount, expires_at) VALUES ($1, $2, CURRENT_TIMESTAMP + $3 * INTERVAL '1 second') RETURNING id", accountID, req.Amount, s.holdTTL().Seconds()).Scan(&holdID)
+		},
+		func() { hold, err = scanHold(tx.QueryRow(holdSQL, holdID)) },
+		func() { err = hook(tx, hold) },
+		func() { err = tx.Commit() },
+	}
+
+	for _, step := range steps {
+		if step(); err != nil {
+			return Hold{}, err
+		}
+	}
+
+	s.forget(ctx, BalanceKey(req.Username, currency.Code))
+
+	return hold, nil
+}
+
+// lockHold locks an active, unexpired hold until tx ends.
+func lockHold(tx *sql.Tx, id int) (Hold, int, error) {
+	var accountID int
+	var expired bool
+	err := tx.QueryRow("SELECT account_id, expires_at <= CURRENT_TIMESTAMP FROM holds WHERE id = $1 FOR UPDATE", id).Scan(&accountID, &expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Hold{}, 0, errors.Wrapf(ErrHoldNotFound, "%d", id)
+	}
+	if err != nil {
+		return Hold{}, 0, err
+	}
+
+	hold, err := scanHold(tx.QueryRow(holdSQL, id))
+	if err != nil {
+		return Hold{}, 0, err
+	}
+	if hold.Status != HoldActive {
+		return Hold{}, 0, errors.Wrapf(ErrHoldClosed, "hold %d is %s", id, hold.Status)
+	}
+	if expired {
+		return Hold{}, 0, errors.Wrapf(ErrHoldClosed, "hold %d expired", id)
+	}
+	return hold, accountID, nil
+}
+
+// CaptureHold debits req.Amount of hold id, or all of it, and releases the
+// rest. The capture is an outflow like a withdrawal: it counts against the
+// limits of the account and is charged the capture fee.
+func (s Service) CaptureHold(ctx context.Context, id int, req CaptureRequest, hook beforeCommit) (Hold, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return Hold{}, err
+	}
+
+	var tx *sql.Tx
+
+	defer func() {
+		if err != nil && tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var hold Hold
+	var accountID, cashOutID, entryID int
+	var amount, fee Money
+	var currency Currency
+	lim := s.limiter(ctx)
+	defer func() {
+		if err != nil {
+			lim.undo()
+		}
+	}()
+
+	steps := []func(){
+		func() { tx, err = db.BeginTx(ctx, nil) },
+		func() { hold, accountID, err = lockHold(tx, id) },
+		func() { err = checkStatus(tx, hold.Username, true) },
+		func() { currency, err = ParseCurrency(hold.Currency) },
+		func() {
+			amount = hold.Amount
+			if req.Amount == nil {
+				return
+			}
+			amount = *req.Amount
+			if hold.Amount.LessThan(amount) {
+				err = errors.Wrapf(ErrCaptureExceedsHold, "%s of %s", amount, hold.Amount)
+				return
+			}
+			err = currency.Check(amount)
+		},
+		func() { fee, err = s.fee("capture", amount, currency) },
+		// the hold reserved the amount, so the account can only lack the
+		// part of the fee the released rest of the hold does not cover
+		func() { err = lockFunds(tx, accountID, fee.Sub(hold.Amount.Sub(amount))) },
+		func() { err = lim.check(tx, accountID, hold.Currency, amount) },
+		func() { cashOutID, err = systemAccount(tx, SystemCashOut, hold.Currency) },
+		func() {
+			entryID, err = post(tx, Entry{Type: "capture", Postings: []Posting{
+				{AccountID: accountID, Currency: hold.Currency, Amount: amount.Neg()},
+				{AccountID: cashOutID, Currency: hold.Currency, Amount: amount},
+			}})
+		},
+		func() { err = chargeFee(tx, entryID, accountID, fee, hold.Currency, "") },
+		func() {
+			lim.count(accountID, amount)
+			_, err = tx.Exec("UPDATE holds SET status = $2, captured = $3, capture_entry_id = $4, closed_at = CURRENT_TIMESTAMP WHERE id = $1", id, HoldCaptured, amount, entryID)
+		},
+		func() { hold, err = scanHold(tx.QueryRow(holdSQL, id)) },
+		func() { err = hook(tx, hold) },
+		func() { err = tx.Commit() },
+	}
+
+	for _, step := range steps {
+		if step(); err != nil {
+			return Hold{}, err
+		}
+	}
+
+	s.forget(ctx, BalanceKey(hold.Username, hold.Currency))
+
+	return hold, nil
+}
+
+// VoidHold releases hold id without moving money.
+func (s Service) VoidHold(ctx context.Context, id int) (Hold, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return Hold{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Hold{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, _, err = lockHold(tx, id); err != nil {
+		return Hold{}, err
+	}
+	_, err = tx.Exec("UPDATE holds SET status = $2, closed_at = CURRENT_TIMESTAMP WHERE id = $1", id, HoldVoided)
+	if err != nil {
+		return Hold{}, err
+	}
+	hold, err := scanHold(tx.QueryRow(holdSQL, id))
+	if err != nil {
+		return Hold{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Hold{}, err
+	}
+
+	s.forget(ctx, BalanceKey(hold.Username, hold.Currency))
+
+	return hold, nil
+}
+
+// GetHold returns hold id.
+func (s Service) GetHold(ctx context.Context, id int) (Hold, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return Hold{}, err
+	}
+
+	hold, err := scanHold(db.QueryRowContext(ctx, holdSQL, id))
+	if errors.Is(err, ErrHoldNotFound) {
+		return Hold{}, errors.Wrapf(err, "%d", id)
+	}
+	return hold, err
+}
+
+// ExpireHolds closes the active holds past their expiry and returns how many
+// it closed. Expired holds already stopped reducing the available balance;
+// this only records it and drops the cached balances.
+func (s Service) ExpireHolds(ctx context.Context) (int, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, `WITH expired AS (
+    UPDATE holds SET status = 'expired', closed_at = CURRENT_TIMESTAMP
+    WHERE status = 'active' AND expires_at <= CURRENT_TIMESTAMP
+    RETURNING account_id
+)
+SELECT u.username, a.currency
+FROM expired
+JOIN accounts a ON a.id = expired.account_id
+JOIN users u ON u.id = a.user_id`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var username, currency string
+		if err = rows.Scan(&username, &currency); err != nil {
+			return 0, err
+		}
+		keys = append(keys, BalanceKey(username, currency))
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	s.forget(ctx, keys...)
+
+	return len(keys), nil
+}
+
+// SweepHolds expires holds every holds.sweepInterval until ctx is done.
+func (s Service) SweepHolds(ctx context.Context) {
+	every := s.factory.Config().Holds.SweepInterval
+	if every <= 0 {
+		every = DefaultSweepInterval
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	logger := s.factory.Logger()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.ExpireHolds(ctx)
+			if err != nil {
+				logger.Errorw("expiring holds failed", "error", err)
+				continue
+			}
+			if n > 0 {
+				logger.Infow("expired holds", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// holdUser opens a wallet funded with amount USD.
+func holdUser(t *testing.T, amount string) string {
+	username := fmt.Sprintf("hold%d", time.Now().UnixNano())
+	call(http.MethodPost, "/accounts", fmt.Sprintf(`{"username":%q}`, username))
+	resp := call(http.MethodPost, "/deposit", fmt.Sprintf(`{"username":%q,"amount":%s}`, username, amount))
+	if resp.Code != http.StatusOK {
+		t.Fatal("deposit failed", resp.Body.String())
+	}
+	return username
+}
+
+func placeHold(t *testing.T, username, amount string) wallet.Hold {
+	resp := call(http.MethodPost, "/holds", fmt.Sprintf(`{"username":%q,"amount":%s}`, username, amount))
+	if resp.Code != http.StatusCreated {
+		t.Fatal("expect hold created, got", resp.Code, resp.Body.String())
+	}
+	var hold wallet.Hold
+	_ = json.Unmarshal(resp.Body.Bytes(), &hold)
+	return hold
+}
+
+func TestHoldCapture(t *testing.T) {
+	username := holdUser(t, "10")
+	svc := wallet.NewService(f)
+
+	hold := placeHold(t, username, "6")
+	balance, _ := svc.GetBalance(context.Background(), username, "USD")
+	if !balance.Balance.Equal(wallet.MustParseMoney("10")) || !balance.Available.Equal(wallet.MustParseMoney("4")) {
+		t.Error("a hold must only reduce the available balance", balance)
+	}
+
+	// held funds cannot be spent twice
+	for _, path := range []string{"/withdraw", "/holds"} {
+		resp := call(http.MethodPost, path, fmt.Sprintf(`{"username":%q,"amount":5}`, username))
+		if resp.Code != http.StatusUnprocessableEntity {
+			t.Error(path, "expect insufficient funds, got", resp.Code, resp.Body.String())
+		}
+	}
+	resp := call(http.MethodPost, "/transfer", fmt.Sprintf(`{"from":%q,"to":"user1","amount":5}`, username))
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("transfer: expect insufficient funds, got", resp.Code, resp.Body.String())
+	}
+
+	resp = call(http.MethodPost, fmt.Sprintf("/holds/%d/capture", hold.ID), `{"amount":7}`)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect capture beyond the hold to fail, got", resp.Code)
+	}
+
+	resp = call(http.MethodPost, fmt.Sprintf("/holds/%d/capture", hold.ID), `{"amount":2.5}`)
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+	_ = json.Unmarshal(resp.Body.Bytes(), &hold)
+	if hold.Status != wallet.HoldCaptured || hold.TransactionID == nil || !hold.Captured.Equal(wallet.MustParseMoney("2.5")) {
+		t.Error("unexpected hold", resp.Body.String())
+	}
+
+	balance, _ = svc.GetBalance(context.Background(), username, "USD")
+	if !balance.Balance.Equal(wallet.MustParseMoney("7.5")) || !balance.Available.Equal(wallet.MustParseMoney("7.5")) {
+		t.Error("capture should debit 2.5 and release the rest", balance)
+	}
+
+	for _, action := range []string{"capture", "void"} {
+		resp = call(http.MethodPost, fmt.Sprintf("/holds/%d/%s", hold.ID, action), "")
+		if resp.Code != http.StatusConflict {
+			t.Error(action, "a captured hold: expect 409, got", resp.Code)
+		}
+	}
+}
+
+func TestHoldVoid(t *testing.T) {
+	username := holdUser(t, "10")
+	hold := placeHold(t, username, "10")
+
+	resp := call(http.MethodPost, fmt.Sprintf("/holds/%d/void", hold.ID), "")
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+
+	balance, _ := wallet.NewService(f).GetBalance(context.Background(), username, "USD")
+	if !balance.Available.Equal(wallet.MustParseMoney("10")) {
+		t.Error("a voided hold must release its amount", balance)
+	}
+}
+
+func TestHoldExpiry(t *testing.T) {
+	username := holdUser(t, "10")
+	hold := placeHold(t, username, "4")
+
+	d, _ := f.DB()
+	if _, err := d.Exec("UPDATE holds SET expires_at = CURRENT_TIMESTAMP - INTERVAL '1 second' WHERE id = $1", hold.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := call(http.MethodPost, fmt.Sprintf("/holds/%d/capture", hold.ID), "")
+	if resp.Code != http.StatusConflict {
+		t.Error("expect an expired hold not to be captured, got", resp.Code)
+	}
+
+	svc := wallet.NewService(f)
+	n, err := svc.ExpireHolds(context.Background())
+	if err != nil || n < 1 {
+		t.Error("expect the hold to be expired", n, err)
+	}
+
+	hold, _ = svc.GetHold(context.Background(), hold.ID)
+	if hold.Status != wallet.HoldExpired || hold.ClosedAt == nil {
+		t.Error("unexpected hold", hold)
+	}
+	balance, _ := svc.GetBalance(context.Background(), username, "USD")
+	if !balance.Available.Equal(wallet.MustParseMoney("10")) {
+		t.Error("an expired hold must release its amount", balance)
+	}
+}
+
+func TestHoldExpiry_CachedBalance(t *testing.T) {
+	username := holdUser(t, "10")
+	ttl := f.Config().Holds.TTL
+	f.Config().Holds.TTL = time.Second
+	defer func() { f.Config().Holds.TTL = ttl }()
+	placeHold(t, username, "4")
+
+	svc := wallet.NewService(f)
+	balance, _ := svc.GetBalance(context.Background(), username, "USD")
+	if !balance.Available.Equal(wallet.MustParseMoney("6")) {
+		t.Fatal("expect the hold to reserve its amount", balance)
+	}
+
+	// no movement clears the cached balance: it must expire with the hold
+	time.Sleep(1500 * time.Millisecond)
+	balance, _ = svc.GetBalance(context.Background(), username, "USD")
+	if !balance.Available.Equal(wallet.MustParseMoney("10")) {
+		t.Error("an expired hold must release its amount from the cached balance", balance)
+	}
+}
+
+func TestHoldNotFound(t *testing.T) {
+	for _, path := range []string{"/holds/0", "/holds/x", "/holds/2147483647"} {
+		if resp := call(http.MethodGet, path, ""); resp.Code != http.StatusNotFound {
+			t.Error(path, "expect 404, got", resp.Code)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const IdempotencyHeader = "Idempotency-Key"
+
+const (
+	// idempotencyClaimTimeout is how long a request may hold a key before a
+	// retry can take it over. A claim only outlives its request if the
+	// process died, and then no money was moved under it.
+	idempotencyClaimTimeout = time.Minute
+	// idempotencyCacheTTL bounds how long Redis keeps a stored outcome; it
+	// stays in Postgres for good.
+	idempotencyCacheTTL = 24 * time.Hour
+
+	idempotencyKeyCtx   = "idempotency.key"
+	idempotencyOwnerCtx = "idempotency.owner"
+	idempotencySavedCtx = "idempotency.saved"
+)
+
+// idempotentResponse is the outcome recorded for a key.
+type idempotentResponse struct {
+	Hash   string `json:"hash"`
+	Status int    `json:"status"`
+	Body   []byte `json:"body"`
+}
+
+func idempotencyCacheKey(key string) string {
+	return "idempotency:" + key
+}
+
+// responseRecorder keeps a copy of what a handler writes.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotent makes a money-moving endpoint safe to retry. The first request
+// carrying an Idempotency-Key claims the key; its handler stores the response
+// in the same database transaction that moves the money (see remember).
+// Replays with the same request get the stored response, a concurrent
+// duplicate gets 409 and a different request under the same key gets 422.
+// Requests without the header are not affected.
+func (c Controller) idempotent(ctx *gin.Context) {
+	key := ctx.GetHeader(IdempotencyHeader)
+	if key == "" {
+		ctx.Next()
+		return
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		c.handleError(ctx, errors.Wrap(ErrInvalidRequest, err.Error()))
+		return
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	sum := sha256.Sum256(append([]byte(ctx.Request.Method+" "+ctx.Request.URL.Path+"\n"), body...))
+	hash := hex.EncodeToString(sum[:])
+
+	token := make([]byte, 16)
+	if _, err = rand.Read(token); c.handleError(ctx, err) {
+		return
+	}
+	owner := hex.EncodeToString(token)
+
+	stored, err := c.claim(ctx, key, hash, owner)
+	if c.handleError(ctx, err) {
+		return
+	}
+	if stored != nil {
+		replay(ctx, stored)
+		return
+	}
+
+	w := &responseRecorder{ResponseWriter: ctx.Writer}
+	ctx.Writer = w
+	ctx.Set(idempotencyKeyCtx, key)
+	ctx.Set(idempotencyOwnerCtx, owner)
+
+	ctx.Next()
+
+	c.settle(ctx, key, owner, idempotentResponse{Hash: hash, Status: w.Status(), Body: w.body.Bytes()})
+}
+
+// claim reserves key for this request under the owner token. It returns the
+// stored outcome if the key was already used with the same request.
+func (c Controller) claim(ctx *gin.Context, key, hash, owner string) (*idempotentResponse, error) {
+	if cached := c.cached(ctx, key); cached != nil {
+		if cached.Hash != hash {
+			return nil, ErrIdempotencyMismatch
+		}
+		return cached, nil
+	}
+
+	db, err := c.factory.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.ExecContext(ctx, "INSERT INTO idempotency_keys (key, request_hash, owner) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING", key, hash, owner)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil, nil
+	}
+
+	var stored idempotentResponse
+	var status sql.NullInt64
+	err = db.QueryRowContext(ctx, "SELECT request_hash, status_code, response FROM idempotency_keys WHERE key = $1", key).Scan(&stored.Hash, &status, &stored.Body)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Hash != hash {
+		return nil, ErrIdempotencyMismatch
+	}
+	if status.Valid {
+		stored.Status = int(status.Int64)
+		c.cache(ctx, key, stored)
+		return &stored, nil
+	}
+
+	// take over a claim abandoned by a crashed request
+	res, err = db.ExecContext(ctx, "UPDATE idempotency_keys SET locked_at = CURRENT_TIMESTAMP, owner = $3 WHERE key = $1 AND status_code IS NULL AND locked_at < CURRENT_TIMESTAMP - $2 * INTERVAL '1 second'", key, idempotencyClaimTimeout.Seconds(), owner)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil, nil
+	}
+
+	return nil, ErrIdempotencyInFlight
+}
+
+// remember returns the hook storing the response of a request carrying an
+// Idempotency-Key in the movement's transaction, so it is committed
+// atomically with the money. The response is status with the movement's
+// result as body; a nil result is an empty response. If the request no
+// longer holds the key, because a retry took over its claim, the hook fails
+// with ErrIdempotencyInFlight and the money is not moved.
+func remember(ctx *gin.Context, status int) beforeCommit {
+	return func(tx *sql.Tx, result any) error {
+		key := ctx.GetString(idempotencyKeyCtx)
+		if key == "" {
+			return nil
+		}
+
+		var b []byte
+		if result != nil {
+			var err error
+			if b, err = json.Marshal(result); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.Exec("UPDATE idempotency_keys SET status_code = $2, response = $3 WHERE key = $1 AND owner = $4 AND status_code IS NULL",
+			key, status, b, ctx.GetString(idempotencyOwnerCtx))
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n != 1 {
+			return ErrIdempotencyInFlight
+		}
+		ctx.Set(idempotencySavedCtx, true)
+		return nil
+	}
+}
+
+// settle finishes a claim once the handler returned. A response saved by
+// remember only needs caching. Other client errors are final and stored as
+// they are, but a conflict (409, e.g. ErrConflict after lock contention) or
+// a server error releases the key so the request can be retried.
+// Nothing is touched once another request took the claim over from owner.
+func (c Controller) settle(ctx *gin.Context, key, owner string, resp idempotentResponse) {
+	logger := c.factory.Logger()
+
+	if ctx.GetBool(idempotencySavedCtx) {
+		c.cache(ctx, key, resp)
+		return
+	}
+
+	db, err := c.factory.DB()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	if resp.Status == http.StatusConflict || resp.Status >= http.StatusInternalServerError {
+		_, err = db.Exec("DELETE FROM idempotency_keys WHERE key = $1 AND owner = $2 AND status_code IS NULL", key, owner)
+		if err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+
+	res, err := db.Exec("UPDATE idempotency_keys SET status_code = $2, response = $3 WHERE key = $1 AND owner = $4 AND status_code IS NULL", key, resp.Status, resp.Body, owner)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		c.cache(ctx, key, resp)
+	}
+}
+
+// cached returns the outcome Redis keeps for key, if any. Redis is only a
+// fast path: when it fails the claim falls back to Postgres.
+func (c Controller) cached(ctx *gin.Context, key string) *idempotentResponse {
+	rdb, err := c.factory.Redis()
+	if err != nil {
+		c.factory.Logger().Warn(err)
+		return nil
+	}
+	b, err := rdb.Get(ctx, idempotencyCacheKey(key)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			c.factory.Logger().Warn(err)
+		}
+		return nil
+	}
+	var cached idempotentResponse
+	if json.Unmarshal(b, &cached) != nil {
+		return nil
+	}
+	return &cached
+}
+
+func (c Controller) cache(ctx *gin.Context, key string, resp idempotentResponse) {
+	rdb, err := c.factory.Redis()
+	if err != nil {
+		c.factory.Logger().Warn(err)
+		return
+	}
+	b, _ := json.Marshal(resp)
+	if err := rdb.Set(ctx, idempotencyCacheKey(key), b, idempotencyCacheTTL).Err(); err != nil {
+		c.factory.Logger().Warn(err)
+	}
+}
+
+func replay(ctx *gin.Context, stored *idempotentResponse) {
+	ctx.Header("Idempotent-Replayed", "true")
+	if len(stored.Body) == 0 {
+		ctx.AbortWithStatus(stored.Status)
+		return
+	}
+	ctx.Data(stored.Status, "application/json; charset=utf-8", stored.Body)
+	ctx.Abort()
+}
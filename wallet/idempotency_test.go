@@ -0,0 +1,157 @@
+package wallet_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bitmyth/walletserivce/db"
+	"github.com/bitmyth/walletserivce/factory"
+	"github.com/bitmyth/walletserivce/route"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func idempotentRequest(path, key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	request.Header.Set(wallet.IdempotencyHeader, key)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	return resp
+}
+
+func TestIdempotentDeposit(t *testing.T) {
+	key := fmt.Sprintf("deposit-%d", time.Now().UnixNano())
+	body := `{"username":"user1","amount":1.5}`
+
+	svc := wallet.NewService(f)
+	before, _ := svc.GetBalance(context.Background(), "user1", "USD")
+
+	first := idempotentRequest("/deposit", key, body)
+	if first.Code != http.StatusOK {
+		t.Error("code is not 200", first.Body.String())
+		return
+	}
+
+	second := idempotentRequest("/deposit", key, body)
+	if second.Code != http.StatusOK {
+		t.Error("code is not 200", second.Body.String())
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Error("replay should return the first response", first.Body.String(), second.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expect replayed header")
+	}
+
+	after, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !after.Balance.Sub(before.Balance).Equal(wallet.MustParseMoney("1.5")) {
+		t.Error("deposit should be applied once", before, after)
+	}
+}
+
+func TestIdempotentTransfer(t *testing.T) {
+	key := fmt.Sprintf("transfer-%d", time.Now().UnixNano())
+	body := `{"from":"user1","to":"user2","amount":1}`
+
+	first := idempotentRequest("/transfer", key, body)
+	second := idempotentRequest("/transfer", key, body)
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Error("code is not 200", first.Code, second.Code)
+	}
+}
+
+func TestIdempotencyRetryAfterConflict(t *testing.T) {
+	username := fmt.Sprintf("idem%d", time.Now().UnixNano())
+	call(http.MethodPost, "/accounts", fmt.Sprintf(`{"username":%q}`, username))
+	call(http.MethodPost, "/accounts/"+username+"/freeze", "")
+
+	key := fmt.Sprintf("conflict-%d", time.Now().UnixNano())
+	body := fmt.Sprintf(`{"username":%q,"amount":2}`, username)
+	if first := idempotentRequest("/deposit", key, body); first.Code != http.StatusConflict {
+		t.Fatal("expect 409, got", first.Code, first.Body.String())
+	}
+
+	// a conflict is not the key's final answer: once it is resolved the
+	// same key moves the money
+	call(http.MethodPost, "/accounts/"+username+"/unfreeze", "")
+	second := idempotentRequest("/deposit", key, body)
+	if second.Code != http.StatusOK || second.Header().Get("Idempotent-Replayed") == "true" {
+		t.Fatal("expect the retry to deposit, got", second.Code, second.Body.String())
+	}
+	balance, _ := wallet.NewService(f).GetBalance(context.Background(), username, "USD")
+	if !balance.Balance.Equal(wallet.MustParseMoney("2")) {
+		t.Error("expect the deposit applied once, got", balance)
+	}
+}
+
+// redisDown is the test factory with Redis unreachable.
+type redisDown struct{ factory.Factory }
+
+func (redisDown) Redis() (*db.Redis, error) { return nil, errors.New("redis is down") }
+
+func TestRedisUnavailable(t *testing.T) {
+	down := redisDown{f}
+	router := route.Router(down)
+	wallet.NewController(down).RegisterRoutes(router)
+
+	before, err := wallet.NewService(down).GetBalance(context.Background(), "user1", "USD")
+	if err != nil {
+		t.Fatal("expect the balance read from the database, got", err)
+	}
+
+	key := fmt.Sprintf("noredis-%d", time.Now().UnixNano())
+	body := `{"username":"user1","amount":3}`
+	serve := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(body))
+		request.Header.Set(wallet.IdempotencyHeader, key)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, request)
+		return resp
+	}
+	first, second := serve(), serve()
+	if first.Code != http.StatusOK || second.Code != http.StatusOK || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("expect the deposit made once and replayed, got", first.Code, second.Code, second.Body.String())
+	}
+
+	after, err := wallet.NewService(down).GetBalance(context.Background(), "user1", "USD")
+	if err != nil || !after.Balance.Sub(before.Balance).Equal(wallet.MustParseMoney("3")) {
+		t.Error("expect the deposit in the balance, got", before, after, err)
+	}
+}
+
+func TestIdempotencyKeyReusedWithDifferentPayload(t *testing.T) {
+	key := fmt.Sprintf("reuse-%d", time.Now().UnixNano())
+
+	first := idempotentRequest("/deposit", key, `{"username":"user1","amount":1}`)
+	if first.Code != http.StatusOK {
+		t.Error("code is not 200", first.Body.String())
+	}
+
+	second := idempotentRequest("/deposit", key, `{"username":"user1","amount":2}`)
+	if second.Code != http.StatusUnprocessableEntity {
+		t.Error("expect 422, got", second.Code)
+	}
+
+	third := idempotentRequest("/withdraw", key, `{"username":"user1","amount":1}`)
+	if third.Code != http.StatusUnprocessableEntity {
+		t.Error("expect 422, got", third.Code)
+	}
+}
+
+func TestIdempotentClientErrorIsStored(t *testing.T) {
+	key := fmt.Sprintf("insufficient-%d", time.Now().UnixNano())
+	body := `{"username":"user1","amount":100000}`
+
+	first := idempotentRequest("/withdraw", key, body)
+	second := idempotentRequest("/withdraw", key, body)
+	if first.Code != http.StatusUnprocessableEntity || second.Code != http.StatusUnprocessableEntity {
+		t.Error("expect 422 twice", first.Code, second.Code)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expect replayed header")
+	}
+}
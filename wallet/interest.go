@@ -0,0 +1,455 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+)
+
+// Interest accrues on the ledger balance of every account at the end of
+// each UTC day, actual/365, and is paid out on the payout schedule.
+const (
+	DefaultInterestPayout       = "@monthly"
+	DefaultInterestPollInterval = time.Hour
+	daysPerYear                 = 365
+)
+
+// InterestRequest overrides the annual rate of an account.
+type InterestRequest struct {
+	Currency string `json:"currency" binding:"omitempty,currency"`
+	// Rate is in percent a year.
+	Rate *decimal.Decimal `json:"rate" binding:"required"`
+}
+
+// AccountInterest is the interest an account earns at its annual Rate in
+// percent: what it accrued since the last payout, through which day, and
+// what was paid out so far.
+type AccountInterest struct {
+	Username       string          `json:"username"`
+	Currency       string          `json:"currency"`
+	Rate           decimal.Decimal `json:"rate"`
+	Accrued        Money           `json:"accrued"`
+	AccruedThrough *string         `json:"accrued_through,omitempty"`
+	Paid           Money           `json:"paid"`
+}
+
+// dailyInterest is what balance earns in a day at an annual rate in percent.
+// It is not rounded to a minor unit; payouts are.
+func dailyInterest(balance Money, rate decimal.Decimal) Money {
+	return Money{d: balance.d.Mul(rate).Div(hundred).Div(decimal.NewFromInt(daysPerYear)).Round(10)}
+}
+
+// payable is what accrued in total less what was paid out, rounded down to
+// the minor unit of c. The fractions left over are paid once they add up.
+func payable(accrued, paid Money, c Currency) Money {
+	return Money{d: accrued.d.RoundFloor(int32(c.Exponent)).Sub(paid.d)}
+}
+
+// configuredRate returns the annual rate configured for currency.
+func (s Service) configuredRate(currency string) (decimal.Decimal, error) {
+	for code, text := range s.factory.Config().Interest.Rates {
+		// viper lowercases map keys
+		if !strings.EqualFold(code, currency) {
+			continue
+		}
+		rate, err := decimal.NewFromString(text)
+		if err != nil {
+			return decimal.Zero, errors.Wrapf(err, "interest.rates.%s", code)
+		}
+		return rate, nil
+	}
+	return decimal.Zero, nil
+}
+
+// interestRate returns the annual rate of accountID: its override, or the
+// rate configured for its currency.
+func (s Service) interestRate(ctx context.Context, q querier, accountID int, currency string) (decimal.Decimal, error) {
+	var rate decimal.Decimal
+	err := q.QueryRowContext(ctx, "SELECT rate FROM interest_rates WHERE account_id = $1", accountID).Scan(&rate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.configuredRate(currency)
+	}
+	return rate, err
+}
+
+// accrualStartSQL lists the accounts of open wallets with the first day
+// they have not accrued interest for: the day after the last accrual, or
+// the day money first reached them. It is NULL for accounts never used.
+const accrualStartSQL = `SELECT a.id, a.currency, COALESCE(
+    (SELECT MAX(i.day) + 1 FROM interest_accruals i WHERE i.account_id = a.id),
+    (SELECT MIN(e.completed_at)::date FROM postings p JOIN journal_entries e ON e.id = p.entry_id WHERE p.account_id = a.id))
+FROM accounts a
+JOIN users u ON u.id = a.user_id
+WHERE u.status <> 'closed'
+ORDER BY a.id`
+
+// endOfDaySQL lists the ledger balance of account $1 at the end of every
+// day from $2 through $3: the sum of its postings completed before the
+// next day began.
+const endOfDaySQL = `SELECT d::date, COALESCE((SELECT SUM(p.amount)
+    FROM postings p
+    JOIN journal_entries e ON e.id = p.entry_id
+    WHERE p.account_id = $1 AND e.completed_at < d + INTERVAL '1 day'), 0)
+FROM generate_series($2::date, $3::date, INTERVAL '1 day') d
+ORDER BY d`
+
+// paidInterestSQL sums the interest paid out to account $1.
+const paidInterestSQL = `SELECT COALESCE(SUM(p.amount), 0)
+FROM postings p
+JOIN journal_entries e ON e.id = p.entry_id
+WHERE p.account_id = $1 AND e.entry_type = 'interest'`
+
+// AccrueInterest accrues interest for every day through the day of through
+// that an account of an open wallet has not accrued yet, so days a run
+// missed are caught up. Days that did not end yet are left for later. It
+// returns how many days were accrued.
+func (s Service) AccrueInterest(ctx context.Context, through time.Time) (int, error) {
+	day := through.UTC().Truncate(24 * time.Hour)
+	if yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1); day.After(yesterday) {
+		day = yesterday
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	type start struct {
+		id       int
+		currency string
+		from     time.Time
+	}
+	var starts []start
+	rows, err := db.QueryContext(ctx, accrualStartSQL)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var a start
+		var from sql.NullTime
+		if err = rows.Scan(&a.id, &a.currency, &from); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		if from.Valid && !from.Time.After(day) {
+			a.from = from.Time
+			starts = append(starts, a)
+		}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, a := range starts {
+		accrued, err := s.accrue(ctx, db.DB, a.id, a.currency, a.from, day)
+		n += accrued
+		if err != nil {
+			return n, errors.Wrapf(err, "account %d", a.id)
+		}
+	}
+	return n, nil
+}
+
+// accrue records the interest accountID earned each day from from through
+// through. Days accrued meanwhile by another run are left as they are.
+func (s Service) accrue(ctx context.Context, db *sql.DB, accountID int, currency string, from, through time.Time) (int, error) {
+	rate, err := s.interestRate(ctx, db, accountID, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	type day struct {
+		day     time.Time
+		balance Money
+	}
+	var days []day
+	rows, err := tx.Query(endOfDaySQL, accountID, from, through)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var d day
+		if err = rows.Scan(&d.day, &d.balance); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		days = append(days, d)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, d := range days {
+		res, err := tx.Exec("INSERT INTO interest_accruals (account_id, day, balance, rate, amount) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (account_id, day) DO NOTHING",
+			accountID, d.day, d.balance, rate, dailyInterest(d.balance, rate))
+		if err != nil {
+			return 0, err
+		}
+		affected, _ := res.RowsAffected()
+		n += int(affected)
+	}
+	return n, tx.Commit()
+}
+
+// payoutSQL lists the accounts of open wallets with unpaid interest, and
+// since when: their last payout, or the first day they accrued.
+const payoutSQL = `SELECT a.id, COALESCE(
+    (SELECT MAX(e.completed_at) FROM postings p JOIN journal_entries e ON e.id = p.entry_id WHERE p.account_id = a.id AND e.entry_type = 'interest'),
+    MIN(i.day)::timestamp)
+FROM interest_accruals i
+JOIN accounts a ON a.id = i.account_id
+JOIN users u ON u.id = a.user_id
+WHERE i.payout_id IS NULL AND u.status <> 'closed'
+GROUP BY a.id
+HAVING SUM(i.amount) > 0
+ORDER BY a.id`
+
+// PayInterest pays out the interest accrued by every account whose payout
+// is due at now: the payout schedule had an occurrence since the account
+// was last paid, or began accruing. However many occurrences were missed,
+// one payout pays all. It returns how many accounts were paid.
+func (s Service) PayInterest(ctx context.Context, now time.Time) (int, error) {
+	payout := s.factory.Config().Interest.Payout
+	if payout == "" {
+		payout = DefaultInterestPayout
+	}
+	spec, err := parseCron(payout)
+	if err != nil {
+		return 0, errors.Wrap(err, "interest.payout")
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	var due []int
+	rows, err := db.QueryContext(ctx, payoutSQL)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var id int
+		var since time.Time
+		if err = rows.Scan(&id, &since); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		if next := spec.next(since); !next.IsZero() && !next.After(now) {
+			due = append(due, id)
+		}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range due {
+		paid, err := s.payInterest(ctx, db.DB, id)
+		if err != nil {
+			return n, errors.Wrapf(err, "account %d", id)
+		}
+		if paid {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// payInterest pays out what accountID accrued and was not paid yet, from
+// the interest system account, and reports whether there was any. The
+// account stays locked meanwhile, so concurrent runs cannot pay it twice.
+func (s Service) payInterest(ctx context.Context, db *sql.DB, accountID int) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var username, code string
+	err = tx.QueryRow("SELECT u.username, a.currency FROM accounts a JOIN users u ON u.id = a.user_id WHERE a.id = $1", accountID).Scan(&username, &code)
+	if err != nil {
+		return false, err
+	}
+	currency, err := ParseCurrency(code)
+	if err != nil {
+		return false, err
+	}
+	if err = checkStatus(tx, username, false); err != nil {
+		return false, err
+	}
+	if err = lockAccounts(tx, accountID); err != nil {
+		return false, err
+	}
+
+	var accrued, paid Money
+	if err = tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM interest_accruals WHERE account_id = $1", accountID).Scan(&accrued); err != nil {
+		return false, err
+	}
+	if err = tx.QueryRow(paidInterestSQL, accountID).Scan(&paid); err != nil {
+		return false, err
+	}
+	amount := payable(accrued, paid, currency)
+	if !amount.IsPositive() {
+		return false, nil
+	}
+
+	interestID, err := systemAccount(tx, SystemInterest, currency.Code)
+	if err != nil {
+		return false, err
+	}
+	entryID, err := post(tx, Entry{Type: "interest", Postings: []Posting{
+		{AccountID: accountID, Currency: currency.Code, Amount: amount},
+		{AccountID: interestID, Currency: currency.Code, Amount: amount.Neg()},
+	}})
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec("UPDATE interest_accruals SET payout_id = $2 WHERE account_id = $1 AND payout_id IS NULL", accountID, entryID)
+	if err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	s.forget(ctx, BalanceKey(username, currency.Code))
+	return true, nil
+}
+
+// RunInterest accrues the interest of the days that ended and pays out
+// what is due, every poll interval, until ctx is done.
+func (s Service) RunInterest(ctx context.Context) {
+	every := s.factory.Config().Interest.PollInterval
+	if every <= 0 {
+		every = DefaultInterestPollInterval
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	logger := s.factory.Logger()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().UTC()
+			n, err := s.AccrueInterest(ctx, now.AddDate(0, 0, -1))
+			if err != nil {
+				logger.Errorw("accruing interest failed", "error", err)
+			} else if n > 0 {
+				logger.Infow("accrued interest", "days", n)
+			}
+			n, err = s.PayInterest(ctx, now)
+			if err != nil {
+				logger.Errorw("paying interest failed", "error", err)
+			} else if n > 0 {
+				logger.Infow("paid interest", "accounts", n)
+			}
+		}
+	}
+}
+
+// GetInterest returns the interest rate of a user's account in currency,
+// and what it accrued and was paid.
+func (s Service) GetInterest(ctx context.Context, username, currency string) (AccountInterest, error) {
+	c, err := ParseCurrency(currency)
+	if err != nil {
+		return AccountInterest{}, err
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return AccountInterest{}, err
+	}
+
+	var accountID sql.NullInt64
+	err = db.QueryRowContext(ctx, "SELECT a.id FROM users u LEFT JOIN accounts a ON a.user_id = u.id AND a.currency = $2 WHERE u.username = $1", username, c.Code).Scan(&accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccountInterest{}, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return AccountInterest{}, err
+	}
+
+	result := AccountInterest{Username: username, Currency: c.Code}
+	// a user who never held the currency would earn the configured rate
+	if result.Rate, err = s.interestRate(ctx, db, int(accountID.Int64), c.Code); err != nil {
+		return AccountInterest{}, err
+	}
+	if !accountID.Valid {
+		return result, nil
+	}
+
+	var accrued Money
+	var through sql.NullTime
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0), MAX(day), ("+paidInterestSQL+") FROM interest_accruals WHERE account_id = $1", accountID.Int64).
+		Scan(&accrued, &through, &result.Paid)
+	if err != nil {
+		return AccountInterest{}, err
+	}
+	result.Accrued = accrued.Sub(result.Paid)
+	if through.Valid {
+		day := through.Time.Format("2006-01-02")
+		result.AccruedThrough = &day
+	}
+	return result, nil
+}
+
+// SetInterestRate overrides the annual interest rate of the account of
+// username. Days already accrued keep the rate they were accrued at.
+func (s Service) SetInterestRate(ctx context.Context, username string, req InterestRequest) (AccountInterest, error) {
+	c, err := ParseCurrency(req.Currency)
+	if err != nil {
+		return AccountInterest{}, err
+	}
+	if req.Rate.IsNegative() || req.Rate.GreaterThan(hundred) {
+		return AccountInterest{}, errors.Wrapf(ErrInvalidRequest, "rate %s is outside 0-100", req.Rate)
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return AccountInterest{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return AccountInterest{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err = checkStatus(tx, username, false); err != nil {
+		return AccountInterest{}, err
+	}
+	accountID, err := userAccount(tx, username, c.Code)
+	if err != nil {
+		return AccountInterest{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO interest_rates (account_id, rate)
+VALUES ($1, $2)
+ON CONFLICT (account_id) DO UPDATE
+SET rate = $2, updated_at = CURRENT_TIMESTAMP`, accountID, *req.Rate)
+	if err != nil {
+		return AccountInterest{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return AccountInterest{}, err
+	}
+
+	return s.GetInterest(ctx, username, c.Code)
+}
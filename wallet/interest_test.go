@@ -0,0 +1,42 @@
+package wallet
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestDailyInterest(t *testing.T) {
+	cases := []struct{ balance, rate, interest string }{
+		{"1000", "3.65", "0.1"},
+		{"1000", "10", "0.2739726027"},
+		{"0", "5", "0"},
+		{"1000", "0", "0"},
+	}
+	for _, c := range cases {
+		got := dailyInterest(MustParseMoney(c.balance), decimal.RequireFromString(c.rate))
+		if !got.Equal(MustParseMoney(c.interest)) {
+			t.Errorf("%s at %s%%: expect %s, got %s", c.balance, c.rate, c.interest, got)
+		}
+	}
+}
+
+func TestPayable(t *testing.T) {
+	usd, jpy := currencies["USD"], currencies["JPY"]
+	cases := []struct {
+		accrued, paid string
+		currency      Currency
+		payable       string
+	}{
+		{"2.7397260270", "0", usd, "2.73"},
+		// the fraction left over by the last payout is paid with the next
+		{"5.4794520540", "2.73", usd, "2.74"},
+		{"0.0099", "0", usd, "0"},
+		{"12.9", "0", jpy, "12"},
+	}
+	for _, c := range cases {
+		got := payable(MustParseMoney(c.accrued), MustParseMoney(c.paid), c.currency)
+		if !got.Equal(MustParseMoney(c.payable)) {
+			t.Errorf("%s accrued, %s paid: expect %s, got %s", c.accrued, c.paid, c.payable, got)
+		}
+	}
+}
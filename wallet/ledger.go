@@ -0,0 +1,190 @@
+package wallet
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// System accounts are the counter-party of money entering or leaving users'
+// wallets. There is one per name and currency, created on first use.
+const (
+	SystemCashIn   = "cash-in"
+	SystemCashOut  = "cash-out"
+	SystemFees     = "fees"
+	SystemFX       = "fx"
+	SystemInterest = "interest"
+	SystemOpening  = "opening"
+)
+
+// nonNegativeBalance is the check constraint keeping user balances >= 0.
+const nonNegativeBalance = "accounts_balance_non_negative"
+
+// Posting moves Amount into (positive) or out of (negative) an account.
+type Posting struct {
+	AccountID int
+	Currency  string
+	Amount    Money
+}
+
+// Entry is one journal entry of the ledger. Its postings must sum to zero in
+// every currency, so money is only ever moved between accounts. An entry is
+// TransactionCompleted unless Status says it is TransactionPending.
+type Entry struct {
+	Type     string
+	Rate     decimal.NullDecimal
+	Status   string
+	Postings []Posting
+	// Reverses is the id of the entry a reversal compensates.
+	Reverses int
+	// Transfer is the id of the transfer a transfer entry records.
+	Transfer int
+	// FeeFor is the id of the entry a fee entry is charged for.
+	FeeFor int
+}
+
+// Check reports ErrUnbalanced unless the postings of e sum to zero per currency.
+func (e Entry) Check() error {
+	if len(e.Postings) < 2 {
+		return errors.Wrap(ErrUnbalanced, "an entry needs at least two postings")
+	}
+	sums := map[string]Money{}
+	for _, p := range e.Postings {
+		sums[p.Currency] = sums[p.Currency].Add(p.Amount)
+	}
+	for currency, sum := range sums {
+		if !sum.IsZero() {
+			return errors.Wrapf(ErrUnbalanced, "%s postings sum to %s", currency, sum)
+		}
+	}
+	return nil
+}
+
+// post writes e and its postings, and moves the cached balance of every user
+// account it touches. System account balances are not cached: they are hit
+// by every movement and would serialize all of them, so they are summed from
+// postings when needed. A pending entry moves no balance until it is
+// settled; its debits only lower the available balance.
+func post(tx *sql.Tx, e Entry) (int, error) {
+	if err := e.Check(); err != nil {
+		return 0, err
+	}
+
+	status := e.Status
+	if status == "" {
+		status = TransactionCompleted
+	}
+
+	var entryID int
+	err := tx.QueryRow("INSERT INTO journal_entries (entry_type, rate, status, completed_at, reverses_id, transfer_id, fee_for_id) VALUES ($1, $2, $3, CASE WHEN $3 = 'completed' THEN CURRENT_TIMESTAMP END, NULLIF($4, 0), NULLIF($5, 0), NULLIF($6, 0)) RETURNING id", e.Type, e.Rate, status, e.Reverses, e.Transfer, e.FeeFor).Scan(&entryID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range e.Postings {
+		_, err = tx.Exec("INSERT INTO postings (entry_id, account_id, currency, amount) VALUES ($1, $2, $3, $4)", entryID, p.AccountID, p.Currency, p.Amount)
+		if err != nil {
+			return 0, err
+		}
+		if status != TransactionCompleted {
+			continue
+		}
+		if err = moveBalance(tx, p.AccountID, p.Amount); err != nil {
+			return 0, err
+		}
+	}
+
+	return entryID, nil
+}
+
+// moveBalance adds amount to the cached balance of a user account. System
+// accounts are left alone, see post.
+func moveBalance(tx *sql.Tx, accountID int, amount Money) error {
+	_, err := tx.Exec("UPDATE accounts SET balance = balance + $1 WHERE id = $2 AND user_id IS NOT NULL", amount, accountID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Constraint == nonNegativeBalance {
+		return errors.Wrapf(ErrInsufficientFunds, "account %d", accountID)
+	}
+	return err
+}
+
+// lockAccounts locks accounts in ascending id order, so transactions
+// locking the same accounts always queue instead of deadlocking.
+func lockAccounts(tx *sql.Tx, ids ...int) error {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+	for _, id := range sorted {
+		if _, err := tx.Exec("SELECT 1 FROM accounts WHERE id = $1 FOR UPDATE", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// availableSQL computes the available balance of the user account a: its
+// ledger balance less the debits still pending and the holds on it. A hold
+// past its expiry no longer counts, even before the sweeper closes it.
+const availableSQL = `a.balance + COALESCE((SELECT SUM(p.amount)
+    FROM postings p
+    JOIN journal_entries e ON e.id = p.entry_id
+    WHERE p.account_id = a.id AND p.amount < 0 AND e.status = 'pending'), 0)
+  - COALESCE((SELECT SUM(h.amount)
+    FROM holds h
+    WHERE h.account_id = a.id AND h.status = 'active' AND h.expires_at > CURRENT_TIMESTAMP), 0)`
+
+// holdExpirySQL is the number of seconds until the first active hold on
+// account a expires, or NULL without any.
+const holdExpirySQL = `(SELECT EXTRACT(EPOCH FROM MIN(h.expires_at) - CURRENT_TIMESTAMP)
+    FROM holds h
+    WHERE h.account_id = a.id AND h.status = 'active' AND h.expires_at > CURRENT_TIMESTAMP)`
+
+// lockFunds locks a user account until tx ends and checks it has at least
+// amount available.
+func lockFunds(tx *sql.Tx, accountID int, amount Money) error {
+	if err := lockAccounts(tx, accountID); err != nil {
+		return err
+	}
+	balance, err := accountBalance(tx, accountID)
+	if err != nil {
+		return err
+	}
+	if balance.Available.LessThan(amount) {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// accountBalance reads the ledger and available balance of a user account.
+func accountBalance(tx *sql.Tx, accountID int) (Balance, error) {
+	var balance Balance
+	err := tx.QueryRow("SELECT a.currency, a.balance, "+availableSQL+" FROM accounts a WHERE a.id = $1", accountID).Scan(&balance.Currency, &balance.Balance, &balance.Available)
+	return balance, err
+}
+
+// userAccount returns the account of username in currency, opening it when
+// the user holds none yet. An unknown user is sql.ErrNoRows.
+func userAccount(tx *sql.Tx, username, currency string) (int, error) {
+	_, err := tx.Exec("INSERT INTO accounts (user_id, currency) SELECT id, $2 FROM users WHERE username = $1 ON CONFLICT (user_id, currency) DO NOTHING", username, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = tx.QueryRow("SELECT a.id FROM accounts a JOIN users u ON u.id = a.user_id WHERE u.username = $1 AND a.currency = $2", username, currency).Scan(&id)
+	return id, err
+}
+
+// systemAccount returns the system account name in currency, opening it on
+// first use.
+func systemAccount(tx *sql.Tx, name, currency string) (int, error) {
+	_, err := tx.Exec("INSERT INTO accounts (system, currency) VALUES ($1, $2) ON CONFLICT (system, currency) DO NOTHING", name, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = tx.QueryRow("SELECT id FROM accounts WHERE system = $1 AND currency = $2", name, currency).Scan(&id)
+	return id, err
+}
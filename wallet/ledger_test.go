@@ -0,0 +1,63 @@
+package wallet_test
+
+import (
+	"errors"
+	"github.com/bitmyth/walletserivce/wallet"
+	"testing"
+)
+
+func TestEntry_Check(t *testing.T) {
+	ten := wallet.MustParseMoney("10")
+	balanced := wallet.Entry{Type: "transfer", Postings: []wallet.Posting{
+		{AccountID: 1, Currency: "USD", Amount: ten.Neg()},
+		{AccountID: 2, Currency: "USD", Amount: ten},
+	}}
+	if err := balanced.Check(); err != nil {
+		t.Error(err)
+	}
+
+	unbalanced := wallet.Entry{Type: "transfer", Postings: []wallet.Posting{
+		{AccountID: 1, Currency: "USD", Amount: ten.Neg()},
+		{AccountID: 2, Currency: "EUR", Amount: ten},
+	}}
+	if err := unbalanced.Check(); !errors.Is(err, wallet.ErrUnbalanced) {
+		t.Error("expect ErrUnbalanced, got", err)
+	}
+
+	single := wallet.Entry{Type: "deposit", Postings: []wallet.Posting{{AccountID: 1, Currency: "USD"}}}
+	if err := single.Check(); !errors.Is(err, wallet.ErrUnbalanced) {
+		t.Error("expect ErrUnbalanced, got", err)
+	}
+}
+
+func TestLedgerBalances(t *testing.T) {
+	d, _ := f.DB()
+
+	// every user balance equals the sum of its settled postings
+	var drifted int
+	err := d.QueryRow(`SELECT COUNT(*) FROM (
+    SELECT a.id FROM accounts a
+    LEFT JOIN postings p ON p.account_id = a.id
+        AND (SELECT status FROM journal_entries e WHERE e.id = p.entry_id) IN ('completed', 'reversed')
+    WHERE a.user_id IS NOT NULL
+    GROUP BY a.id HAVING a.balance <> COALESCE(SUM(p.amount), 0)
+) drift`).Scan(&drifted)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if drifted != 0 {
+		t.Error("accounts drifted from their postings:", drifted)
+	}
+
+	// money never appears from nowhere
+	var unbalanced int
+	err = d.QueryRow("SELECT COUNT(*) FROM (SELECT currency FROM postings GROUP BY currency HAVING SUM(amount) <> 0) u").Scan(&unbalanced)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if unbalanced != 0 {
+		t.Error("ledger does not balance in", unbalanced, "currencies")
+	}
+}
@@ -0,0 +1,465 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits caps the outflow of an account: each withdrawal or outgoing
+// transfer, and their sum and number per UTC day and month. A nil limit
+// does not apply.
+type Limits struct {
+	PerTransaction *Money `json:"per_transaction,omitempty"`
+	Daily          *Money `json:"daily,omitempty"`
+	Monthly        *Money `json:"monthly,omitempty"`
+	DailyCount     *int   `json:"daily_count,omitempty"`
+	MonthlyCount   *int   `json:"monthly_count,omitempty"`
+}
+
+func (l Limits) empty() bool {
+	return l == Limits{}
+}
+
+// Usage is the outflow of an account so far this day and month.
+type Usage struct {
+	Daily        Money `json:"daily"`
+	DailyCount   int   `json:"daily_count"`
+	Monthly      Money `json:"monthly"`
+	MonthlyCount int   `json:"monthly_count"`
+}
+
+// AccountLimits are the limits applying to an account, what it used of them
+// and what remains.
+type AccountLimits struct {
+	Username  string `json:"username"`
+	Currency  string `json:"currency"`
+	Limits    Limits `json:"limits"`
+	Used      Usage  `json:"used"`
+	Remaining Limits `json:"remaining"`
+}
+
+// LimitsRequest overrides the configured limits of an account. Limits left
+// out fall back to the configured ones.
+type LimitsRequest struct {
+	Currency       string `json:"currency" binding:"omitempty,currency"`
+	PerTransaction *Money `json:"per_transaction" binding:"omitempty,money"`
+	Daily          *Money `json:"daily" binding:"omitempty,money"`
+	Monthly        *Money `json:"monthly" binding:"omitempty,money"`
+	DailyCount     *int   `json:"daily_count" binding:"omitempty,min=0"`
+	MonthlyCount   *int   `json:"monthly_count" binding:"omitempty,min=0"`
+}
+
+// LimitError is ErrLimitExceeded for one limit, with what remains of it.
+type LimitError struct {
+	// Limit is the name of the limit as in Limits: daily, monthly_count...
+	Limit     string
+	Max       string
+	Remaining string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s limit of %s, %s remaining", ErrLimitExceeded, e.Limit, e.Max, e.Remaining)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// remaining returns what is left of l after used.
+func remaining(l Limits, used Usage) Limits {
+	left := Limits{PerTransaction: l.PerTransaction}
+	if l.Daily != nil {
+		d := maxMoney(l.Daily.Sub(used.Daily), Money{})
+		left.Daily = &d
+	}
+	if l.Monthly != nil {
+		m := maxMoney(l.Monthly.Sub(used.Monthly), Money{})
+		left.Monthly = &m
+	}
+	if l.DailyCount != nil {
+		n := max(*l.DailyCount-used.DailyCount, 0)
+		left.DailyCount = &n
+	}
+	if l.MonthlyCount != nil {
+		n := max(*l.MonthlyCount-used.MonthlyCount, 0)
+		left.MonthlyCount = &n
+	}
+	return left
+}
+
+func maxMoney(a, b Money) Money {
+	if a.LessThan(b) {
+		return b
+	}
+	return a
+}
+
+// exceeded returns the first limit of l that amount more would break, given
+// used.
+func exceeded(l Limits, used Usage, amount Money) error {
+	left := remaining(l, used)
+	amounts := []struct {
+		name      string
+		max, left *Money
+	}{
+		{"per_transaction", l.PerTransaction, left.PerTransaction},
+		{"daily", l.Daily, left.Daily},
+		{"monthly", l.Monthly, left.Monthly},
+	}
+	for _, a := range amounts {
+		if a.max != nil && a.left.LessThan(amount) {
+			return &LimitError{Limit: a.name, Max: a.max.String(), Remaining: a.left.String()}
+		}
+	}
+	counts := []struct {
+		name      string
+		max, left *int
+	}{
+		{"daily_count", l.DailyCount, left.DailyCount},
+		{"monthly_count", l.MonthlyCount, left.MonthlyCount},
+	}
+	for _, c := range counts {
+		if c.max != nil && *c.left < 1 {
+			return &LimitError{Limit: c.name, Max: strconv.Itoa(*c.max), Remaining: "0"}
+		}
+	}
+	return nil
+}
+
+// configuredLimits returns the limits configured for currency.
+func (s Service) configuredLimits(currency string) (Limits, error) {
+	var l Limits
+	for code, c := range s.factory.Config().Limits {
+		// viper lowercases map keys
+		if !strings.EqualFold(code, currency) {
+			continue
+		}
+		for _, f := range []struct {
+			text string
+			dst  **Money
+		}{{c.PerTransaction, &l.PerTransaction}, {c.Daily, &l.Daily}, {c.Monthly, &l.Monthly}} {
+			if f.text == "" {
+				continue
+			}
+			m, err := ParseMoney(f.text)
+			if err != nil {
+				return Limits{}, errors.Wrapf(err, "limits.%s", code)
+			}
+			*f.dst = &m
+		}
+		if c.DailyCount > 0 {
+			l.DailyCount = &c.DailyCount
+		}
+		if c.MonthlyCount > 0 {
+			l.MonthlyCount = &c.MonthlyCount
+		}
+	}
+	return l, nil
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// accountLimits returns the limits applying to accountID: its overrides,
+// and the configured ones for the rest.
+func (s Service) accountLimits(ctx context.Context, q querier, accountID int, currency string) (Limits, error) {
+	l, err := s.configuredLimits(currency)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	var o Limits
+	err = q.QueryRowContext(ctx, "SELECT per_transaction, daily, monthly, daily_count, monthly_count FROM account_limits WHERE account_id = $1", accountID).
+		Scan(&o.PerTransaction, &o.Daily, &o.Monthly, &o.DailyCount, &o.MonthlyCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return l, nil
+	}
+	if err != nil {
+		return Limits{}, err
+	}
+
+	if o.PerTransaction != nil {
+		l.PerTransaction = o.PerTransaction
+	}
+	if o.Daily != nil {
+		l.Daily = o.Daily
+	}
+	if o.Monthly != nil {
+		l.Monthly = o.Monthly
+	}
+	if o.DailyCount != nil {
+		l.DailyCount = o.DailyCount
+	}
+	if o.MonthlyCount != nil {
+		l.MonthlyCount = o.MonthlyCount
+	}
+	return l, nil
+}
+
+// usageKeys are the Redis hashes counting the outflow of accountID in the
+// UTC day and month of now.
+func usageKeys(accountID int, now time.Time) (day, month string) {
+	now = now.UTC()
+	return fmt.Sprintf("limits:%d:%s", accountID, now.Format("2006-01-02")),
+		fmt.Sprintf("limits:%d:%s", accountID, now.Format("2006-01"))
+}
+
+// outflowSQL sums the withdrawals, outgoing transfers and captured holds of
+// an account since the start of the month ($3), and of the day ($2).
+const outflowSQL = `SELECT COALESCE(SUM(-p.amount) FILTER (WHERE e.created_at >= $2), 0), COUNT(*) FILTER (WHERE e.created_at >= $2),
+    COALESCE(SUM(-p.amount), 0), COUNT(*)
+FROM postings p
+JOIN journal_entries e ON e.id = p.entry_id
+WHERE p.account_id = $1 AND p.amount < 0 AND e.entry_type IN ('withdraw', 'transfer', 'capture') AND e.status <> 'failed' AND e.created_at >= $3`
+
+// minorUnits is how Redis counts amounts: in integer units of 10^-4, the
+// precision of NUMERIC(20, 4).
+const minorUnits = 4
+
+// limiter checks the outflow limits within the transaction of one request
+// and counts what it let through in Redis. The counters are a cache of the
+// ledger: when missing they are rebuilt from it, and when the transaction
+// fails undo drops them so they are rebuilt.
+type limiter struct {
+	s    Service
+	ctx  context.Context
+	rdb  *redis.Client
+	now  time.Time
+	used map[int]Usage
+	keys []string
+}
+
+func (s Service) limiter(ctx context.Context) *limiter {
+	l := &limiter{s: s, ctx: ctx, now: time.Now().UTC(), used: map[int]Usage{}}
+	if rdb, err := s.factory.Redis(); err == nil {
+		l.rdb = rdb.Client
+	} else {
+		s.factory.Logger().Warnw("limits fall back to the database", "error", err)
+	}
+	return l
+}
+
+// usage returns the outflow of accountID. It reads the Redis counters, or
+// the ledger when they are missing. With seed, which needs the account
+// locked so no debit is counted meanwhile, it then seeds the counters;
+// fields set in between are kept.
+func (l *limiter) usage(q querier, accountID int, seed bool) (Usage, error) {
+	day, month := usageKeys(accountID, l.now)
+
+	if l.rdb != nil {
+		cached, err := l.cached(day, month)
+		if err == nil {
+			return cached, nil
+		}
+		if err != redis.Nil {
+			l.s.factory.Logger().Warnw("limit counters unavailable", "error", err)
+		}
+	}
+
+	var u Usage
+	dayStart := l.now.Truncate(24 * time.Hour)
+	monthStart := time.Date(l.now.Year(), l.now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	err := q.QueryRowContext(l.ctx, outflowSQL, accountID, dayStart, monthStart).Scan(&u.Daily, &u.DailyCount, &u.Monthly, &u.MonthlyCount)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	if seed && l.rdb != nil {
+		pipe := l.rdb.TxPipeline()
+		l.seed(pipe, accountID, u)
+		if _, err = pipe.Exec(l.ctx); err != nil {
+			l.s.factory.Logger().Warnw("seeding limit counters failed", "error", err)
+		}
+	}
+	return u, nil
+}
+
+// seed queues setting the counters of accountID to u, keeping the fields
+// that are already set.
+func (l *limiter) seed(pipe redis.Pipeliner, accountID int, u Usage) {
+	day, month := usageKeys(accountID, l.now)
+	dayStart := l.now.Truncate(24 * time.Hour)
+	monthStart := time.Date(l.now.Year(), l.now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	pipe.HSetNX(l.ctx, day, "amount", units(u.Daily))
+	pipe.HSetNX(l.ctx, day, "count", u.DailyCount)
+	pipe.ExpireAt(l.ctx, day, dayStart.Add(25*time.Hour))
+	pipe.HSetNX(l.ctx, month, "amount", units(u.Monthly))
+	pipe.HSetNX(l.ctx, month, "count", u.MonthlyCount)
+	pipe.ExpireAt(l.ctx, month, monthStart.AddDate(0, 1, 0).Add(time.Hour))
+}
+
+func (l *limiter) cached(day, month string) (Usage, error) {
+	var u Usage
+	for _, c := range []struct {
+		key    string
+		amount *Money
+		count  *int
+	}{{day, &u.Daily, &u.DailyCount}, {month, &u.Monthly, &u.MonthlyCount}} {
+		v, err := l.rdb.HMGet(l.ctx, c.key, "amount", "count").Result()
+		if err != nil {
+			return Usage{}, err
+		}
+		amount, ok1 := v[0].(string)
+		count, ok2 := v[1].(string)
+		if !ok1 || !ok2 {
+			return Usage{}, redis.Nil
+		}
+		n, err := strconv.ParseInt(amount, 10, 64)
+		if err != nil {
+			return Usage{}, err
+		}
+		*c.amount = Money{d: decimal.New(n, -minorUnits)}
+		if *c.count, err = strconv.Atoi(count); err != nil {
+			return Usage{}, err
+		}
+	}
+	return u, nil
+}
+
+func units(m Money) int64 {
+	return m.d.Shift(minorUnits).IntPart()
+}
+
+// check reports a LimitError when debiting amount from accountID breaks one
+// of its limits. The account must stay locked until count, so no
+// concurrent debit can slip in between.
+func (l *limiter) check(tx *sql.Tx, accountID int, currency string, amount Money) error {
+	limits, err := l.s.accountLimits(l.ctx, tx, accountID, currency)
+	if err != nil || limits.empty() {
+		return err
+	}
+	used, ok := l.used[accountID]
+	if !ok {
+		if used, err = l.usage(tx, accountID, true); err != nil {
+			return err
+		}
+		l.used[accountID] = used
+	}
+	return exceeded(limits, used, amount)
+}
+
+// count adds a debit check let through to the usage of accountID. It runs
+// once the debit is posted, so the rest of the transaction sees it. The
+// counters are seeded first in the same transaction: if seeding failed or
+// they expired since, incrementing alone would leave them partial and too low.
+func (l *limiter) count(accountID int, amount Money) {
+	used, ok := l.used[accountID]
+	if !ok {
+		// the account has no limits
+		return
+	}
+	before := used
+	used.Daily, used.Monthly = used.Daily.Add(amount), used.Monthly.Add(amount)
+	used.DailyCount++
+	used.MonthlyCount++
+	l.used[accountID] = used
+
+	if l.rdb == nil {
+		return
+	}
+	day, month := usageKeys(accountID, l.now)
+	pipe := l.rdb.TxPipeline()
+	l.seed(pipe, accountID, before)
+	for _, key := range []string{day, month} {
+		pipe.HIncrBy(l.ctx, key, "amount", units(amount))
+		pipe.HIncrBy(l.ctx, key, "count", 1)
+	}
+	if _, err := pipe.Exec(l.ctx); err != nil {
+		l.s.factory.Logger().Warnw("counting outflow failed", "error", err)
+	}
+	l.keys = append(l.keys, day, month)
+}
+
+// undo drops the counters of a transaction that did not commit.
+func (l *limiter) undo() {
+	l.used = map[int]Usage{}
+	if l.rdb != nil && len(l.keys) > 0 {
+		l.rdb.Del(l.ctx, l.keys...)
+	}
+	l.keys = nil
+}
+
+// GetLimits returns the limits of the account of username in currency, and
+// its usage.
+func (s Service) GetLimits(ctx context.Context, username, currency string) (AccountLimits, error) {
+	c, err := ParseCurrency(currency)
+	if err != nil {
+		return AccountLimits{}, err
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return AccountLimits{}, err
+	}
+
+	var accountID sql.NullInt64
+	err = db.QueryRowContext(ctx, "SELECT a.id FROM users u LEFT JOIN accounts a ON a.user_id = u.id AND a.currency = $2 WHERE u.username = $1", username, c.Code).Scan(&accountID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccountLimits{}, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return AccountLimits{}, err
+	}
+
+	result := AccountLimits{Username: username, Currency: c.Code}
+	// a user who never held the currency has the configured limits, unused
+	if result.Limits, err = s.accountLimits(ctx, db, int(accountID.Int64), c.Code); err != nil {
+		return AccountLimits{}, err
+	}
+	if accountID.Valid {
+		// without the account lock the counters are read, never seeded
+		if result.Used, err = s.limiter(ctx).usage(db, int(accountID.Int64), false); err != nil {
+			return AccountLimits{}, err
+		}
+	}
+	result.Remaining = remaining(result.Limits, result.Used)
+	return result, nil
+}
+
+// SetLimits replaces the limit overrides of the account of username.
+func (s Service) SetLimits(ctx context.Context, username string, req LimitsRequest) (AccountLimits, error) {
+	c, err := ParseCurrency(req.Currency)
+	if err != nil {
+		return AccountLimits{}, err
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return AccountLimits{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return AccountLimits{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err = checkStatus(tx, username, false); err != nil {
+		return AccountLimits{}, err
+	}
+	accountID, err := userAccount(tx, username, c.Code)
+	if err != nil {
+		return AccountLimits{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO account_limits (account_id, per_transaction, daily, monthly, daily_count, monthly_count)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (account_id) DO UPDATE
+SET per_transaction = $2, daily = $3, monthly = $4, daily_count = $5, monthly_count = $6, updated_at = CURRENT_TIMESTAMP`,
+		accountID, req.PerTransaction, req.Daily, req.Monthly, req.DailyCount, req.MonthlyCount)
+	if err != nil {
+		return AccountLimits{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return AccountLimits{}, err
+	}
+
+	return s.GetLimits(ctx, username, c.Code)
+}
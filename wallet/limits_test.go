@@ -0,0 +1,50 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExceeded(t *testing.T) {
+	money := func(s string) *Money {
+		m := MustParseMoney(s)
+		return &m
+	}
+	count := func(n int) *int { return &n }
+
+	limits := Limits{PerTransaction: money("100"), Daily: money("150"), Monthly: money("1000"), DailyCount: count(3)}
+	used := Usage{Daily: MustParseMoney("120"), DailyCount: 1, Monthly: MustParseMoney("500"), MonthlyCount: 4}
+
+	cases := []struct {
+		amount    string
+		used      Usage
+		limit     string
+		remaining string
+	}{
+		{"10", used, "", ""},
+		{"30", used, "", ""},
+		{"100.01", Usage{}, "per_transaction", "100"},
+		{"30.01", used, "daily", "30"},
+		{"1", Usage{Daily: MustParseMoney("1"), DailyCount: 3}, "daily_count", "0"},
+		{"1", Usage{Monthly: MustParseMoney("1000")}, "monthly", "0"},
+	}
+	for _, c := range cases {
+		err := exceeded(limits, c.used, MustParseMoney(c.amount))
+		var limit *LimitError
+		switch {
+		case c.limit == "" && err != nil:
+			t.Errorf("%s: unexpected %v", c.amount, err)
+		case c.limit != "" && !errors.As(err, &limit):
+			t.Errorf("%s: expect %s exceeded, got %v", c.amount, c.limit, err)
+		case c.limit != "" && (limit.Limit != c.limit || limit.Remaining != c.remaining):
+			t.Errorf("%s: got %s with %s remaining, want %s with %s", c.amount, limit.Limit, limit.Remaining, c.limit, c.remaining)
+		}
+		if c.limit != "" && !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: a LimitError is ErrLimitExceeded", c.amount)
+		}
+	}
+
+	if err := exceeded(Limits{}, used, MustParseMoney("1000000")); err != nil {
+		t.Error("no limits, nothing exceeded:", err)
+	}
+}
@@ -1,15 +1,154 @@
 package wallet
 
+import (
+	"github.com/shopspring/decimal"
+)
+
+// User is a wallet. Balances is only filled in account details.
 type User struct {
-	ID       int     `json:"id"`
-	Username string  `json:"username"`
-	Balance  float64 `json:"balance"`
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Status    string    `json:"status"`
+	CreatedAt string    `json:"created_at"`
+	Balances  []Balance `json:"balances,omitempty"`
+}
+
+// Balance is what a user holds in one currency. Balance is the ledger
+// balance, moved by completed transactions only; Available is what can be
+// spent, which excludes pending debits.
+type Balance struct {
+	Currency  string `json:"currency"`
+	Balance   Money  `json:"balance"`
+	Available Money  `json:"available"`
+}
+
+// BalanceAt is the ledger balance of an account at At: the closing balance
+// of SnapshotDay, when a snapshot precedes At, plus what moved after it.
+type BalanceAt struct {
+	Currency    string  `json:"currency"`
+	Balance     Money   `json:"balance"`
+	At          string  `json:"at"`
+	SnapshotDay *string `json:"snapshot_day,omitempty"`
+}
+
+// Receipt is the outcome of a deposit or withdrawal: the transaction
+// recorded, the fee charged on top of it if any, and the balance it leaves.
+type Receipt struct {
+	TransactionID int    `json:"transaction_id"`
+	Status        string `json:"status"`
+	Fee           *Money `json:"fee,omitempty"`
+	Balance
+}
+
+// Transfer moves Amount from one user to another, who is credited ToAmount
+// in ToCurrency; both legs are postings of the journal entry TransactionID.
+type Transfer struct {
+	ID            int    `json:"id"`
+	TransactionID int    `json:"transaction_id"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Amount        Money  `json:"amount"`
+	Currency      string `json:"currency"`
+	ToAmount      Money  `json:"to_amount"`
+	ToCurrency    string `json:"to_currency"`
+	// Fee is charged to the sender on top of Amount.
+	Fee Money `json:"fee"`
+	// Rate is the exchange rate applied by a conversion.
+	Rate      decimal.NullDecimal `json:"rate"`
+	Memo      string              `json:"memo,omitempty"`
+	Reference string              `json:"reference,omitempty"`
+	Status    string              `json:"status"`
+	CreatedAt string              `json:"created_at"`
+}
+
+// Schedule is a standing order paying Amount from one user to another: once
+// at NextRunAt, or on every occurrence of Cron or IntervalSeconds. Attempts
+// counts the failed attempts at the occurrence due.
+type Schedule struct {
+	ID                   int     `json:"id"`
+	From                 string  `json:"from"`
+	To                   string  `json:"to"`
+	Amount               Money   `json:"amount"`
+	Currency             string  `json:"currency"`
+	Memo                 string  `json:"memo,omitempty"`
+	Cron                 string  `json:"cron,omitempty"`
+	IntervalSeconds      int     `json:"interval_seconds,omitempty"`
+	MaxRetries           int     `json:"max_retries"`
+	RetryIntervalSeconds int     `json:"retry_interval_seconds"`
+	Status               string  `json:"status"`
+	Attempts             int     `json:"attempts"`
+	NextRunAt            *string `json:"next_run_at,omitempty"`
+	CreatedAt            string  `json:"created_at"`
+}
+
+// ScheduleRun is one attempt at paying a schedule: the transfer it made, or
+// the error code and message of why it could not.
+type ScheduleRun struct {
+	ID         int    `json:"id"`
+	ScheduleID int    `json:"schedule_id"`
+	DueAt      string `json:"due_at"`
+	Attempt    int    `json:"attempt"`
+	Status     string `json:"status"`
+	TransferID *int   `json:"transfer_id,omitempty"`
+	Code       string `json:"code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// Hold reserves Amount of a user's balance until it is captured, voided or
+// expires. Captured is the part that was debited, through TransactionID.
+type Hold struct {
+	ID            int     `json:"id"`
+	Username      string  `json:"username"`
+	Currency      string  `json:"currency"`
+	Amount        Money   `json:"amount"`
+	Captured      *Money  `json:"captured,omitempty"`
+	TransactionID *int    `json:"transaction_id,omitempty"`
+	Status        string  `json:"status"`
+	ExpiresAt     string  `json:"expires_at"`
+	CreatedAt     string  `json:"created_at"`
+	ClosedAt      *string `json:"closed_at,omitempty"`
+}
+
+// Movement is a transaction as a whole, with the time of every status
+// transition it went through.
+type Movement struct {
+	ID          int     `json:"id"`
+	Type        string  `json:"type"`
+	Status      string  `json:"status"`
+	ReversesID  *int    `json:"reverses_id,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+	CompletedAt *string `json:"completed_at,omitempty"`
+	FailedAt    *string `json:"failed_at,omitempty"`
+	ReversedAt  *string `json:"reversed_at,omitempty"`
 }
 
+// Transaction is one posting to a user account, as shown in the history.
+// ID is its journal entry's, the id receipts return and settle, fail and
+// reverse take; postings written by the same entry share it. PostingID
+// tells them apart and orders the history.
 type Transaction struct {
-	ID              int     `json:"id"`
-	UserID          int     `json:"user_id"`
-	Amount          float64 `json:"amount"`
-	TransactionType string  `json:"transaction_type"`
-	CreatedAt       string  `json:"created_at"`
+	ID              int    `json:"id"`
+	PostingID       int    `json:"posting_id"`
+	UserID          int    `json:"user_id"`
+	Amount          Money  `json:"amount"`
+	Currency        string `json:"currency"`
+	TransactionType string `json:"transaction_type"`
+	// Rate is the exchange rate applied by a conversion transfer.
+	Rate        decimal.NullDecimal `json:"rate"`
+	Status      string              `json:"status"`
+	CreatedAt   string              `json:"created_at"`
+	CompletedAt *string             `json:"completed_at,omitempty"`
+	FailedAt    *string             `json:"failed_at,omitempty"`
+	ReversedAt  *string             `json:"reversed_at,omitempty"`
+	// ReversesID is the transaction a reversal refunds; Reversals are the
+	// reversals refunding this one.
+	ReversesID *int    `json:"reverses_id,omitempty"`
+	Reversals  []int64 `json:"reversals,omitempty"`
+	// TransferID is the transfer a transfer posting belongs to, and
+	// Counterparty the user on its other side.
+	TransferID   *int   `json:"transfer_id,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`
+	// FeeFor is the transaction a fee was charged for.
+	FeeFor *int `json:"fee_for,omitempty"`
 }
@@ -0,0 +1,156 @@
+package wallet
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"math/big"
+)
+
+// Money is an exact decimal amount. It never passes through binary floating
+// point, neither in JSON, in Redis nor in Postgres (NUMERIC columns).
+type Money struct {
+	d decimal.Decimal
+}
+
+// maxMoneyDigits bounds the digits of a parsed amount and its exponent
+// either way. It is far beyond any real amount, and keeps arithmetic on
+// amounts like 1e-60000 from a request from growing without limit.
+const maxMoneyDigits = 40
+
+// ParseMoney parses a decimal amount, plain or in exponent notation.
+func ParseMoney(s string) (Money, error) {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return Money{}, errors.Wrapf(ErrInvalidMoney, "%q", s)
+	}
+	if exp := d.Exponent(); exp < -maxMoneyDigits || exp > maxMoneyDigits || d.NumDigits() > maxMoneyDigits {
+		return Money{}, errors.Wrapf(ErrInvalidMoney, "%q is out of range", s)
+	}
+	return Money{d: d}, nil
+}
+
+// MustParseMoney is like ParseMoney but panics on malformed input.
+// It is meant for constants in fixtures and tests.
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m Money) Add(o Money) Money { return Money{d: m.d.Add(o.d)} }
+
+func (m Money) Sub(o Money) Money { return Money{d: m.d.Sub(o.d)} }
+
+func (m Money) Neg() Money { return Money{d: m.d.Neg()} }
+
+func (m Money) Cmp(o Money) int { return m.d.Cmp(o.d) }
+
+func (m Money) Equal(o Money) bool { return m.d.Equal(o.d) }
+
+func (m Money) LessThan(o Money) bool { return m.d.LessThan(o.d) }
+
+func (m Money) IsZero() bool { return m.d.IsZero() }
+
+func (m Money) IsPositive() bool { return m.d.IsPositive() }
+
+func (m Money) IsNegative() bool { return m.d.IsNegative() }
+
+// Convert prices m at rate and rounds down to the minor unit of to, so a
+// conversion never credits more than was paid for.
+func (m Money) Convert(rate decimal.Decimal, to Currency) Money {
+	return Money{d: m.d.Mul(rate).RoundFloor(int32(to.Exponent))}
+}
+
+// Share returns the part/whole share of m, truncated toward zero to the
+// minor unit of c. Shares of opposite amounts are opposite, so an entry
+// scaled posting by posting stays balanced.
+func (m Money) Share(part, whole Money, c Currency) Money {
+	return Money{d: m.d.Mul(part.d).Div(whole.d).Truncate(int32(c.Exponent))}
+}
+
+// Places returns the number of significant digits after the decimal point,
+// e.g. 1.230 has two places.
+func (m Money) Places() int {
+	exp := m.d.Exponent()
+	if exp >= 0 {
+		return 0
+	}
+	// drop the trailing zeros of the coefficient, at most -exp of them
+	c, q, r := m.d.Coefficient(), new(big.Int), new(big.Int)
+	for ; exp < 0 && c.Sign() != 0; exp++ {
+		if q.QuoRem(c, ten, r); r.Sign() != 0 {
+			break
+		}
+		c, q = q, c
+	}
+	if c.Sign() == 0 {
+		return 0
+	}
+	return int(-exp)
+}
+
+var ten = big.NewInt(10)
+
+func (m Money) String() string { return m.d.String() }
+
+// StringFixed formats m with exactly places digits after the decimal point.
+func (m Money) StringFixed(places int) string { return m.d.StringFixed(int32(places)) }
+
+// MarshalJSON writes m as a JSON number literal, so the exact decimal text is
+// preserved on the wire.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.d.String()), nil
+}
+
+// UnmarshalJSON accepts both a number literal (1.23) and a string ("1.23").
+// The literal is parsed as text, never as float64.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		*m = Money{}
+		return nil
+	}
+	s := string(bytes.Trim(b, `"`))
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Scan implements sql.Scanner for NUMERIC columns.
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+		return nil
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	case int64:
+		*m = Money{d: decimal.NewFromInt(v)}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+}
+
+func (m *Money) scanString(s string) error {
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Value implements driver.Valuer, sending m to Postgres as exact text.
+func (m Money) Value() (driver.Value, error) {
+	return m.d.String(), nil
+}
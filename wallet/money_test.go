@@ -0,0 +1,90 @@
+package wallet_test
+
+import (
+	"encoding/json"
+	"github.com/bitmyth/walletserivce/wallet"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	m, err := wallet.ParseMoney("0.1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sum := m.Add(wallet.MustParseMoney("0.2"))
+	if !sum.Equal(wallet.MustParseMoney("0.3")) {
+		t.Error("0.1 + 0.2 should be exactly 0.3, got", sum)
+	}
+
+	if _, err = wallet.ParseMoney("1e"); err == nil {
+		t.Error("expect return err")
+	}
+
+	// absurd exponents are refused before any arithmetic
+	for _, s := range []string{"1e-60000", "1e60000", "12345678901234567890123456789012345678901"} {
+		if _, err = wallet.ParseMoney(s); err == nil {
+			t.Error(s, "expect return err")
+		}
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	var req wallet.Request
+	if err := json.Unmarshal([]byte(`{"username":"user1","amount":100.10}`), &req); err != nil {
+		t.Error(err)
+		return
+	}
+	if req.Amount.String() != "100.1" {
+		t.Error("unexpected amount", req.Amount)
+	}
+
+	if err := json.Unmarshal([]byte(`{"username":"user1","amount":"0.07"}`), &req); err != nil {
+		t.Error(err)
+		return
+	}
+	marshal, _ := json.Marshal(req.Amount)
+	if string(marshal) != `0.07` {
+		t.Error("unexpected json", string(marshal))
+	}
+
+	if err := json.Unmarshal([]byte(`{"amount":"abc"}`), &req); err == nil {
+		t.Error("expect return err")
+	}
+}
+
+func TestMoney_Places(t *testing.T) {
+	cases := map[string]int{"0": 0, "0.000": 0, "100": 0, "1e3": 0, "100.10": 1, "1.23": 2, "0.123456": 6, "1.2300": 2, "-1.50": 1, "1e-40": 40}
+	for s, places := range cases {
+		if got := wallet.MustParseMoney(s).Places(); got != places {
+			t.Errorf("%s: expect %d places, got %d", s, places, got)
+		}
+	}
+}
+
+func TestCurrency_Check(t *testing.T) {
+	usd, _ := wallet.LookupCurrency("USD")
+	if err := usd.Check(wallet.MustParseMoney("1.23")); err != nil {
+		t.Error(err)
+	}
+	if err := usd.Check(wallet.MustParseMoney("0.123456")); err == nil {
+		t.Error("expect return err")
+	}
+
+	jpy, _ := wallet.LookupCurrency("JPY")
+	if err := jpy.Check(wallet.MustParseMoney("1.5")); err == nil {
+		t.Error("expect return err")
+	}
+}
+
+func TestMoney_Share(t *testing.T) {
+	usd, _ := wallet.ParseCurrency("USD")
+	third := wallet.MustParseMoney("10").Share(wallet.MustParseMoney("1"), wallet.MustParseMoney("3"), usd)
+	if third.String() != "3.33" {
+		t.Error("expect 3.33, got", third)
+	}
+	neg := wallet.MustParseMoney("-10").Share(wallet.MustParseMoney("1"), wallet.MustParseMoney("3"), usd)
+	if !neg.Equal(third.Neg()) {
+		t.Error("shares of opposite amounts should be opposite, got", neg)
+	}
+}
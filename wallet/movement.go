@@ -0,0 +1,383 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// beforeCommit runs inside the transaction moving money, right before it
+// commits, with what the movement will return. The controller uses it to
+// store the response of an idempotent request (see remember).
+type beforeCommit func(tx *sql.Tx, result any) error
+
+// checkAmount validates amount in currency and returns the currency.
+func checkAmount(amount Money, code string) (Currency, error) {
+	currency, err := ParseCurrency(code)
+	if err != nil {
+		return Currency{}, err
+	}
+	if !amount.IsPositive() {
+		return Currency{}, errors.Wrapf(ErrInvalidAmount, "%s", amount)
+	}
+	return currency, currency.Check(amount)
+}
+
+// Deposit credits req.Amount to the user. A pending deposit is recorded
+// but only credited once settled.
+func (s Service) Deposit(ctx context.Context, req Request, hook beforeCommit) (Receipt, error) {
+	currency, err := checkAmount(req.Amount, req.Currency)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	var tx *sql.Tx
+
+	defer func() {
+		if err != nil && tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var accountID, cashInID int
+	result := Receipt{Status: req.status()}
+
+	steps := []func(){
+		func() {
+			tx, err = db.BeginTx(ctx, nil)
+		},
+		func() {
+			err = checkStatus(tx, req.Username, false)
+		},
+		func() {
+			accountID, err = userAccount(tx, req.Username, currency.Code)
+		},
+		func() {
+			cashInID, err = systemAccount(tx, SystemCashIn, currency.Code)
+		},
+		// money comes in from outside the wallet, so cash-in is the counter-party
+		func() {
+			result.TransactionID, err = post(tx, Entry{Type: "deposit", Status: result.Status, Postings: []Posting{
+				{AccountID: accountID, Currency: currency.Code, Amount: req.Amount},
+				{AccountID: cashInID, Currency: currency.Code, Amount: req.Amount.Neg()},
+			}})
+		},
+		func() {
+			result.Balance, err = accountBalance(tx, accountID)
+		},
+		func() {
+			err = hook(tx, result)
+		},
+		func() {
+			err = tx.Commit()
+		},
+	}
+
+	for _, step := range steps {
+		if step(); err != nil {
+			return Receipt{}, err
+		}
+	}
+
+	s.forget(ctx, BalanceKey(req.Username, currency.Code))
+
+	return result, nil
+}
+
+// Withdraw debits req.Amount from the user. A pending withdrawal reserves
+// the amount, so it is no longer available, but only debits it once settled.
+func (s Service) Withdraw(ctx context.Context, req Request, hook beforeCommit) (Receipt, error) {
+	currency, err := checkAmount(req.Amount, req.Currency)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	var tx *sql.Tx
+
+	defer func() {
+		if err != nil && tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var accountID, cashOutID int
+	var fee Money
+	result := Receipt{Status: req.status()}
+	lim := s.limiter(ctx)
+	defer func() {
+		if err != nil {
+			lim.undo()
+		}
+	}()
+
+	steps := []func(){
+		func() {
+			tx, err = db.BeginTx(ctx, nil)
+		},
+		func() {
+			err = checkStatus(tx, req.Username, true)
+		},
+		func() {
+			accountID, err = userAccount(tx, req.Username, currency.Code)
+		},
+		func() {
+			fee, err = s.fee("withdraw", req.Amount, currency)
+		},
+		// the account stays locked until commit, so no concurrent debit can
+		// spend the same money between the check and the posting
+		func() {
+			err = lockFunds(tx, accountID, req.Amount.Add(fee))
+		},
+		func() {
+			err = lim.check(tx, accountID, currency.Code, req.Amount)
+		},
+		func() {
+			cashOutID, err = systemAccount(tx, SystemCashOut, currency.Code)
+		},
+		func() {
+			result.TransactionID, err = post(tx, Entry{Type: "withdraw", Status: result.Status, Postings: []Posting{
+				{AccountID: accountID, Currency: currency.Code, Amount: req.Amount.Neg()},
+				{AccountID: cashOutID, Currency: currency.Code, Amount: req.Amount},
+			}})
+		},
+		func() {
+			err = chargeFee(tx, result.TransactionID, accountID, fee, currency.Code, result.Status)
+		},
+		func() {
+			lim.count(accountID, req.Amount)
+			if fee.IsPositive() {
+				result.Fee = &fee
+			}
+			result.Balance, err = accountBalance(tx, accountID)
+		},
+		func() {
+			err = hook(tx, result)
+		},
+		func() {
+			err = tx.Commit()
+		},
+	}
+
+	for _, step := range steps {
+		if step(); err != nil {
+			return Receipt{}, err
+		}
+	}
+
+	s.forget(ctx, BalanceKey(req.Username, currency.Code))
+
+	return result, nil
+}
+
+// conversion is the credit side of a transfer.
+type conversion struct {
+	currency Currency
+	amount   Money
+	rate     decimal.NullDecimal
+}
+
+func (s Service) conversion(ctx context.Context, req TransferRequest, from Currency) (conversion, error) {
+	to, err := ParseCurrency(req.ToCurrency)
+	if err != nil {
+		return conversion{}, err
+	}
+	if req.ToCurrency == "" || to == from {
+		return conversion{currency: from, amount: req.Amount}, nil
+	}
+	if req.QuoteID == "" {
+		return conversion{}, ErrCrossCurrency
+	}
+
+	quote, err := s.factory.FX().Lookup(ctx, req.QuoteID)
+	if err != nil {
+		return conversion{}, err
+	}
+	if quote.From != from.Code || quote.To != to.Code {
+		return conversion{}, errors.Wrapf(ErrQuoteMismatch, "quote is for %s/%s", quote.From, quote.To)
+	}
+
+	amount := req.Amount.Convert(quote.Rate, to)
+	if !amount.IsPositive() {
+		return conversion{}, ErrTooSmall
+	}
+
+	return conversion{currency: to, amount: amount, rate: decimal.NewNullDecimal(quote.Rate)}, nil
+}
+
+// leg is a transfer request checked and priced, ready to be posted. The
+// sender pays fee on top of the amount.
+type leg struct {
+	TransferRequest
+	currency Currency
+	conv     conversion
+	fee      Money
+}
+
+// prepare checks req and locks in its conversion. It needs no transaction.
+func (s Service) prepare(ctx context.Context, req TransferRequest) (leg, error) {
+	currency, err := checkAmount(req.Amount, req.Currency)
+	if err != nil {
+		return leg{}, err
+	}
+	conv, err := s.conversion(ctx, req, currency)
+	if err != nil {
+		return leg{}, err
+	}
+	if req.From == req.To && currency == conv.currency {
+		return leg{}, errors.Wrapf(ErrSelfTransfer, "%q", req.From)
+	}
+	fee, err := s.fee("transfer", req.Amount, currency)
+	if err != nil {
+		return leg{}, err
+	}
+	return leg{TransferRequest: req, currency: currency, conv: conv, fee: fee}, nil
+}
+
+// keys are the cached balances a leg changes.
+func (l leg) keys() []string {
+	return []string{BalanceKey(l.From, l.currency.Code), BalanceKey(l.To, l.conv.currency.Code)}
+}
+
+// post records the leg within tx, within the sender's limits.
+func (l leg) post(tx *sql.Tx, lim *limiter) (Transfer, error) {
+	var err error
+	var fromID, toID, entryID int
+	var transfer Transfer
+	entry := Entry{Type: "transfer", Rate: l.conv.rate}
+
+	steps := []func(){
+		// frozen receivers can still be paid
+		func() { err = checkStatus(tx, l.From, true) },
+		func() { err = checkStatus(tx, l.To, false) },
+		// the receiver may not hold the credited currency yet
+		func() { fromID, err = userAccount(tx, l.From, l.currency.Code) },
+		func() { toID, err = userAccount(tx, l.To, l.conv.currency.Code) },
+		// lock sender and receiver balance, always in the same order so
+		// opposing transfers cannot deadlock
+		func() { err = lockAccounts(tx, fromID, toID) },
+		func() { err = lockFunds(tx, fromID, l.Amount.Add(l.fee)) },
+		func() { err = lim.check(tx, fromID, l.currency.Code, l.Amount) },
+		// withdraw from sender, deposit to receiver
+		func() {
+			entry.Postings = []Posting{
+				{AccountID: fromID, Currency: l.currency.Code, Amount: l.Amount.Neg()},
+				{AccountID: toID, Currency: l.conv.currency.Code, Amount: l.conv.amount},
+			}
+		},
+		// a conversion goes through the fx account, which buys the sender's
+		// currency and sells the receiver's
+		func() {
+			if !l.conv.rate.Valid {
+				return
+			}
+			var buyID, sellID int
+			if buyID, err = systemAccount(tx, SystemFX, l.currency.Code); err != nil {
+				return
+			}
+			if sellID, err = systemAccount(tx, SystemFX, l.conv.currency.Code); err != nil {
+				return
+			}
+			entry.Postings = append(entry.Postings,
+				Posting{AccountID: buyID, Currency: l.currency.Code, Amount: l.Amount},
+				Posting{AccountID: sellID, Currency: l.conv.currency.Code, Amount: l.conv.amount.Neg()},
+			)
+		},
+		// both legs are postings of one entry, which points at the transfer
+		func() { entry.Transfer, err = insertTransfer(tx, l.TransferRequest, l.currency, l.conv) },
+		func() { entryID, err = post(tx, entry) },
+		func() { err = chargeFee(tx, entryID, fromID, l.fee, l.currency.Code, TransactionCompleted) },
+		func() { transfer, err = scanTransfer(tx.QueryRow(transferSQL, entry.Transfer)) },
+		func() { lim.count(fromID, l.Amount) },
+	}
+
+	for _, step := range steps {
+		if step(); err != nil {
+			return Transfer{}, err
+		}
+	}
+	return transfer, nil
+}
+
+// Transfer moves req.Amount from one user to another, converting it when
+// the receiver is credited in another currency. A transfer Postgres keeps
+// aborting because of lock contention ends in ErrConflict.
+func (s Service) Transfer(ctx context.Context, req TransferRequest, hook beforeCommit) (Transfer, error) {
+	l, err := s.prepare(ctx, req)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	var tx *sql.Tx
+
+	defer func() {
+		if err != nil && tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var transfer Transfer
+	lim := s.limiter(ctx)
+	defer func() {
+		if err != nil {
+			lim.undo()
+		}
+	}()
+
+	steps := []func(){
+		// start a transaction
+		func() { tx, err = db.BeginTx(ctx, nil) },
+		func() { transfer, err = l.post(tx, lim) },
+		func() { err = hook(tx, transfer) },
+		func() { err = tx.Commit() },
+	}
+
+	run := func() error {
+		for _, step := range steps {
+			if step(); err != nil {
+				break
+			}
+		}
+		return err
+	}
+	rollback := func() {
+		_ = tx.Rollback()
+		lim.undo()
+	}
+	if err = s.retry("transfer", run, rollback); err != nil {
+		return Transfer{}, err
+	}
+
+	// del cache for both users
+	s.forget(ctx, l.keys()...)
+
+	return transfer, nil
+}
+
+// forget drops cached balances after a movement committed.
+func (s Service) forget(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	rdb, err := s.factory.Redis()
+	if err != nil {
+		s.factory.Logger().Warn(err)
+		return
+	}
+	rdb.Del(ctx, keys...)
+}
@@ -0,0 +1,40 @@
+package wallet
+
+import (
+	"context"
+	"github.com/bitmyth/walletserivce/fx"
+)
+
+// QuoteRequest asks for the rate of From/To. With an Amount in From the
+// quote also tells what it converts to.
+type QuoteRequest struct {
+	From   string `json:"from" binding:"required,currency"`
+	To     string `json:"to" binding:"required,currency"`
+	Amount *Money `json:"amount" binding:"omitempty,money"`
+}
+
+// FXQuote is a quote locking a rate (see TransferRequest.QuoteID), and
+// Amount converted at it when the request had one.
+type FXQuote struct {
+	fx.Quote
+	Amount    *Money `json:"amount,omitempty"`
+	Converted *Money `json:"converted,omitempty"`
+}
+
+// Quote locks the current rate of req.From to req.To.
+func (s Service) Quote(ctx context.Context, req QuoteRequest) (FXQuote, error) {
+	from, _ := ParseCurrency(req.From)
+	to, _ := ParseCurrency(req.To)
+
+	quote, err := s.factory.FX().Quote(ctx, from.Code, to.Code)
+	if err != nil {
+		return FXQuote{}, err
+	}
+
+	q := FXQuote{Quote: quote, Amount: req.Amount}
+	if req.Amount != nil {
+		converted := req.Amount.Convert(quote.Rate, to)
+		q.Converted = &converted
+	}
+	return q, nil
+}
@@ -0,0 +1,298 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// DefaultReconcileInterval is how often the ledger is checked unless
+// configured.
+const DefaultReconcileInterval = time.Hour
+
+// Kinds of discrepancies a reconciliation reports.
+const (
+	// BalanceDrift is a user account whose balance is not the sum of its
+	// completed postings.
+	BalanceDrift = "balance_drift"
+	// CacheDrift is a cached balance that disagrees with Postgres.
+	CacheDrift = "cache_drift"
+	// UnbalancedEntry is a journal entry whose postings do not sum to zero
+	// in a currency.
+	UnbalancedEntry = "unbalanced_entry"
+	// OrphanEntry is a journal entry without postings.
+	OrphanEntry = "orphan_entry"
+	// OrphanTransfer is a transfer no journal entry records.
+	OrphanTransfer = "orphan_transfer"
+)
+
+// Discrepancy is one thing a reconciliation found wrong: what Expected
+// says it should be, and what it Actual is.
+type Discrepancy struct {
+	Kind       string `json:"kind"`
+	AccountID  int    `json:"account_id,omitempty"`
+	EntryID    int    `json:"entry_id,omitempty"`
+	TransferID int    `json:"transfer_id,omitempty"`
+	Username   string `json:"username,omitempty"`
+	Currency   string `json:"currency,omitempty"`
+	Expected   string `json:"expected,omitempty"`
+	Actual     string `json:"actual,omitempty"`
+	// Repaired is set once a drifting cache entry was dropped.
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+// Reconciliation is the report of one run over the whole ledger.
+type Reconciliation struct {
+	StartedAt     string        `json:"started_at"`
+	FinishedAt    string        `json:"finished_at"`
+	Accounts      int           `json:"accounts"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// balanceDriftSQL lists the user accounts whose balance is not the sum of
+// the postings of their entries that moved it.
+const balanceDriftSQL = `SELECT a.id, u.username, a.currency, a.balance,
+    COALESCE(SUM(p.amount) FILTER (WHERE e.status IN ('completed', 'reversed')), 0) AS ledger
+FROM accounts a
+JOIN users u ON u.id = a.user_id
+LEFT JOIN postings p ON p.account_id = a.id
+LEFT JOIN journal_entries e ON e.id = p.entry_id
+GROUP BY a.id, u.username
+HAVING a.balance <> COALESCE(SUM(p.amount) FILTER (WHERE e.status IN ('completed', 'reversed')), 0)
+ORDER BY a.id`
+
+const unbalancedEntrySQL = `SELECT entry_id, currency, SUM(amount)
+FROM postings
+GROUP BY entry_id, currency
+HAVING SUM(amount) <> 0
+ORDER BY entry_id`
+
+const orphanEntrySQL = `SELECT e.id FROM journal_entries e
+WHERE NOT EXISTS (SELECT 1 FROM postings p WHERE p.entry_id = e.id)
+ORDER BY e.id`
+
+const orphanTransferSQL = `SELECT t.id FROM transfers t
+WHERE NOT EXISTS (SELECT 1 FROM journal_entries e WHERE e.transfer_id = t.id)
+ORDER BY t.id`
+
+// Reconcile checks the ledger: user account balances against their
+// postings, entries against double entry, entries and transfers missing
+// their other half, and cached balances against Postgres. With repair,
+// drifting cached balances are dropped, to be read from Postgres again.
+func (s Service) Reconcile(ctx context.Context, repair bool) (Reconciliation, error) {
+	report := Reconciliation{StartedAt: time.Now().UTC().Format(time.RFC3339Nano), Discrepancies: []Discrepancy{}}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return Reconciliation{}, err
+	}
+
+	if err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM accounts WHERE user_id IS NOT NULL").Scan(&report.Accounts); err != nil {
+		return Reconciliation{}, err
+	}
+
+	rows, err := db.QueryContext(ctx, balanceDriftSQL)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	for rows.Next() {
+		d := Discrepancy{Kind: BalanceDrift}
+		var balance, ledger Money
+		if err = rows.Scan(&d.AccountID, &d.Username, &d.Currency, &balance, &ledger); err != nil {
+			_ = rows.Close()
+			return Reconciliation{}, err
+		}
+		d.Expected, d.Actual = ledger.String(), balance.String()
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return Reconciliation{}, err
+	}
+
+	rows, err = db.QueryContext(ctx, unbalancedEntrySQL)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	for rows.Next() {
+		d := Discrepancy{Kind: UnbalancedEntry, Expected: "0"}
+		var sum Money
+		if err = rows.Scan(&d.EntryID, &d.Currency, &sum); err != nil {
+			_ = rows.Close()
+			return Reconciliation{}, err
+		}
+		d.Actual = sum.String()
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return Reconciliation{}, err
+	}
+
+	for _, orphans := range []struct {
+		query string
+		kind  string
+	}{{orphanEntrySQL, OrphanEntry}, {orphanTransferSQL, OrphanTransfer}} {
+		rows, err = db.QueryContext(ctx, orphans.query)
+		if err != nil {
+			return Reconciliation{}, err
+		}
+		for rows.Next() {
+			d := Discrepancy{Kind: orphans.kind}
+			id := &d.EntryID
+			if orphans.kind == OrphanTransfer {
+				id = &d.TransferID
+			}
+			if err = rows.Scan(id); err != nil {
+				_ = rows.Close()
+				return Reconciliation{}, err
+			}
+			report.Discrepancies = append(report.Discrepancies, d)
+		}
+		_ = rows.Close()
+		if err = rows.Err(); err != nil {
+			return Reconciliation{}, err
+		}
+	}
+
+	drift, err := s.cacheDrift(ctx, repair)
+	if err != nil {
+		return Reconciliation{}, err
+	}
+	report.Discrepancies = append(report.Discrepancies, drift...)
+
+	report.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	return report, nil
+}
+
+// cachedBalance is a balance as cached in Redis, or an error when the cache
+// holds something else.
+func cachedBalance(b []byte) (Balance, error) {
+	var balance Balance
+	err := json.Unmarshal(b, &balance)
+	return balance, err
+}
+
+func sameBalance(a, b Balance) bool {
+	return a.Balance.Equal(b.Balance) && a.Available.Equal(b.Available)
+}
+
+// cacheDrift compares every cached balance with Postgres. A movement
+// commits before it drops the cached balances it changed, so a mismatch is
+// only reported when it is still there once both are read again.
+func (s Service) cacheDrift(ctx context.Context, repair bool) ([]Discrepancy, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return nil, err
+	}
+	rdb, err := s.factory.Redis()
+	if err != nil {
+		return nil, err
+	}
+
+	const query = "SELECT a.id, u.username, a.currency, a.balance, " + availableSQL + " FROM accounts a JOIN users u ON u.id = a.user_id"
+	read := func(d *Discrepancy) (actual Balance, cached []byte, err error) {
+		err = db.QueryRowContext(ctx, query+" WHERE a.id = $1", d.AccountID).Scan(&d.AccountID, &d.Username, &d.Currency, &actual.Balance, &actual.Available)
+		if err != nil {
+			return Balance{}, nil, err
+		}
+		cached, err = rdb.Get(ctx, BalanceKey(d.Username, d.Currency)).Bytes()
+		return actual, cached, err
+	}
+
+	rows, err := db.QueryContext(ctx, query+" ORDER BY a.id")
+	if err != nil {
+		return nil, err
+	}
+	type account struct {
+		Discrepancy
+		actual Balance
+	}
+	var accounts []account
+	for rows.Next() {
+		a := account{Discrepancy: Discrepancy{Kind: CacheDrift}}
+		if err = rows.Scan(&a.AccountID, &a.Username, &a.Currency, &a.actual.Balance, &a.actual.Available); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var drift []Discrepancy
+	for _, a := range accounts {
+		b, err := rdb.Get(ctx, BalanceKey(a.Username, a.Currency)).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cached, err := cachedBalance(b); err == nil && sameBalance(cached, a.actual) {
+			continue
+		}
+
+		d := a.Discrepancy
+		actual, b, err := read(&d)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cached, err := cachedBalance(b)
+		if err == nil && sameBalance(cached, actual) {
+			continue
+		}
+
+		actual.Currency = d.Currency
+		d.Expected, d.Actual = string(mustJSON(actual)), string(b)
+		if repair {
+			if err = rdb.Del(ctx, BalanceKey(d.Username, d.Currency)).Err(); err != nil {
+				return nil, err
+			}
+			d.Repaired = true
+		}
+		drift = append(drift, d)
+	}
+	return drift, nil
+}
+
+func mustJSON(v any) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// RunReconciliation reconciles the ledger every interval until ctx is done,
+// logging what it finds.
+func (s Service) RunReconciliation(ctx context.Context) {
+	c := s.factory.Config().Reconcile
+	every := c.Interval
+	if every <= 0 {
+		every = DefaultReconcileInterval
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	logger := s.factory.Logger()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := s.Reconcile(ctx, c.RepairCache)
+			if err != nil {
+				logger.Errorw("reconciliation failed", "error", err)
+				continue
+			}
+			if len(report.Discrepancies) > 0 {
+				logger.Warnw("ledger discrepancies found", "report", string(mustJSON(report)))
+			}
+		}
+	}
+}
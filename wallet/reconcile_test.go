@@ -0,0 +1,30 @@
+package wallet
+
+import "testing"
+
+func TestCachedBalance(t *testing.T) {
+	ledger := Balance{Currency: "USD", Balance: MustParseMoney("10"), Available: MustParseMoney("7.5")}
+
+	cases := []struct {
+		cached string
+		same   bool
+	}{
+		{`{"currency":"USD","balance":"10","available":"7.5"}`, true},
+		{`{"currency":"USD","balance":"10.00","available":"7.50"}`, true},
+		{`{"currency":"USD","balance":"10","available":"10"}`, false},
+		{`{"currency":"USD","balance":"9","available":"7.5"}`, false},
+	}
+	for _, c := range cases {
+		cached, err := cachedBalance([]byte(c.cached))
+		if err != nil {
+			t.Fatal(c.cached, err)
+		}
+		if same := sameBalance(cached, ledger); same != c.same {
+			t.Errorf("%s: expect same %v, got %v", c.cached, c.same, same)
+		}
+	}
+
+	if _, err := cachedBalance([]byte("not json")); err == nil {
+		t.Error("expect an error for a corrupt cache entry")
+	}
+}
@@ -0,0 +1,62 @@
+package wallet_test
+
+import (
+	"context"
+	"github.com/bitmyth/walletserivce/wallet"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	username := holdUser(t, "100")
+
+	db, _ := f.DB()
+	var accountID int
+	err := db.QueryRow("SELECT a.id FROM accounts a JOIN users u ON u.id = a.user_id WHERE u.username = $1 AND a.currency = 'USD'", username).Scan(&accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the balance drifts from the ledger, the cache from Postgres, and an
+	// entry lost its postings
+	if _, err = db.Exec("UPDATE accounts SET balance = balance + 1 WHERE id = $1", accountID); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = db.Exec("UPDATE accounts SET balance = balance - 1 WHERE id = $1", accountID) }()
+
+	var orphanID int
+	if err = db.QueryRow("INSERT INTO journal_entries (entry_type) VALUES ('deposit') RETURNING id").Scan(&orphanID); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = db.Exec("DELETE FROM journal_entries WHERE id = $1", orphanID) }()
+
+	rdb, _ := f.Redis()
+	key := wallet.BalanceKey(username, "USD")
+	if err = rdb.Set(ctx, key, `{"currency":"USD","balance":"5","available":"5"}`, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := wallet.NewService(f).Reconcile(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]wallet.Discrepancy{}
+	for _, d := range report.Discrepancies {
+		if d.AccountID == accountID || d.EntryID == orphanID {
+			found[d.Kind] = d
+		}
+	}
+	if d, ok := found[wallet.BalanceDrift]; !ok || d.Expected != "100" || d.Actual != "101" {
+		t.Error("expect the balance drift, got", found[wallet.BalanceDrift])
+	}
+	if d, ok := found[wallet.CacheDrift]; !ok || !d.Repaired {
+		t.Error("expect the cache drift repaired, got", found[wallet.CacheDrift])
+	}
+	if _, ok := found[wallet.OrphanEntry]; !ok {
+		t.Error("expect the orphan entry, got", report.Discrepancies)
+	}
+	if n, _ := rdb.Exists(ctx, key).Result(); n != 0 {
+		t.Error("expect the cached balance dropped")
+	}
+}
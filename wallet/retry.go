@@ -0,0 +1,51 @@
+package wallet
+
+import (
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	// maxTxAttempts bounds how often a transaction aborted by Postgres is run.
+	maxTxAttempts = 5
+	txBackoff     = 10 * time.Millisecond
+)
+
+// retryable reports whether Postgres aborted the transaction because of a
+// deadlock (40P01) or a serialization failure (40001). Such a transaction
+// did nothing and can simply be run again.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40P01" || pqErr.Code == "40001"
+}
+
+// backoff is the pause before the next attempt: exponential, with jitter so
+// the transactions that collided do not collide again.
+func backoff(attempt int) time.Duration {
+	d := txBackoff << (attempt - 1)
+	return d + time.Duration(rand.Int63n(int64(d)))
+}
+
+// retry runs attempt until it succeeds or fails for a reason other than
+// Postgres aborting it, rolling back in between. After maxTxAttempts aborts
+// it gives up with ErrConflict.
+func (s Service) retry(what string, attempt func() error, rollback func()) error {
+	for n := 1; ; n++ {
+		err := attempt()
+		if !retryable(err) {
+			return err
+		}
+		rollback()
+		if n == maxTxAttempts {
+			return errors.Wrapf(ErrConflict, "%s aborted %d times: %v", what, n, err)
+		}
+		wait := backoff(n)
+		s.factory.Logger().Warnw(what+" aborted by postgres, retrying", "attempt", n, "backoff", wait, "error", err)
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,32 @@
+package wallet
+
+import (
+	"errors"
+	"github.com/lib/pq"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := map[error]bool{
+		&pq.Error{Code: "40P01"}:   true,
+		&pq.Error{Code: "40001"}:   true,
+		&pq.Error{Code: "23505"}:   false,
+		errors.New("sql: no rows"): false,
+		ErrInsufficientFunds:       false,
+	}
+	for err, want := range cases {
+		if got := retryable(err); got != want {
+			t.Errorf("retryable(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt < maxTxAttempts; attempt++ {
+		d := backoff(attempt)
+		base := txBackoff << (attempt - 1)
+		if d < base || d >= 2*base {
+			t.Errorf("backoff(%d) = %v, want within [%v, %v)", attempt, d, base, 2*base)
+		}
+	}
+}
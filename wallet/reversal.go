@@ -0,0 +1,241 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+)
+
+// ReverseRequest refunds Amount of a transaction; whatever is still
+// refundable when Amount is not given.
+type ReverseRequest struct {
+	Amount *Money `json:"amount" binding:"omitempty,money"`
+}
+
+// reversible is what a reversal needs to know about the entry it undoes.
+type reversible struct {
+	// postings per account, in the order they were written
+	postings []Posting
+	// usernames of the user accounts among them
+	usernames map[int]string
+	// amount is what the entry moved: its credits in the currency of its
+	// first posting, which is the user side of every movement
+	amount Money
+	// reversed sums the earlier reversals per account
+	reversed map[int]Money
+}
+
+// refunded is the part of the amount earlier reversals gave back.
+func (r reversible) refunded() Money {
+	first := r.postings[0]
+	var sum Money
+	for _, p := range r.postings {
+		if p.Currency == first.Currency && p.Amount.IsPositive() {
+			// a reversal credits what the entry debited
+			sum = sum.Sub(r.reversed[p.AccountID])
+		}
+	}
+	return sum
+}
+
+func loadReversible(tx *sql.Tx, id int) (reversible, error) {
+	r := reversible{usernames: map[int]string{}, reversed: map[int]Money{}}
+
+	rows, err := tx.Query(`SELECT p.account_id, p.currency, p.amount, u.username
+FROM postings p
+JOIN accounts a ON a.id = p.account_id
+LEFT JOIN users u ON u.id = a.user_id
+WHERE p.entry_id = $1
+ORDER BY p.id`, id)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p Posting
+		var username sql.NullString
+		if err = rows.Scan(&p.AccountID, &p.Currency, &p.Amount, &username); err != nil {
+			return r, err
+		}
+		if username.Valid {
+			r.usernames[p.AccountID] = username.String
+		}
+		r.postings = append(r.postings, p)
+	}
+	if err = rows.Err(); err != nil {
+		return r, err
+	}
+	if len(r.postings) == 0 {
+		return r, errors.Wrapf(ErrNotReversible, "transaction %d has no postings", id)
+	}
+
+	for _, p := range r.postings {
+		if p.Currency == r.postings[0].Currency && p.Amount.IsPositive() {
+			r.amount = r.amount.Add(p.Amount)
+		}
+	}
+
+	rows, err = tx.Query(`SELECT p.account_id, SUM(p.amount)
+FROM postings p
+JOIN journal_entries e ON e.id = p.entry_id
+WHERE e.reverses_id = $1
+GROUP BY p.account_id`, id)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var accountID int
+		var sum Money
+		if err = rows.Scan(&accountID, &sum); err != nil {
+			return r, err
+		}
+		r.reversed[accountID] = sum
+	}
+	return r, rows.Err()
+}
+
+// compensate returns the postings refunding amount more of r. They are
+// computed from the cumulative refund, so partial refunds add up to exactly
+// the original entry once it is refunded in full.
+func (r reversible) compensate(amount Money) ([]Posting, error) {
+	total := r.refunded().Add(amount)
+
+	postings := make([]Posting, 0, len(r.postings))
+	for _, p := range r.postings {
+		currency, err := ParseCurrency(p.Currency)
+		if err != nil {
+			return nil, err
+		}
+		target := p.Amount.Neg()
+		if !total.Equal(r.amount) {
+			target = p.Amount.Neg().Share(total, r.amount, currency)
+		}
+		postings = append(postings, Posting{AccountID: p.AccountID, Currency: p.Currency, Amount: target.Sub(r.reversed[p.AccountID])})
+	}
+	return postings, nil
+}
+
+// Reverse refunds req.Amount of the completed transaction id, or all that is
+// left of it, with a reversal entry linked to it. Refunds can be partial
+// until the whole amount is refunded; then the transaction is reversed.
+func (s Service) Reverse(ctx context.Context, id int, req ReverseRequest, hook beforeCommit) (Movement, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return Movement{}, err
+	}
+
+	var tx *sql.Tx
+
+	defer func() {
+		if err != nil && tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var original, reversal Movement
+	var r reversible
+	var amount Money
+	entry := Entry{Type: "reversal", Reverses: id}
+	var keys []string
+
+	steps := []func(){
+		func() { tx, err = db.BeginTx(ctx, nil) },
+		// the lock makes concurrent refunds of one transaction queue, so
+		// they cannot refund more than it moved
+		func() {
+			original, err = scanMovement(tx.QueryRow(movementSQL+" FOR UPDATE", id))
+			if errors.Is(err, sql.ErrNoRows) {
+				err = errors.Wrapf(ErrTransactionNotFound, "%d", id)
+			}
+		},
+		func() {
+			switch {
+			case original.Status == TransactionReversed:
+				err = errors.Wrapf(ErrAlreadyReversed, "%d", id)
+			case original.ReversesID != nil:
+				err = errors.Wrapf(ErrNotReversible, "%d is itself a reversal", id)
+			case original.Status != TransactionCompleted:
+				err = errors.Wrapf(ErrNotReversible, "%d is %s", id, original.Status)
+			}
+		},
+		func() { r, err = loadReversible(tx, id) },
+		func() {
+			refundable := r.amount.Sub(r.refunded())
+			amount = refundable
+			if req.Amount != nil {
+				amount = *req.Amount
+			}
+			if refundable.LessThan(amount) {
+				err = errors.Wrapf(ErrRefundExceeds, "%s of %s", amount, refundable)
+				return
+			}
+			var currency Currency
+			if currency, err = ParseCurrency(r.postings[0].Currency); err == nil {
+				err = currency.Check(amount)
+			}
+		},
+		func() {
+			entry.Postings, err = r.compensate(amount)
+			if err == nil && entry.Check() != nil {
+				err = errors.Wrapf(ErrInvalidAmount, "%s cannot be refunded exactly", amount)
+			}
+		},
+		// the accounts getting money back and those giving it back must be
+		// open, and the latter must still have it available
+		func() {
+			for _, p := range entry.Postings {
+				username, ok := r.usernames[p.AccountID]
+				if !ok {
+					continue
+				}
+				if err = checkStatus(tx, username, p.Amount.IsNegative()); err != nil {
+					return
+				}
+				keys = append(keys, BalanceKey(username, p.Currency))
+			}
+		},
+		func() {
+			ids := make([]int, 0, len(entry.Postings))
+			for _, p := range entry.Postings {
+				ids = append(ids, p.AccountID)
+			}
+			err = lockAccounts(tx, ids...)
+		},
+		func() {
+			for _, p := range entry.Postings {
+				if _, ok := r.usernames[p.AccountID]; !ok || !p.Amount.IsNegative() {
+					continue
+				}
+				if err = lockFunds(tx, p.AccountID, p.Amount.Neg()); err != nil {
+					return
+				}
+			}
+		},
+		func() {
+			var entryID int
+			if entryID, err = post(tx, entry); err == nil {
+				reversal, err = scanMovement(tx.QueryRow(movementSQL, entryID))
+			}
+		},
+		func() {
+			if r.refunded().Add(amount).Equal(r.amount) {
+				_, err = setTransactionStatus(tx, id, TransactionReversed)
+			}
+		},
+		func() { err = hook(tx, reversal) },
+		func() { err = tx.Commit() },
+	}
+
+	for _, step := range steps {
+		if step(); err != nil {
+			return Movement{}, err
+		}
+	}
+
+	s.forget(ctx, keys...)
+
+	return reversal, nil
+}
@@ -0,0 +1,97 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"testing"
+)
+
+func reverse(t *testing.T, id int, body string, code int) wallet.Movement {
+	resp := call(http.MethodPost, fmt.Sprintf("/transactions/%d/reverse", id), body)
+	if resp.Code != code {
+		t.Fatal("expect", code, "got", resp.Code, resp.Body.String())
+	}
+	var movement wallet.Movement
+	_ = json.Unmarshal(resp.Body.Bytes(), &movement)
+	return movement
+}
+
+func TestReverseWithdraw(t *testing.T) {
+	svc := wallet.NewService(f)
+	call(http.MethodPost, "/deposit", `{"username":"user1","amount":5}`)
+	before, _ := svc.GetBalance(context.Background(), "user1", "USD")
+
+	resp := call(http.MethodPost, "/withdraw", `{"username":"user1","amount":5}`)
+	var receipt wallet.Receipt
+	_ = json.Unmarshal(resp.Body.Bytes(), &receipt)
+
+	reversal := reverse(t, receipt.TransactionID, "", http.StatusCreated)
+	if reversal.Type != "reversal" || reversal.ReversesID == nil || *reversal.ReversesID != receipt.TransactionID {
+		t.Error("expect a reversal of the withdrawal", reversal)
+	}
+
+	after, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !after.Balance.Equal(before.Balance) {
+		t.Error("a full reversal should restore the balance", before, after)
+	}
+
+	original, _ := svc.GetMovement(context.Background(), receipt.TransactionID)
+	if original.Status != wallet.TransactionReversed || original.ReversedAt == nil {
+		t.Error("expect the withdrawal reversed", original)
+	}
+
+	reverse(t, receipt.TransactionID, "", http.StatusConflict)
+	reverse(t, reversal.ID, "", http.StatusConflict)
+}
+
+func TestPartialRefund(t *testing.T) {
+	svc := wallet.NewService(f)
+	resp := call(http.MethodPost, "/deposit", `{"username":"user1","amount":10}`)
+	var receipt wallet.Receipt
+	_ = json.Unmarshal(resp.Body.Bytes(), &receipt)
+
+	first := reverse(t, receipt.TransactionID, `{"amount":4}`, http.StatusCreated)
+	after, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !receipt.Balance.Balance.Sub(after.Balance).Equal(wallet.MustParseMoney("4")) {
+		t.Error("a refund of 4 should debit 4", receipt.Balance, after)
+	}
+
+	reverse(t, receipt.TransactionID, `{"amount":7}`, http.StatusUnprocessableEntity)
+	reverse(t, receipt.TransactionID, `{"amount":0.001}`, http.StatusUnprocessableEntity)
+
+	original, _ := svc.GetMovement(context.Background(), receipt.TransactionID)
+	if original.Status != wallet.TransactionCompleted {
+		t.Error("a partly refunded transaction stays completed", original)
+	}
+
+	second := reverse(t, receipt.TransactionID, "", http.StatusCreated)
+	original, _ = svc.GetMovement(context.Background(), receipt.TransactionID)
+	if original.Status != wallet.TransactionReversed {
+		t.Error("refunding the rest should reverse the transaction", original)
+	}
+
+	resp = call(http.MethodGet, "/transactions/user1?limit=100", "")
+	var page wallet.HistoryPage
+	_ = json.Unmarshal(resp.Body.Bytes(), &page)
+	found := false
+	for _, tx := range page.Transactions {
+		if tx.ID != receipt.TransactionID {
+			continue
+		}
+		found = true
+		if len(tx.Reversals) != 2 || tx.Reversals[0] != int64(first.ID) || tx.Reversals[1] != int64(second.ID) {
+			t.Error("history should link both refunds", tx.Reversals)
+		}
+	}
+	if !found {
+		t.Error("deposit missing from history", resp.Body.String())
+	}
+}
+
+func TestReverseUnknown(t *testing.T) {
+	reverse(t, 1<<30, "", http.StatusNotFound)
+	reverse(t, 1, `{"amount":-1}`, http.StatusUnprocessableEntity)
+}
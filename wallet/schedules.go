@@ -0,0 +1,538 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// A schedule is active until it is cancelled or, when it pays only once,
+// completed or failed. Paused schedules skip their occurrences.
+const (
+	ScheduleActive    = "active"
+	SchedulePaused    = "paused"
+	ScheduleCompleted = "completed"
+	ScheduleFailed    = "failed"
+	ScheduleCancelled = "cancelled"
+)
+
+// Outcomes of a schedule run. A retrying run failed for lack of funds and
+// will be attempted again.
+const (
+	RunSucceeded = "succeeded"
+	RunRetrying  = "retrying"
+	RunFailed    = "failed"
+)
+
+const (
+	// DefaultScheduleRetries applies when a schedule sets no max_retries.
+	DefaultScheduleRetries = 3
+	// DefaultScheduleRetryInterval applies when a schedule sets no
+	// retry_interval_seconds.
+	DefaultScheduleRetryInterval = time.Hour
+	// DefaultSchedulePollInterval applies when schedules.pollInterval is not
+	// configured.
+	DefaultSchedulePollInterval = time.Minute
+)
+
+// ScheduleRequest sets up a standing order from From to To: once at
+// StartAt, or on every occurrence of Cron (UTC) or every IntervalSeconds,
+// starting at StartAt when given.
+type ScheduleRequest struct {
+	From                 string     `json:"from" binding:"required,username"`
+	To                   string     `json:"to" binding:"required,username"`
+	Amount               Money      `json:"amount" binding:"money"`
+	Currency             string     `json:"currency" binding:"omitempty,currency"`
+	Memo                 string     `json:"memo" binding:"omitempty,max=140"`
+	Cron                 string     `json:"cron" binding:"omitempty,cron"`
+	IntervalSeconds      int        `json:"interval_seconds" binding:"omitempty,min=60"`
+	StartAt              *time.Time `json:"start_at"`
+	MaxRetries           *int       `json:"max_retries" binding:"omitempty,min=0,max=10"`
+	RetryIntervalSeconds int        `json:"retry_interval_seconds" binding:"omitempty,min=60"`
+}
+
+// ScheduleUpdate changes what a schedule pays, or pauses and resumes it.
+type ScheduleUpdate struct {
+	Amount *Money  `json:"amount" binding:"omitempty,money"`
+	Memo   *string `json:"memo" binding:"omitempty,max=140"`
+	Status string  `json:"status" binding:"omitempty,oneof=active paused"`
+}
+
+// schedule is a Schedule as the worker needs it.
+type schedule struct {
+	Schedule
+	due, next     sql.NullTime
+	retryInterval time.Duration
+}
+
+const scheduleSQL = `SELECT s.id, fu.username, tu.username, s.amount, s.currency, COALESCE(s.memo, ''), COALESCE(s.cron, ''), COALESCE(s.interval_seconds, 0),
+    s.max_retries, s.retry_interval_seconds, s.status, s.attempts, s.due_at, s.next_run_at, s.created_at
+FROM schedules s
+JOIN users fu ON fu.id = s.from_user_id
+JOIN users tu ON tu.id = s.to_user_id`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSchedule(row scanner) (schedule, error) {
+	var s schedule
+	var retrySeconds int
+	err := row.Scan(&s.ID, &s.From, &s.To, &s.Amount, &s.Currency, &s.Memo, &s.Cron, &s.IntervalSeconds,
+		&s.MaxRetries, &retrySeconds, &s.Status, &s.Attempts, &s.due, &s.next, &s.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return schedule{}, ErrScheduleNotFound
+	}
+	if err != nil {
+		return schedule{}, err
+	}
+	s.RetryIntervalSeconds = retrySeconds
+	s.retryInterval = time.Duration(retrySeconds) * time.Second
+	if s.next.Valid {
+		next := s.next.Time.UTC().Format(time.RFC3339)
+		s.NextRunAt = &next
+	}
+	return s, nil
+}
+
+// following returns the occurrence of s after the one due, no earlier than
+// now, or false when s pays only once.
+func (s schedule) following(due, now time.Time) (time.Time, bool) {
+	switch {
+	case s.Cron != "":
+		spec, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		next := spec.next(now)
+		return next, !next.IsZero()
+	case s.IntervalSeconds > 0:
+		every := time.Duration(s.IntervalSeconds) * time.Second
+		next := due.Add(every)
+		// occurrences missed while the worker was down are skipped
+		for !next.After(now) {
+			next = next.Add(every)
+		}
+		return next, true
+	}
+	return time.Time{}, false
+}
+
+// first returns the first occurrence of a new schedule.
+func first(req ScheduleRequest, now time.Time) (time.Time, error) {
+	start := now
+	if req.StartAt != nil {
+		start = req.StartAt.UTC()
+	}
+	switch {
+	case req.Cron != "":
+		spec, err := parseCron(req.Cron)
+		if err != nil {
+			return time.Time{}, errors.Wrap(ErrInvalidRequest, err.Error())
+		}
+		// the first minute matching at or after start
+		next := spec.next(start.Add(-time.Minute))
+		if next.IsZero() {
+			return time.Time{}, errors.Wrapf(ErrInvalidRequest, "cron %q never runs", req.Cron)
+		}
+		return next, nil
+	case req.IntervalSeconds > 0 && req.StartAt == nil:
+		return now.Add(time.Duration(req.IntervalSeconds) * time.Second), nil
+	}
+	return start, nil
+}
+
+// CreateSchedule sets up a standing order.
+func (s Service) CreateSchedule(ctx context.Context, req ScheduleRequest) (Schedule, error) {
+	currency, err := checkAmount(req.Amount, req.Currency)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if req.From == req.To {
+		return Schedule{}, errors.Wrapf(ErrSelfTransfer, "%q", req.From)
+	}
+	due, err := first(req, time.Now().UTC())
+	if err != nil {
+		return Schedule{}, err
+	}
+	retries := DefaultScheduleRetries
+	if req.MaxRetries != nil {
+		retries = *req.MaxRetries
+	}
+	retryInterval := int(DefaultScheduleRetryInterval.Seconds())
+	if req.RetryIntervalSeconds > 0 {
+		retryInterval = req.RetryIntervalSeconds
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	var id int
+	err = db.QueryRowContext(ctx, `INSERT INTO schedules (from_user_id, to_user_id, amount, currency, memo, cron, interval_seconds, max_retries, retry_interval_seconds, due_at, next_run_at)
+SELECT f.id, t.id, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, 0), $8, $9, $10, $10
+FROM users f, users t
+WHERE f.username = $1 AND t.username = $2
+RETURNING id`, req.From, req.To, req.Amount, currency.Code, req.Memo, req.Cron, req.IntervalSeconds, retries, retryInterval, due).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Schedule{}, errors.Wrapf(ErrAccountNotFound, "%q or %q", req.From, req.To)
+	}
+	if err != nil {
+		return Schedule{}, err
+	}
+	return s.GetSchedule(ctx, id)
+}
+
+// GetSchedule returns schedule id.
+func (s Service) GetSchedule(ctx context.Context, id int) (Schedule, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	sched, err := scanSchedule(db.QueryRowContext(ctx, scheduleSQL+" WHERE s.id = $1", id))
+	if errors.Is(err, ErrScheduleNotFound) {
+		return Schedule{}, errors.Wrapf(err, "%d", id)
+	}
+	return sched.Schedule, err
+}
+
+// GetSchedules returns the schedules paid by username, newest first.
+func (s Service) GetSchedules(ctx context.Context, username string) ([]Schedule, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+
+	rows, err := db.QueryContext(ctx, scheduleSQL+" WHERE fu.username = $1 ORDER BY s.id DESC", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		sched, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sched.Schedule)
+	}
+	return schedules, rows.Err()
+}
+
+// lockSchedule locks schedule id until tx ends.
+func lockSchedule(tx *sql.Tx, id int) (schedule, error) {
+	if _, err := tx.Exec("SELECT 1 FROM schedules WHERE id = $1 FOR UPDATE", id); err != nil {
+		return schedule{}, err
+	}
+	sched, err := scanSchedule(tx.QueryRow(scheduleSQL+" WHERE s.id = $1", id))
+	if errors.Is(err, ErrScheduleNotFound) {
+		return schedule{}, errors.Wrapf(err, "%d", id)
+	}
+	return sched, err
+}
+
+// UpdateSchedule applies upd to schedule id. A resumed schedule skips the
+// occurrences that passed while it was paused.
+func (s Service) UpdateSchedule(ctx context.Context, id int, upd ScheduleUpdate) (Schedule, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Schedule{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	sched, err := lockSchedule(tx, id)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if sched.Status != ScheduleActive && sched.Status != SchedulePaused {
+		return Schedule{}, errors.Wrapf(ErrScheduleClosed, "schedule %d is %s", id, sched.Status)
+	}
+
+	if upd.Amount != nil {
+		if _, err = checkAmount(*upd.Amount, sched.Currency); err != nil {
+			return Schedule{}, err
+		}
+		if _, err = tx.Exec("UPDATE schedules SET amount = $2 WHERE id = $1", id, *upd.Amount); err != nil {
+			return Schedule{}, err
+		}
+	}
+	if upd.Memo != nil {
+		if _, err = tx.Exec("UPDATE schedules SET memo = NULLIF($2, '') WHERE id = $1", id, *upd.Memo); err != nil {
+			return Schedule{}, err
+		}
+	}
+	if upd.Status == ScheduleActive && sched.Status == SchedulePaused {
+		now := time.Now().UTC()
+		due := sched.due.Time
+		if next, ok := sched.following(due, now); ok && due.Before(now) {
+			due = next
+		}
+		_, err = tx.Exec("UPDATE schedules SET status = $2, due_at = $3, next_run_at = $3, attempts = 0 WHERE id = $1", id, ScheduleActive, due)
+		if err != nil {
+			return Schedule{}, err
+		}
+	}
+	if upd.Status == SchedulePaused {
+		if _, err = tx.Exec("UPDATE schedules SET status = $2 WHERE id = $1", id, SchedulePaused); err != nil {
+			return Schedule{}, err
+		}
+	}
+
+	if sched, err = scanSchedule(tx.QueryRow(scheduleSQL+" WHERE s.id = $1", id)); err != nil {
+		return Schedule{}, err
+	}
+	return sched.Schedule, tx.Commit()
+}
+
+// CancelSchedule stops schedule id for good. Its runs are kept.
+func (s Service) CancelSchedule(ctx context.Context, id int) (Schedule, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Schedule{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	sched, err := lockSchedule(tx, id)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if sched.Status != ScheduleActive && sched.Status != SchedulePaused {
+		return Schedule{}, errors.Wrapf(ErrScheduleClosed, "schedule %d is %s", id, sched.Status)
+	}
+	_, err = tx.Exec("UPDATE schedules SET status = $2, next_run_at = NULL WHERE id = $1", id, ScheduleCancelled)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	if sched, err = scanSchedule(tx.QueryRow(scheduleSQL+" WHERE s.id = $1", id)); err != nil {
+		return Schedule{}, err
+	}
+	return sched.Schedule, tx.Commit()
+}
+
+// GetScheduleRuns returns every attempt at paying schedule id, oldest first.
+func (s Service) GetScheduleRuns(ctx context.Context, id int) ([]ScheduleRun, error) {
+	if _, err := s.GetSchedule(ctx, id); err != nil {
+		return nil, err
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, schedule_id, due_at, attempt, status, transfer_id, COALESCE(code, ''), COALESCE(error, ''), created_at
+FROM schedule_runs
+WHERE schedule_id = $1
+ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScheduleRun{}
+	for rows.Next() {
+		var r ScheduleRun
+		if err = rows.Scan(&r.ID, &r.ScheduleID, &r.DueAt, &r.Attempt, &r.Status, &r.TransferID, &r.Code, &r.Error, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// errScheduleMoved means another worker ran or changed the schedule since
+// it was read. The run is dropped.
+var errScheduleMoved = errors.New("schedule moved on")
+
+// settle records run of sched in tx and moves sched to its next run, unless
+// another worker already did.
+func settle(tx *sql.Tx, sched schedule, run ScheduleRun, now time.Time) error {
+	due := sched.due.Time
+	status, next := sched.Status, sql.NullTime{}
+	attempts := 0
+
+	switch {
+	case run.Status == RunRetrying:
+		next = sql.NullTime{Time: now.Add(sched.retryInterval), Valid: true}
+		attempts = run.Attempt
+	default:
+		if following, ok := sched.following(due, now); ok {
+			due, next = following, sql.NullTime{Time: following, Valid: true}
+		} else if run.Status == RunSucceeded {
+			status = ScheduleCompleted
+		} else {
+			status = ScheduleFailed
+		}
+	}
+
+	res, err := tx.Exec(`UPDATE schedules SET status = $3, due_at = $4, next_run_at = $5, attempts = $6
+WHERE id = $1 AND status = 'active' AND next_run_at = $2`, sched.ID, sched.next.Time, status, due, next, attempts)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errScheduleMoved
+	}
+
+	_, err = tx.Exec(`INSERT INTO schedule_runs (schedule_id, due_at, attempt, status, transfer_id, code, error)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))`, sched.ID, sched.due.Time, run.Attempt, run.Status, run.TransferID, run.Code, run.Error)
+	return err
+}
+
+// runSchedule pays the due occurrence of schedule id through Transfer. The
+// schedule moves on in the same transaction as the transfer, so no
+// occurrence is paid twice.
+func (s Service) runSchedule(ctx context.Context, id int, now time.Time) error {
+	db, err := s.factory.DB()
+	if err != nil {
+		return err
+	}
+
+	sched, err := scanSchedule(db.QueryRowContext(ctx, scheduleSQL+" WHERE s.id = $1", id))
+	if err != nil {
+		return err
+	}
+	if sched.Status != ScheduleActive || !sched.next.Valid || sched.next.Time.After(now) {
+		return nil
+	}
+
+	run := ScheduleRun{ScheduleID: id, Attempt: sched.Attempts + 1, Status: RunSucceeded}
+	req := TransferRequest{
+		From:      sched.From,
+		To:        sched.To,
+		Amount:    sched.Amount,
+		Currency:  sched.Currency,
+		Memo:      sched.Memo,
+		Reference: fmt.Sprintf("schedule-%d-%d", id, sched.due.Time.Unix()),
+	}
+	_, err = s.Transfer(ctx, req, func(tx *sql.Tx, result any) error {
+		transferID := result.(Transfer).ID
+		run.TransferID = &transferID
+		return settle(tx, sched, run, now)
+	})
+	if errors.Is(err, errScheduleMoved) {
+		return nil
+	}
+	// a conflict is contention, not a refusal: the occurrence stays due and
+	// is paid on a later pass
+	if errors.Is(err, ErrConflict) {
+		return err
+	}
+	_, code, domain := domainError(err)
+	if err == nil || !domain {
+		return err
+	}
+
+	// the transfer was refused: record why in a transaction of its own
+	run.TransferID = nil
+	run.Status, run.Code, run.Error = RunFailed, code, err.Error()
+	if errors.Is(err, ErrInsufficientFunds) && sched.Attempts < sched.MaxRetries {
+		run.Status = RunRetrying
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err = settle(tx, sched, run, now); err != nil {
+		if errors.Is(err, errScheduleMoved) {
+			return nil
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// RunDueSchedules pays the schedules due and returns how many it ran. A
+// schedule failing to run is logged and does not hold up the others; their
+// errors are returned together.
+func (s Service) RunDueSchedules(ctx context.Context) (int, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	rows, err := db.QueryContext(ctx, "SELECT id FROM schedules WHERE status = 'active' AND next_run_at <= $1 ORDER BY next_run_at LIMIT 100", now)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n, failed := 0, []error{}
+	for _, id := range ids {
+		if err = s.runSchedule(ctx, id, now); err != nil {
+			err = errors.Wrapf(err, "schedule %d", id)
+			s.factory.Logger().Errorw("running schedule failed", "schedule", id, "error", err)
+			failed = append(failed, err)
+			continue
+		}
+		n++
+	}
+	return n, stderrors.Join(failed...)
+}
+
+// RunSchedules pays due schedules every schedules.pollInterval until ctx
+// is done.
+func (s Service) RunSchedules(ctx context.Context) {
+	every := s.factory.Config().Schedules.PollInterval
+	if every <= 0 {
+		every = DefaultSchedulePollInterval
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	logger := s.factory.Logger()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// failures were logged schedule by schedule
+			n, _ := s.RunDueSchedules(ctx)
+			if n > 0 {
+				logger.Infow("ran schedules", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// scheduleFor creates a schedule from a fresh user holding balance.
+func scheduleFor(t *testing.T, balance, body string) (string, wallet.Schedule) {
+	payer := fmt.Sprintf("sched%d", time.Now().UnixNano())
+	call(http.MethodPost, "/accounts", fmt.Sprintf(`{"username":%q}`, payer))
+	call(http.MethodPost, "/deposit", fmt.Sprintf(`{"username":%q,"amount":%s}`, payer, balance))
+
+	resp := call(http.MethodPost, "/schedules", fmt.Sprintf(body, payer))
+	if resp.Code != http.StatusCreated {
+		t.Fatal("code is not 201", resp.Body.String())
+	}
+	var schedule wallet.Schedule
+	_ = json.Unmarshal(resp.Body.Bytes(), &schedule)
+	return payer, schedule
+}
+
+func scheduleRuns(t *testing.T, id int) []wallet.ScheduleRun {
+	resp := call(http.MethodGet, fmt.Sprintf("/schedules/%d/runs", id), "")
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+	var runs []wallet.ScheduleRun
+	_ = json.Unmarshal(resp.Body.Bytes(), &runs)
+	return runs
+}
+
+func TestScheduleRunsOnce(t *testing.T) {
+	svc := wallet.NewService(f)
+	start := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	payer, schedule := scheduleFor(t, "10", `{"from":%q,"to":"user2","amount":4,"memo":"rent","start_at":"`+start+`"}`)
+
+	if _, err := svc.RunDueSchedules(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := scheduleRuns(t, schedule.ID)
+	if len(runs) != 1 || runs[0].Status != wallet.RunSucceeded || runs[0].TransferID == nil {
+		t.Fatal("expect one successful run", runs)
+	}
+	transfer, _ := svc.GetTransfer(context.Background(), *runs[0].TransferID)
+	if transfer.From != payer || transfer.Memo != "rent" {
+		t.Error("unexpected transfer", transfer)
+	}
+	balance, _ := svc.GetBalance(context.Background(), payer, "USD")
+	if !balance.Balance.Equal(wallet.MustParseMoney("6")) {
+		t.Error("expect 6 left, got", balance.Balance)
+	}
+
+	done, _ := svc.GetSchedule(context.Background(), schedule.ID)
+	if done.Status != wallet.ScheduleCompleted || done.NextRunAt != nil {
+		t.Error("a one-shot schedule completes", done)
+	}
+
+	// nothing is due any more
+	_, _ = svc.RunDueSchedules(context.Background())
+	if runs = scheduleRuns(t, schedule.ID); len(runs) != 1 {
+		t.Error("a schedule must not pay twice", runs)
+	}
+}
+
+func TestScheduleRetriesInsufficientFunds(t *testing.T) {
+	svc := wallet.NewService(f)
+	d, _ := f.DB()
+	start := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	_, schedule := scheduleFor(t, "1", `{"from":%q,"to":"user2","amount":4,"max_retries":1,"start_at":"`+start+`"}`)
+
+	_, _ = svc.RunDueSchedules(context.Background())
+	retrying, _ := svc.GetSchedule(context.Background(), schedule.ID)
+	if retrying.Status != wallet.ScheduleActive || retrying.Attempts != 1 {
+		t.Fatal("expect a retry to be planned", retrying)
+	}
+
+	// the retry is an hour away: bring it forward
+	_, _ = d.Exec("UPDATE schedules SET next_run_at = $2 WHERE id = $1", schedule.ID, time.Now().Add(-time.Second).UTC())
+	_, _ = svc.RunDueSchedules(context.Background())
+
+	runs := scheduleRuns(t, schedule.ID)
+	if len(runs) != 2 || runs[0].Status != wallet.RunRetrying || runs[1].Status != wallet.RunFailed || runs[1].Code != "insufficient_funds" {
+		t.Fatal("expect a retry, then a failure", runs)
+	}
+	failed, _ := svc.GetSchedule(context.Background(), schedule.ID)
+	if failed.Status != wallet.ScheduleFailed {
+		t.Error("a one-shot schedule out of retries fails", failed)
+	}
+}
+
+func TestScheduleConflictStaysDue(t *testing.T) {
+	svc := wallet.NewService(f)
+	d, _ := f.DB()
+	// every attempt of the transfer is aborted as a deadlock
+	_, err := d.Exec(`CREATE OR REPLACE FUNCTION test_deadlock() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'deadlock detected' USING ERRCODE = '40P01';
+END $$ LANGUAGE plpgsql;
+CREATE TRIGGER test_deadlock BEFORE INSERT ON transfers FOR EACH ROW WHEN (NEW.memo = 'deadlock') EXECUTE FUNCTION test_deadlock()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _, _ = d.Exec("DROP TRIGGER IF EXISTS test_deadlock ON transfers; DROP FUNCTION IF EXISTS test_deadlock()") }()
+
+	start := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	_, schedule := scheduleFor(t, "10", `{"from":%q,"to":"user2","amount":4,"memo":"deadlock","start_at":"`+start+`"}`)
+
+	if _, err = svc.RunDueSchedules(context.Background()); !errors.Is(err, wallet.ErrConflict) {
+		t.Fatal("expect a conflict, got", err)
+	}
+	due, _ := svc.GetSchedule(context.Background(), schedule.ID)
+	if due.Status != wallet.ScheduleActive || due.Attempts != 0 || due.NextRunAt == nil || len(scheduleRuns(t, schedule.ID)) != 0 {
+		t.Fatal("a conflict must leave the schedule due", due)
+	}
+
+	_, _ = d.Exec("DROP TRIGGER test_deadlock ON transfers")
+	_, _ = svc.RunDueSchedules(context.Background())
+	runs := scheduleRuns(t, schedule.ID)
+	if len(runs) != 1 || runs[0].Status != wallet.RunSucceeded {
+		t.Error("expect the schedule paid on the next pass", runs)
+	}
+}
+
+func TestScheduleCRUD(t *testing.T) {
+	payer, schedule := scheduleFor(t, "0", `{"from":%q,"to":"user2","amount":50,"cron":"0 0 1 * *"}`)
+	if schedule.Status != wallet.ScheduleActive || schedule.NextRunAt == nil || schedule.MaxRetries != wallet.DefaultScheduleRetries {
+		t.Fatal("unexpected schedule", schedule)
+	}
+
+	path := fmt.Sprintf("/schedules/%d", schedule.ID)
+	steps := []struct {
+		method, path, body string
+		code               int
+		status             string
+	}{
+		{http.MethodPatch, path, `{"status":"paused","amount":60}`, http.StatusOK, wallet.SchedulePaused},
+		{http.MethodPatch, path, `{"status":"active"}`, http.StatusOK, wallet.ScheduleActive},
+		{http.MethodPatch, path, `{"amount":0.001}`, http.StatusBadRequest, ""},
+		{http.MethodDelete, path, "", http.StatusOK, wallet.ScheduleCancelled},
+		{http.MethodDelete, path, "", http.StatusConflict, ""},
+		{http.MethodPatch, path, `{"status":"active"}`, http.StatusConflict, ""},
+		{http.MethodGet, "/schedules/999999999", "", http.StatusNotFound, ""},
+	}
+	for _, step := range steps {
+		resp := call(step.method, step.path, step.body)
+		if resp.Code != step.code {
+			t.Error(step.method, step.path, step.body, "expect", step.code, "got", resp.Code, resp.Body.String())
+			continue
+		}
+		var got wallet.Schedule
+		_ = json.Unmarshal(resp.Body.Bytes(), &got)
+		if step.status != "" && got.Status != step.status {
+			t.Error(step.method, step.path, step.body, "expect status", step.status, "got", got.Status)
+		}
+	}
+
+	resp := call(http.MethodGet, "/accounts/"+payer+"/schedules", "")
+	var schedules []wallet.Schedule
+	_ = json.Unmarshal(resp.Body.Bytes(), &schedules)
+	if len(schedules) != 1 || !schedules[0].Amount.Equal(wallet.MustParseMoney("60")) {
+		t.Error("unexpected schedules", resp.Body.String())
+	}
+}
@@ -2,12 +2,16 @@ package wallet
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"github.com/bitmyth/walletserivce/config"
 	"github.com/bitmyth/walletserivce/db"
+	"github.com/bitmyth/walletserivce/fx"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
-	"strconv"
+	"time"
 )
 
 type dependency interface {
@@ -15,6 +19,7 @@ type dependency interface {
 	DB() (*db.DB, error)
 	Redis() (*db.Redis, error)
 	Logger() *zap.SugaredLogger
+	FX() *fx.Service
 	WalletController() *Controller
 	RegisterRoutes(router *gin.Engine)
 }
@@ -27,39 +32,200 @@ func NewService(factory dependency) *Service {
 	return &Service{factory: factory}
 }
 
-func (s Service) GetBalance(ctx context.Context, username string) (float64, error) {
+// balanceCacheTTL bounds how long a cached balance outlives a movement whose
+// invalidation it missed, e.g. when read concurrently or with Redis down.
+const balanceCacheTTL = time.Minute
+
+// BalanceKey is the Redis key caching the balance of username in currency.
+func BalanceKey(username, currency string) string {
+	return "balance:" + username + ":" + currency
+}
+
+// GetBalance returns the ledger and available balance of username in
+// currency. A user who never held the currency has a zero balance; an
+// unknown user is ErrAccountNotFound. The cached balance lives until a
+// movement on the account, at most balanceCacheTTL and no longer than its
+// first hold to expire, which frees funds without moving any.
+func (s Service) GetBalance(ctx context.Context, username, currency string) (Balance, error) {
 	logger := s.factory.Logger()
 
 	d, err := s.factory.DB()
 	if err != nil {
 		logger.Error(err)
-		return 0, err
+		return Balance{}, err
 	}
 
-	rdb, er := s.factory.Redis()
-	if er != nil {
-		logger.Error(err)
-		return 0, err
+	// the cache is only a fast path: without Redis, read the database
+	rdb, err := s.factory.Redis()
+	if err != nil {
+		logger.Warn(err)
+		rdb = nil
 	}
 
-	var balance float64
+	key := BalanceKey(username, currency)
+
+	balance := Balance{Currency: currency}
 	// check cache first
-	b, err := rdb.Get(ctx, username).Result()
-	if err == redis.Nil {
-		// not in cache, get from DB
-		var user User
-		err = d.QueryRow("SELECT id, username, balance FROM users WHERE username = $1", username).Scan(&user.ID, &user.Username, &user.Balance)
-		if err != nil {
-			return 0, err
+	if rdb != nil {
+		b, err := rdb.Get(ctx, key).Bytes()
+		if err == nil && json.Unmarshal(b, &balance) == nil {
+			return balance, nil
+		}
+		if err != nil && err != redis.Nil {
+			logger.Warn(err)
 		}
-		// cache the balance
-		rdb.Set(ctx, username, user.Balance, 0)
-		balance = user.Balance
-	} else if err != nil {
-		return 0, err
-	} else {
-		balance, _ = strconv.ParseFloat(b, 64)
+	}
+
+	// not in cache, get from DB
+	var holdExpiry sql.NullFloat64
+	err = d.QueryRowContext(ctx, "SELECT COALESCE(a.balance, 0), COALESCE("+availableSQL+", 0), "+holdExpirySQL+" FROM users u LEFT JOIN accounts a ON a.user_id = u.id AND a.currency = $2 WHERE u.username = $1", username, currency).Scan(&balance.Balance, &balance.Available, &holdExpiry)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Balance{}, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return Balance{}, err
+	}
+
+	// cache the balance
+	ttl := balanceCacheTTL
+	if holdExpiry.Valid {
+		ttl = min(ttl, time.Duration(holdExpiry.Float64*float64(time.Second)))
+	}
+	if rdb != nil && ttl > 0 {
+		b, _ := json.Marshal(balance)
+		rdb.Set(ctx, key, b, ttl)
 	}
 
 	return balance, nil
 }
+
+// GetBalances returns all balances of username ordered by currency.
+func (s Service) GetBalances(ctx context.Context, username string) ([]Balance, error) {
+	d, err := s.factory.DB()
+	if err != nil {
+		s.factory.Logger().Error(err)
+		return nil, err
+	}
+
+	var userID int
+	err = d.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.QueryContext(ctx, "SELECT a.currency, a.balance, "+availableSQL+" FROM accounts a WHERE a.user_id = $1 ORDER BY a.currency", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []Balance{}
+	for rows.Next() {
+		var balance Balance
+		if err = rows.Scan(&balance.Currency, &balance.Balance, &balance.Available); err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+
+	return balances, rows.Err()
+}
+
+// CreateUser opens a new, active wallet.
+func (s Service) CreateUser(ctx context.Context, username string) (User, error) {
+	d, err := s.factory.DB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{Username: username}
+	err = d.QueryRowContext(ctx, "INSERT INTO users (username) VALUES ($1) ON CONFLICT (username) DO NOTHING RETURNING id, status, created_at", username).Scan(&user.ID, &user.Status, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.Wrapf(ErrAccountExists, "%q", username)
+	}
+	return user, err
+}
+
+// GetUser returns a wallet with all its balances.
+func (s Service) GetUser(ctx context.Context, username string) (User, error) {
+	d, err := s.factory.DB()
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{Username: username}
+	err = d.QueryRowContext(ctx, "SELECT id, status, created_at FROM users WHERE username = $1", username).Scan(&user.ID, &user.Status, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	user.Balances, err = s.GetBalances(ctx, username)
+	return user, err
+}
+
+// SetStatus moves a wallet to status, which must be reachable from its
+// current one: active and frozen swap, and either can be closed once every
+// balance is zero. Closed is final.
+func (s Service) SetStatus(ctx context.Context, username, status string) (User, error) {
+	allowed := map[string][]string{
+		StatusActive: {StatusFrozen},
+		StatusFrozen: {StatusActive},
+		StatusClosed: {StatusActive, StatusFrozen},
+	}
+
+	d, err := s.factory.DB()
+	if err != nil {
+		return User{}, err
+	}
+
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// the update lock waits for movements holding the user, see checkStatus
+	var user User
+	err = tx.QueryRow("SELECT id, username, status, created_at FROM users WHERE username = $1 FOR UPDATE", username).Scan(&user.ID, &user.Username, &user.Status, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	if user.Status == StatusClosed {
+		return User{}, errors.Wrapf(ErrAccountClosed, "%q", username)
+	}
+	valid := false
+	for _, from := range allowed[status] {
+		valid = valid || user.Status == from
+	}
+	if !valid {
+		return User{}, errors.Wrapf(ErrInvalidTransition, "%s to %s", user.Status, status)
+	}
+
+	if status == StatusClosed {
+		var funded bool
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM accounts WHERE user_id = $1 AND balance <> 0)", user.ID).Scan(&funded)
+		if err != nil {
+			return User{}, err
+		}
+		if funded {
+			return User{}, errors.Wrapf(ErrNonZeroBalance, "%q", username)
+		}
+	}
+
+	if _, err = tx.Exec("UPDATE users SET status = $2 WHERE id = $1", user.ID, status); err != nil {
+		return User{}, err
+	}
+	user.Status = status
+
+	return user, tx.Commit()
+}
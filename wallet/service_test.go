@@ -5,40 +5,46 @@ import (
 	"github.com/bitmyth/walletserivce/factory"
 	"github.com/bitmyth/walletserivce/wallet"
 	"testing"
+	"time"
 )
 
 func TestService_GetBalance(t *testing.T) {
 	s := wallet.NewService(f)
-	balance, err := s.GetBalance(context.Background(), "user1")
+	balance, err := s.GetBalance(context.Background(), "user1", "USD")
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	if balance < 0 {
+	if balance.Balance.IsNegative() || balance.Available.IsNegative() {
 		t.Error("user1 balance is wrong")
 	}
 
 	rdb, _ := f.Redis()
-	rdb.Del(context.Background(), "user1")
+	rdb.Del(context.Background(), wallet.BalanceKey("user1", "USD"))
 
-	balance, err = s.GetBalance(context.Background(), "user1")
+	balance, err = s.GetBalance(context.Background(), "user1", "USD")
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	if balance < 0 {
+	if balance.Balance.IsNegative() || balance.Available.IsNegative() {
 		t.Error("user1 balance is wrong")
 	}
+
+	// a balance is never cached for good
+	if ttl := rdb.TTL(context.Background(), wallet.BalanceKey("user1", "USD")).Val(); ttl <= 0 || ttl > time.Minute {
+		t.Error("expect the balance cached for at most a minute, got", ttl)
+	}
 }
 
 func TestGetBalanceForNotFoundUser(t *testing.T) {
 	s := wallet.NewService(f)
-	balance, err := s.GetBalance(context.Background(), "notfound")
+	balance, err := s.GetBalance(context.Background(), "notfound", "USD")
 	if err == nil {
 		t.Error("expect return err")
 		return
 	}
-	if balance > 0 {
+	if balance.Balance.IsPositive() {
 		t.Error("balance should be 0")
 	}
 }
@@ -46,7 +52,7 @@ func TestGetBalanceForNotFoundUser(t *testing.T) {
 func TestGetBalanceDbError(t *testing.T) {
 	ft, _ := factory.NewTesting()
 	s := wallet.NewService(ft)
-	_, err := s.GetBalance(context.Background(), "notfound")
+	_, err := s.GetBalance(context.Background(), "notfound", "USD")
 	if err == nil {
 		t.Error("expect return err")
 		return
@@ -56,7 +62,7 @@ func TestGetBalanceDbError(t *testing.T) {
 func BenchmarkGetBalance(b *testing.B) {
 	s := wallet.NewService(f)
 	for i := 0; i < b.N; i++ {
-		_, err := s.GetBalance(context.Background(), "notfound")
+		_, err := s.GetBalance(context.Background(), "notfound", "USD")
 		if err == nil {
 			b.Error(err)
 			return
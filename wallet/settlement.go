@@ -0,0 +1,215 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// A transaction is completed when recorded, unless it was requested as
+// pending: then it waits for the bank to settle it (completed) or reject it
+// (failed). Completed transactions can be reversed.
+const (
+	TransactionPending   = "pending"
+	TransactionCompleted = "completed"
+	TransactionFailed    = "failed"
+	TransactionReversed  = "reversed"
+)
+
+// transactionTransitions lists the statuses a transaction can reach, and
+// from which.
+var transactionTransitions = map[string][]string{
+	TransactionCompleted: {TransactionPending},
+	TransactionFailed:    {TransactionPending},
+	TransactionReversed:  {TransactionCompleted},
+}
+
+// transitionColumn is the timestamp set when a transaction reaches a status.
+var transitionColumn = map[string]string{
+	TransactionCompleted: "completed_at",
+	TransactionFailed:    "failed_at",
+	TransactionReversed:  "reversed_at",
+}
+
+const movementSQL = "SELECT id, entry_type, status, reverses_id, created_at, completed_at, failed_at, reversed_at FROM journal_entries WHERE id = $1"
+
+func scanMovement(row *sql.Row) (Movement, error) {
+	var m Movement
+	err := row.Scan(&m.ID, &m.Type, &m.Status, &m.ReversesID, &m.CreatedAt, &m.CompletedAt, &m.FailedAt, &m.ReversedAt)
+	return m, err
+}
+
+// setTransactionStatus moves the transaction id to status within tx. The
+// entry stays locked until tx ends, so concurrent transitions queue up and
+// all but the first find it in a status it cannot leave.
+func setTransactionStatus(tx *sql.Tx, id int, status string) (Movement, error) {
+	m, err := scanMovement(tx.QueryRow(movementSQL+" FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movement{}, errors.Wrapf(ErrTransactionNotFound, "%d", id)
+	}
+	if err != nil {
+		return Movement{}, err
+	}
+
+	valid := false
+	for _, from := range transactionTransitions[status] {
+		valid = valid || m.Status == from
+	}
+	if !valid {
+		return Movement{}, errors.Wrapf(ErrTransactionState, "%s to %s", m.Status, status)
+	}
+
+	_, err = tx.Exec("UPDATE journal_entries SET status = $2, "+transitionColumn[status]+" = CURRENT_TIMESTAMP WHERE id = $1", id, status)
+	if err != nil {
+		return Movement{}, err
+	}
+	return scanMovement(tx.QueryRow(movementSQL, id))
+}
+
+// GetMovement returns the transaction id.
+func (s Service) GetMovement(ctx context.Context, id int) (Movement, error) {
+	d, err := s.factory.DB()
+	if err != nil {
+		return Movement{}, err
+	}
+
+	m, err := scanMovement(d.QueryRowContext(ctx, movementSQL, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movement{}, errors.Wrapf(ErrTransactionNotFound, "%d", id)
+	}
+	return m, err
+}
+
+// Settle completes a pending transaction: its postings now move the ledger
+// balances of the accounts involved.
+func (s Service) Settle(ctx context.Context, id int) (Movement, error) {
+	return s.finish(ctx, id, TransactionCompleted)
+}
+
+// Fail rejects a pending transaction. No balance ever moved, and the
+// pending debits it held no longer reduce the available balance nor count
+// against the outflow limits.
+func (s Service) Fail(ctx context.Context, id int) (Movement, error) {
+	return s.finish(ctx, id, TransactionFailed)
+}
+
+func (s Service) finish(ctx context.Context, id int, status string) (Movement, error) {
+	d, err := s.factory.DB()
+	if err != nil {
+		return Movement{}, err
+	}
+
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return Movement{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	m, err := setTransactionStatus(tx, id, status)
+	if err != nil {
+		return Movement{}, err
+	}
+
+	// a fee is charged only if the movement it is charged for goes through
+	fees, err := feeEntries(tx, id)
+	if err != nil {
+		return Movement{}, err
+	}
+	for _, fee := range fees {
+		if _, err = setTransactionStatus(tx, fee, status); err != nil {
+			return Movement{}, err
+		}
+	}
+
+	postings, keys, err := userPostings(tx, append([]int{id}, fees...))
+	if err != nil {
+		return Movement{}, err
+	}
+
+	if status == TransactionCompleted {
+		ids := make([]int, 0, len(postings))
+		for _, p := range postings {
+			ids = append(ids, p.AccountID)
+		}
+		if err = lockAccounts(tx, ids...); err != nil {
+			return Movement{}, err
+		}
+		for _, p := range postings {
+			if err = moveBalance(tx, p.AccountID, p.Amount); err != nil {
+				return Movement{}, err
+			}
+		}
+	}
+
+	if status == TransactionFailed {
+		// the limit counters are rebuilt from the ledger, which leaves out
+		// failed entries
+		var created time.Time
+		if err = tx.QueryRow("SELECT created_at FROM journal_entries WHERE id = $1", id).Scan(&created); err != nil {
+			return Movement{}, err
+		}
+		for _, p := range postings {
+			if p.Amount.IsNegative() {
+				day, month := usageKeys(p.AccountID, created)
+				keys = append(keys, day, month)
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Movement{}, err
+	}
+
+	s.forget(ctx, keys...)
+
+	return m, nil
+}
+
+// feeEntries returns the ids of the fees charged for the entry id.
+func feeEntries(tx *sql.Tx, id int) ([]int, error) {
+	var ids []int
+	rows, err := tx.Query("SELECT id FROM journal_entries WHERE fee_for_id = $1 ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fee int
+		if err = rows.Scan(&fee); err != nil {
+			return nil, err
+		}
+		ids = append(ids, fee)
+	}
+	return ids, rows.Err()
+}
+
+// userPostings returns the postings of entries to user accounts, with the
+// cache keys of their balances.
+func userPostings(tx *sql.Tx, entryIDs []int) ([]Posting, []string, error) {
+	rows, err := tx.Query(`SELECT p.account_id, p.currency, p.amount, u.username
+FROM postings p
+JOIN accounts a ON a.id = p.account_id
+JOIN users u ON u.id = a.user_id
+WHERE p.entry_id = ANY($1)
+ORDER BY p.id`, pq.Array(entryIDs))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var postings []Posting
+	var keys []string
+	for rows.Next() {
+		var p Posting
+		var username string
+		if err = rows.Scan(&p.AccountID, &p.Currency, &p.Amount, &username); err != nil {
+			return nil, nil, err
+		}
+		postings = append(postings, p)
+		keys = append(keys, BalanceKey(username, p.Currency))
+	}
+	return postings, keys, rows.Err()
+}
@@ -0,0 +1,108 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func pendingMovement(t *testing.T, path, body string) wallet.Receipt {
+	resp := call(http.MethodPost, path, body)
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+	var receipt wallet.Receipt
+	_ = json.Unmarshal(resp.Body.Bytes(), &receipt)
+	if receipt.Status != wallet.TransactionPending || receipt.TransactionID == 0 {
+		t.Fatal("expect a pending transaction", resp.Body.String())
+	}
+	return receipt
+}
+
+func TestPendingDeposit(t *testing.T) {
+	svc := wallet.NewService(f)
+	before, _ := svc.GetBalance(context.Background(), "user1", "USD")
+
+	receipt := pendingMovement(t, "/deposit", `{"username":"user1","amount":7,"pending":true}`)
+	pending, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !pending.Balance.Equal(before.Balance) || !pending.Available.Equal(before.Available) {
+		t.Error("a pending deposit must not credit", before, pending)
+	}
+
+	resp := call(http.MethodPost, fmt.Sprintf("/transactions/%d/settle", receipt.TransactionID), "")
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+	var movement wallet.Movement
+	_ = json.Unmarshal(resp.Body.Bytes(), &movement)
+	if movement.Status != wallet.TransactionCompleted || movement.CompletedAt == nil {
+		t.Error("expect a completed transaction", resp.Body.String())
+	}
+
+	settled, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !settled.Balance.Sub(before.Balance).Equal(wallet.MustParseMoney("7")) {
+		t.Error("settling should credit the deposit", before, settled)
+	}
+
+	for _, action := range []string{"settle", "fail"} {
+		resp = call(http.MethodPost, fmt.Sprintf("/transactions/%d/%s", receipt.TransactionID, action), "")
+		if resp.Code != http.StatusConflict {
+			t.Error(action, "a completed transaction: expect 409, got", resp.Code)
+		}
+	}
+}
+
+func TestPendingWithdrawFails(t *testing.T) {
+	svc := wallet.NewService(f)
+	before, _ := svc.GetBalance(context.Background(), "user1", "USD")
+
+	receipt := pendingMovement(t, "/withdraw", `{"username":"user1","amount":3,"pending":true}`)
+	if !receipt.Balance.Balance.Equal(before.Balance) {
+		t.Error("a pending withdrawal must not debit the ledger balance", before, receipt.Balance)
+	}
+	if !before.Available.Sub(receipt.Available).Equal(wallet.MustParseMoney("3")) {
+		t.Error("a pending withdrawal must reserve its amount", before, receipt.Balance)
+	}
+
+	resp := call(http.MethodPost, fmt.Sprintf("/transactions/%d/fail", receipt.TransactionID), "")
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+
+	after, _ := svc.GetBalance(context.Background(), "user1", "USD")
+	if !after.Balance.Equal(before.Balance) || !after.Available.Equal(before.Available) {
+		t.Error("a failed withdrawal must release its amount", before, after)
+	}
+
+	resp = call(http.MethodGet, "/transactions/user1?status=failed&limit=1", "")
+	var page wallet.HistoryPage
+	_ = json.Unmarshal(resp.Body.Bytes(), &page)
+	if len(page.Transactions) != 1 || page.Transactions[0].ID != receipt.TransactionID || page.Transactions[0].FailedAt == nil {
+		t.Error("history should show the failed withdrawal", resp.Body.String())
+	}
+}
+
+func TestPendingWithdrawIsNotAvailable(t *testing.T) {
+	username := fmt.Sprintf("pending%d", time.Now().UnixNano())
+	call(http.MethodPost, "/accounts", fmt.Sprintf(`{"username":%q}`, username))
+	call(http.MethodPost, "/deposit", fmt.Sprintf(`{"username":%q,"amount":5}`, username))
+
+	pendingMovement(t, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":4,"pending":true}`, username))
+
+	resp := call(http.MethodPost, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":2}`, username))
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Error("expect insufficient funds, got", resp.Code, resp.Body.String())
+	}
+}
+
+func TestSettleUnknownTransaction(t *testing.T) {
+	for _, path := range []string{"/transactions/0/settle", "/transactions/abc/fail", "/transactions/2147483647/settle"} {
+		if resp := call(http.MethodPost, path, ""); resp.Code != http.StatusNotFound {
+			t.Error(path, "expect 404, got", resp.Code)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// DefaultSnapshotPollInterval is how often closing balances are snapshot
+// unless configured.
+const DefaultSnapshotPollInterval = time.Hour
+
+// snapshotStartSQL lists the user accounts with the first day they have no
+// closing balance snapshot for, and the balance they closed the day before
+// with. It starts the day after the last snapshot, or the day money first
+// reached the account, and is NULL for accounts never used.
+const snapshotStartSQL = `SELECT a.id, COALESCE(s.day + 1,
+    (SELECT MIN(e.completed_at)::date FROM postings p JOIN journal_entries e ON e.id = p.entry_id WHERE p.account_id = a.id)),
+    COALESCE(s.balance, 0)
+FROM accounts a
+LEFT JOIN LATERAL (SELECT day, balance FROM balance_snapshots WHERE account_id = a.id ORDER BY day DESC LIMIT 1) s ON true
+WHERE a.user_id IS NOT NULL
+ORDER BY a.id`
+
+// dailyMovementSQL sums the postings to account $1 completed on each day
+// from $2 through $3.
+const dailyMovementSQL = `SELECT d::date, COALESCE((SELECT SUM(p.amount)
+    FROM postings p
+    JOIN journal_entries e ON e.id = p.entry_id
+    WHERE p.account_id = $1 AND e.completed_at >= d AND e.completed_at < d + INTERVAL '1 day'), 0)
+FROM generate_series($2::date, $3::date, INTERVAL '1 day') d
+ORDER BY d`
+
+// balanceAtSQL computes the balance at $2 of the accounts of user $1: the
+// latest snapshot of a day closed by then plus the postings completed
+// between that day's end and $2.
+const balanceAtSQL = `SELECT a.currency, s.day, COALESCE(s.balance, 0) + COALESCE((SELECT SUM(p.amount)
+    FROM postings p
+    JOIN journal_entries e ON e.id = p.entry_id
+    WHERE p.account_id = a.id AND e.completed_at < $2::timestamp AND (s.day IS NULL OR e.completed_at >= s.day + 1)), 0)
+FROM accounts a
+LEFT JOIN LATERAL (SELECT day, balance FROM balance_snapshots WHERE account_id = a.id AND day + 1 <= $2::timestamp ORDER BY day DESC LIMIT 1) s ON true
+WHERE a.user_id = $1`
+
+// SnapshotBalances records the closing balance of every user account for
+// each day through the day of through it has no snapshot for yet, so days
+// a run missed are caught up. Days that did not end yet are left for later.
+// It returns how many snapshots were taken.
+func (s Service) SnapshotBalances(ctx context.Context, through time.Time) (int, error) {
+	day := through.UTC().Truncate(24 * time.Hour)
+	if yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1); day.After(yesterday) {
+		day = yesterday
+	}
+
+	db, err := s.factory.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	type start struct {
+		id      int
+		from    time.Time
+		opening Money
+	}
+	var starts []start
+	rows, err := db.QueryContext(ctx, snapshotStartSQL)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var a start
+		var from sql.NullTime
+		if err = rows.Scan(&a.id, &from, &a.opening); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		if from.Valid && !from.Time.After(day) {
+			a.from = from.Time
+			starts = append(starts, a)
+		}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, a := range starts {
+		taken, err := snapshot(ctx, db.DB, a.id, a.opening, a.from, day)
+		n += taken
+		if err != nil {
+			return n, errors.Wrapf(err, "account %d", a.id)
+		}
+	}
+	return n, nil
+}
+
+// snapshot records the closing balance of accountID each day from from
+// through through, starting from the opening balance it had before. Days
+// snapshot meanwhile by another run are left as they are.
+func snapshot(ctx context.Context, db *sql.DB, accountID int, opening Money, from, through time.Time) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	type day struct {
+		day     time.Time
+		balance Money
+	}
+	var days []day
+	rows, err := tx.Query(dailyMovementSQL, accountID, from, through)
+	if err != nil {
+		return 0, err
+	}
+	balance := opening
+	for rows.Next() {
+		var d day
+		var moved Money
+		if err = rows.Scan(&d.day, &moved); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		balance = balance.Add(moved)
+		d.balance = balance
+		days = append(days, d)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, d := range days {
+		res, err := tx.Exec("INSERT INTO balance_snapshots (account_id, day, balance) VALUES ($1, $2, $3) ON CONFLICT (account_id, day) DO NOTHING", accountID, d.day, d.balance)
+		if err != nil {
+			return 0, err
+		}
+		affected, _ := res.RowsAffected()
+		n += int(affected)
+	}
+	return n, tx.Commit()
+}
+
+// RunSnapshots snapshots the closing balances of the days that ended, every
+// poll interval, until ctx is done.
+func (s Service) RunSnapshots(ctx context.Context) {
+	every := s.factory.Config().Snapshots.PollInterval
+	if every <= 0 {
+		every = DefaultSnapshotPollInterval
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	logger := s.factory.Logger()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.SnapshotBalances(ctx, time.Now().UTC().AddDate(0, 0, -1))
+			if err != nil {
+				logger.Errorw("snapshotting balances failed", "error", err)
+				continue
+			}
+			if n > 0 {
+				logger.Infow("snapshot balances", "count", n)
+			}
+		}
+	}
+}
+
+// GetBalancesAt returns the ledger balance of every account of username at
+// at, ordered by currency.
+func (s Service) GetBalancesAt(ctx context.Context, username string, at time.Time) ([]BalanceAt, error) {
+	return s.balancesAt(ctx, username, "", at)
+}
+
+// GetBalanceAt returns the ledger balance of username in currency at at. A
+// user who did not hold the currency then has a zero balance.
+func (s Service) GetBalanceAt(ctx context.Context, username, currency string, at time.Time) (BalanceAt, error) {
+	balances, err := s.balancesAt(ctx, username, currency, at)
+	if err != nil {
+		return BalanceAt{}, err
+	}
+	if len(balances) == 0 {
+		return BalanceAt{Currency: currency, At: at.UTC().Format(time.RFC3339Nano)}, nil
+	}
+	return balances[0], nil
+}
+
+// balancesAt returns the balances of username at at, in currency only
+// unless it is empty.
+func (s Service) balancesAt(ctx context.Context, username, currency string, at time.Time) ([]BalanceAt, error) {
+	d, err := s.factory.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	var userID int
+	err = d.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at = at.UTC()
+	query, args := balanceAtSQL, []any{userID, at}
+	if currency != "" {
+		query += " AND a.currency = $3"
+		args = append(args, currency)
+	}
+	rows, err := d.QueryContext(ctx, query+" ORDER BY a.currency", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []BalanceAt{}
+	for rows.Next() {
+		balance := BalanceAt{At: at.Format(time.RFC3339Nano)}
+		var day sql.NullTime
+		if err = rows.Scan(&balance.Currency, &day, &balance.Balance); err != nil {
+			return nil, err
+		}
+		if day.Valid {
+			snapshot := day.Time.Format("2006-01-02")
+			balance.SnapshotDay = &snapshot
+		}
+		balances = append(balances, balance)
+	}
+	return balances, rows.Err()
+}
@@ -0,0 +1,86 @@
+package wallet_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func balanceAt(t *testing.T, username string, at time.Time) wallet.BalanceAt {
+	resp := call(http.MethodGet, fmt.Sprintf("/balance/%s?currency=USD&at=%s", username, at.UTC().Format(time.RFC3339)), "")
+	if resp.Code != http.StatusOK {
+		t.Fatal("expect a balance, got", resp.Code, resp.Body.String())
+	}
+	var balance wallet.BalanceAt
+	_ = json.Unmarshal(resp.Body.Bytes(), &balance)
+	return balance
+}
+
+func TestBalanceAt(t *testing.T) {
+	username := fmt.Sprintf("past%d", time.Now().UnixNano())
+	call(http.MethodPost, "/accounts", fmt.Sprintf(`{"username":%q}`, username))
+
+	// 100 deposited 3 days ago, 30 withdrawn yesterday and 5 deposited today
+	db, _ := f.DB()
+	for _, m := range []struct {
+		path, amount string
+		daysAgo      int
+	}{{"/deposit", "100", 3}, {"/withdraw", "30", 1}, {"/deposit", "5", 0}} {
+		resp := call(http.MethodPost, m.path, fmt.Sprintf(`{"username":%q,"amount":%s}`, username, m.amount))
+		var receipt wallet.Receipt
+		_ = json.Unmarshal(resp.Body.Bytes(), &receipt)
+		if resp.Code != http.StatusOK {
+			t.Fatal(m.path, resp.Code, resp.Body.String())
+		}
+		_, err := db.Exec("UPDATE journal_entries SET completed_at = completed_at - make_interval(days => $2) WHERE id = $1", receipt.TransactionID, m.daysAgo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	if b := balanceAt(t, username, today.AddDate(0, 0, -1)); !b.Balance.Equal(wallet.MustParseMoney("100")) || b.SnapshotDay != nil {
+		t.Error("expect 100 from the postings alone, got", b)
+	}
+
+	svc := wallet.NewService(f)
+	for run := 0; run < 2; run++ {
+		if _, err := svc.SnapshotBalances(context.Background(), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		at       time.Time
+		balance  string
+		snapshot time.Time
+	}{
+		{today.AddDate(0, 0, -1), "100", today.AddDate(0, 0, -2)},
+		{today, "70", today.AddDate(0, 0, -1)},
+		{now.Add(time.Minute), "75", today.AddDate(0, 0, -1)},
+	}
+	for _, c := range cases {
+		b := balanceAt(t, username, c.at)
+		if !b.Balance.Equal(wallet.MustParseMoney(c.balance)) || b.SnapshotDay == nil || *b.SnapshotDay != c.snapshot.Format("2006-01-02") {
+			t.Errorf("at %s: expect %s from the snapshot of %s, got %+v", c.at, c.balance, c.snapshot.Format("2006-01-02"), b)
+		}
+	}
+
+	if b := balanceAt(t, username, today.AddDate(0, 0, -5)); !b.Balance.IsZero() {
+		t.Error("expect nothing before the first deposit, got", b)
+	}
+
+	resp := call(http.MethodGet, "/balance/"+username+"?at=yesterday", "")
+	if resp.Code != http.StatusBadRequest {
+		t.Error("expect 400, got", resp.Code)
+	}
+	resp = call(http.MethodGet, "/balance/notfound?at="+now.Format(time.RFC3339), "")
+	if resp.Code != http.StatusNotFound {
+		t.Error("expect 404, got", resp.Code)
+	}
+}
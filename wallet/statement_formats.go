@@ -0,0 +1,210 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// StatementFormats maps each statement format to its content type.
+var StatementFormats = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"jsonl": "application/x-ndjson",
+	"ofx":   "application/x-ofx",
+	"pdf":   "application/pdf",
+}
+
+// NewStatementWriter returns the writer rendering format to w, or nil for
+// a format not in StatementFormats.
+func NewStatementWriter(format string, w io.Writer) StatementWriter {
+	switch format {
+	case "csv":
+		return &csvStatement{w: csv.NewWriter(w)}
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return &jsonlStatement{enc: enc}
+	case "ofx":
+		return &ofxStatement{w: w}
+	case "pdf":
+		return &pdfStatement{w: w}
+	}
+	return nil
+}
+
+// csvStatement is one table: the opening balance row, a row per posting and
+// the closing balance row.
+type csvStatement struct {
+	w        *csv.Writer
+	head     Statement
+	exponent int
+}
+
+func (s *csvStatement) Begin(head Statement) error {
+	s.head, s.exponent = head, head.Currency.Exponent
+	_ = s.w.Write([]string{"date", "id", "entry_id", "type", "status", "counterparty", "memo", "reference", "amount", "balance"})
+	return s.row(head.From, "", "", "opening_balance", "", "", "", "", "", head.Opening.StringFixed(s.exponent))
+}
+
+func (s *csvStatement) Line(l StatementLine) error {
+	return s.row(l.Date, strconv.Itoa(l.ID), strconv.Itoa(l.EntryID), l.Type, l.Status, l.Counterparty, l.Memo, l.Reference,
+		l.Amount.StringFixed(s.exponent), l.Balance.StringFixed(s.exponent))
+}
+
+func (s *csvStatement) End(closing Money) error {
+	if err := s.row(s.head.To, "", "", "closing_balance", "", "", "", "", "", closing.StringFixed(s.exponent)); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvStatement) row(date time.Time, fields ...string) error {
+	return s.w.Write(append([]string{date.Format(time.RFC3339)}, fields...))
+}
+
+// jsonlStatement writes one JSON object per line, told apart by their
+// record: an opening line, a transaction line per posting and a closing
+// line.
+type jsonlStatement struct {
+	enc      *json.Encoder
+	exponent int
+}
+
+type jsonlOpening struct {
+	Record    string `json:"record"`
+	Username  string `json:"username"`
+	Currency  string `json:"currency"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Balance   string `json:"balance"`
+	Generated string `json:"generated_at"`
+}
+
+type jsonlLine struct {
+	Record       string `json:"record"`
+	Date         string `json:"date"`
+	ID           int    `json:"id"`
+	EntryID      int    `json:"entry_id"`
+	Type         string `json:"type"`
+	Status       string `json:"status"`
+	Counterparty string `json:"counterparty,omitempty"`
+	Memo         string `json:"memo,omitempty"`
+	Reference    string `json:"reference,omitempty"`
+	Amount       string `json:"amount"`
+	Balance      string `json:"balance"`
+}
+
+type jsonlClosing struct {
+	Record  string `json:"record"`
+	Balance string `json:"balance"`
+}
+
+func (s *jsonlStatement) Begin(head Statement) error {
+	s.exponent = head.Currency.Exponent
+	return s.enc.Encode(jsonlOpening{
+		Record:    "opening",
+		Username:  head.Username,
+		Currency:  head.Currency.Code,
+		From:      head.From.Format(time.RFC3339),
+		To:        head.To.Format(time.RFC3339),
+		Balance:   head.Opening.StringFixed(s.exponent),
+		Generated: head.GeneratedAt.Format(time.RFC3339),
+	})
+}
+
+func (s *jsonlStatement) Line(l StatementLine) error {
+	return s.enc.Encode(jsonlLine{
+		Record:       "transaction",
+		Date:         l.Date.Format(time.RFC3339Nano),
+		ID:           l.ID,
+		EntryID:      l.EntryID,
+		Type:         l.Type,
+		Status:       l.Status,
+		Counterparty: l.Counterparty,
+		Memo:         l.Memo,
+		Reference:    l.Reference,
+		Amount:       l.Amount.StringFixed(s.exponent),
+		Balance:      l.Balance.StringFixed(s.exponent),
+	})
+}
+
+func (s *jsonlStatement) End(closing Money) error {
+	return s.enc.Encode(jsonlClosing{Record: "closing", Balance: closing.StringFixed(s.exponent)})
+}
+
+// ofxStatement writes an OFX 2.2 bank statement. OFX has no opening
+// balance; the closing one is the ledger balance as of the end of the
+// period.
+type ofxStatement struct {
+	w        io.Writer
+	head     Statement
+	exponent int
+	err      error
+}
+
+// ofxTime formats t as an OFX datetime in UTC.
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405.000") + "[0:GMT]"
+}
+
+func (s *ofxStatement) printf(format string, args ...any) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.w, format, args...)
+	}
+}
+
+// text writes v escaped for XML.
+func (s *ofxStatement) text(v string) {
+	if s.err == nil {
+		s.err = xml.EscapeText(s.w, []byte(v))
+	}
+}
+
+func (s *ofxStatement) Begin(head Statement) error {
+	s.head, s.exponent = head, head.Currency.Exponent
+	s.printf(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>%s</CURDEF>
+<BANKACCTFROM><BANKID>wallet</BANKID><ACCTID>`, ofxTime(head.GeneratedAt), head.Currency.Code)
+	s.text(head.Username)
+	s.printf("</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>\n<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>\n", ofxTime(head.From), ofxTime(head.To))
+	return s.err
+}
+
+func (s *ofxStatement) Line(l StatementLine) error {
+	kind := "CREDIT"
+	if l.Amount.IsNegative() {
+		kind = "DEBIT"
+	}
+	name := l.Counterparty
+	if name == "" {
+		name = l.Type
+	}
+	s.printf("<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%d</FITID><NAME>", kind, ofxTime(l.Date), l.Amount.StringFixed(s.exponent), l.ID)
+	s.text(name)
+	s.printf("</NAME>")
+	if l.Memo != "" {
+		s.printf("<MEMO>")
+		s.text(l.Memo)
+		s.printf("</MEMO>")
+	}
+	s.printf("</STMTTRN>\n")
+	return s.err
+}
+
+func (s *ofxStatement) End(closing Money) error {
+	s.printf(`</BANKTRANLIST>
+<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`, closing.StringFixed(s.exponent), ofxTime(s.head.To))
+	return s.err
+}
@@ -0,0 +1,74 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestStatementFormats(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	head := Statement{
+		Username:    "alice",
+		Currency:    currencies["USD"],
+		From:        from,
+		To:          from.AddDate(0, 1, 0),
+		Opening:     MustParseMoney("100"),
+		GeneratedAt: from.AddDate(0, 1, 1),
+	}
+	lines := []StatementLine{
+		{ID: 7, EntryID: 3, Date: from.Add(36 * time.Hour), Type: "transfer", Status: "completed", Counterparty: "bob", Memo: `rent, "March" & more`, Reference: "r-1", Amount: MustParseMoney("-40.5"), Balance: MustParseMoney("59.5")},
+		{ID: 9, EntryID: 4, Date: from.Add(72 * time.Hour), Type: "deposit", Status: "completed", Amount: MustParseMoney("10"), Balance: MustParseMoney("69.5")},
+	}
+
+	cases := map[string]string{
+		"csv": `date,id,entry_id,type,status,counterparty,memo,reference,amount,balance
+2024-03-01T00:00:00Z,,,opening_balance,,,,,,100.00
+2024-03-02T12:00:00Z,7,3,transfer,completed,bob,"rent, ""March"" & more",r-1,-40.50,59.50
+2024-03-04T00:00:00Z,9,4,deposit,completed,,,,10.00,69.50
+2024-04-01T00:00:00Z,,,closing_balance,,,,,,69.50
+`,
+		"jsonl": `{"record":"opening","username":"alice","currency":"USD","from":"2024-03-01T00:00:00Z","to":"2024-04-01T00:00:00Z","balance":"100.00","generated_at":"2024-04-02T00:00:00Z"}
+{"record":"transaction","date":"2024-03-02T12:00:00Z","id":7,"entry_id":3,"type":"transfer","status":"completed","counterparty":"bob","memo":"rent, \"March\" & more","reference":"r-1","amount":"-40.50","balance":"59.50"}
+{"record":"transaction","date":"2024-03-04T00:00:00Z","id":9,"entry_id":4,"type":"deposit","status":"completed","amount":"10.00","balance":"69.50"}
+{"record":"closing","balance":"69.50"}
+`,
+		"ofx": `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS><DTSERVER>20240402000000.000[0:GMT]</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>
+<STMTRS><CURDEF>USD</CURDEF>
+<BANKACCTFROM><BANKID>wallet</BANKID><ACCTID>alice</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>
+<BANKTRANLIST><DTSTART>20240301000000.000[0:GMT]</DTSTART><DTEND>20240401000000.000[0:GMT]</DTEND>
+<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20240302120000.000[0:GMT]</DTPOSTED><TRNAMT>-40.50</TRNAMT><FITID>7</FITID><NAME>bob</NAME><MEMO>rent, &#34;March&#34; &amp; more</MEMO></STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT</TRNTYPE><DTPOSTED>20240304000000.000[0:GMT]</DTPOSTED><TRNAMT>10.00</TRNAMT><FITID>9</FITID><NAME>deposit</NAME></STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>69.50</BALAMT><DTASOF>20240401000000.000[0:GMT]</DTASOF></LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`,
+	}
+	for format, want := range cases {
+		var buf bytes.Buffer
+		w := NewStatementWriter(format, &buf)
+		if err := w.Begin(head); err != nil {
+			t.Fatal(format, err)
+		}
+		for _, l := range lines {
+			if err := w.Line(l); err != nil {
+				t.Fatal(format, err)
+			}
+		}
+		if err := w.End(MustParseMoney("69.5")); err != nil {
+			t.Fatal(format, err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("%s: expect\n%s\ngot\n%s", format, want, got)
+		}
+	}
+
+	if NewStatementWriter("xlsx", &bytes.Buffer{}) != nil {
+		t.Error("expect no writer for an unknown format")
+	}
+}
@@ -0,0 +1,162 @@
+package wallet
+
+import (
+	"fmt"
+	"github.com/bitmyth/walletserivce/pdf"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Layout of a PDF statement on A4, in points.
+const (
+	pdfLeft      = 50
+	pdfRight     = pdf.A4Width - 50
+	pdfTop       = pdf.A4Height - 60
+	pdfBottom    = 60
+	pdfFooter    = 30
+	pdfRowHeight = 14
+	pdfFontSize  = 9
+)
+
+// pdfColumns are the left edges of the transaction table's columns, and of
+// its numbers the right edges.
+var pdfColumns = struct {
+	date, typ, details, detailsWidth, amount, balance float64
+}{pdfLeft, 128, 215, 185, 470, pdfRight}
+
+// pdfStatement lays a statement out as a printable document: a header with
+// the account, a summary, then the transactions with their running balance,
+// page after page. Unlike the other formats it is only written at End, as
+// the summary and the page count are known last.
+type pdfStatement struct {
+	w        io.Writer
+	doc      *pdf.Document
+	page     *pdf.Page
+	y        float64
+	head     Statement
+	exponent int
+	in, out  Money
+	count    int
+}
+
+func (s *pdfStatement) money(m Money) string {
+	return m.StringFixed(s.exponent)
+}
+
+func (s *pdfStatement) Begin(head Statement) error {
+	s.head, s.exponent = head, head.Currency.Exponent
+	s.doc = pdf.New(pdf.A4Width, pdf.A4Height)
+	s.doc.SetTitle(fmt.Sprintf("Statement of %s (%s)", head.Username, head.Currency.Code))
+	s.page = s.doc.AddPage()
+
+	s.page.Text(pdfLeft, pdfTop, pdf.HelveticaBold, 18, "Account statement")
+	y := pdfTop - 26.0
+	for _, detail := range [][2]string{
+		{"Account holder", head.Username},
+		{"Currency", head.Currency.Code},
+		{"Period", head.From.Format("2006-01-02 15:04") + " to " + head.To.Format("2006-01-02 15:04") + " UTC"},
+		{"Generated", head.GeneratedAt.Format("2006-01-02 15:04") + " UTC"},
+	} {
+		s.page.Text(pdfLeft, y, pdf.HelveticaBold, 10, detail[0])
+		s.page.Text(pdfLeft+100, y, pdf.Helvetica, 10, detail[1])
+		y -= pdfRowHeight
+	}
+
+	// the summary is filled in at End, once the totals are known
+	s.y = y - 6*pdfRowHeight - 20
+	s.tableHeader()
+	return nil
+}
+
+// tableHeader starts the transaction table at the top of the space left.
+func (s *pdfStatement) tableHeader() {
+	s.page.Fill(pdfLeft-4, s.y-4, pdfRight-pdfLeft+8, pdfRowHeight+2, 0.9)
+	s.page.Text(pdfColumns.date, s.y, pdf.HelveticaBold, pdfFontSize, "Date")
+	s.page.Text(pdfColumns.typ, s.y, pdf.HelveticaBold, pdfFontSize, "Type")
+	s.page.Text(pdfColumns.details, s.y, pdf.HelveticaBold, pdfFontSize, "Details")
+	s.page.TextRight(pdfColumns.amount, s.y, pdf.HelveticaBold, pdfFontSize, "Amount")
+	s.page.TextRight(pdfColumns.balance, s.y, pdf.HelveticaBold, pdfFontSize, "Balance")
+	s.y -= pdfRowHeight + 4
+}
+
+// row makes room for one more row, on a new page when this one is full.
+func (s *pdfStatement) row() {
+	if s.y < pdfBottom {
+		s.page = s.doc.AddPage()
+		s.y = pdfTop
+		s.tableHeader()
+	}
+}
+
+func (s *pdfStatement) Line(l StatementLine) error {
+	s.count++
+	if l.Amount.IsNegative() {
+		s.out = s.out.Add(l.Amount.Neg())
+	} else {
+		s.in = s.in.Add(l.Amount)
+	}
+
+	var details []string
+	if l.Counterparty != "" {
+		direction := "from "
+		if l.Amount.IsNegative() {
+			direction = "to "
+		}
+		details = append(details, direction+l.Counterparty)
+	}
+	for _, d := range []string{l.Memo, l.Reference} {
+		if d != "" {
+			details = append(details, d)
+		}
+	}
+	typ := l.Type
+	if l.Status != TransactionCompleted {
+		typ += " (" + l.Status + ")"
+	}
+
+	s.row()
+	s.page.Text(pdfColumns.date, s.y, pdf.Helvetica, pdfFontSize, l.Date.Format("2006-01-02 15:04"))
+	s.page.Text(pdfColumns.typ, s.y, pdf.Helvetica, pdfFontSize, typ)
+	if len(details) > 0 {
+		s.page.Text(pdfColumns.details, s.y, pdf.Helvetica, pdfFontSize, pdf.Fit(pdf.Helvetica, pdfFontSize, strings.Join(details, ", "), pdfColumns.detailsWidth))
+	}
+	s.page.TextRight(pdfColumns.amount, s.y, pdf.Helvetica, pdfFontSize, s.money(l.Amount))
+	s.page.TextRight(pdfColumns.balance, s.y, pdf.Helvetica, pdfFontSize, s.money(l.Balance))
+	s.y -= pdfRowHeight
+	return nil
+}
+
+func (s *pdfStatement) End(closing Money) error {
+	if s.count == 0 {
+		s.row()
+		s.page.Text(pdfColumns.date, s.y, pdf.Helvetica, pdfFontSize, "No transactions in this period.")
+	}
+
+	first := s.doc.Pages()[0]
+	y := pdfTop - 26.0 - 4*pdfRowHeight - 12
+	first.Text(pdfLeft, y, pdf.HelveticaBold, 11, "Summary")
+	first.Line(pdfLeft, y-4, pdfLeft+250, y-4, 0.5)
+	for _, line := range [][2]string{
+		{"Opening balance", s.money(s.head.Opening)},
+		{"Money in", s.money(s.in)},
+		{"Money out", s.money(s.out.Neg())},
+		{"Closing balance", s.money(closing)},
+		{"Transactions", strconv.Itoa(s.count)},
+	} {
+		y -= pdfRowHeight
+		first.Text(pdfLeft, y, pdf.Helvetica, 10, line[0])
+		first.TextRight(pdfLeft+250, y, pdf.Helvetica, 10, line[1])
+	}
+
+	pages := s.doc.Pages()
+	footer := fmt.Sprintf("%s, %s, %s to %s", s.head.Username, s.head.Currency.Code, s.head.From.Format("2006-01-02"), s.head.To.Format("2006-01-02"))
+	for i, p := range pages {
+		p.Line(pdfLeft, pdfFooter+12, pdfRight, pdfFooter+12, 0.5)
+		p.Text(pdfLeft, pdfFooter, pdf.Helvetica, 8, footer)
+		p.TextRight(pdfRight, pdfFooter, pdf.Helvetica, 8, fmt.Sprintf("Page %d of %d", i+1, len(pages)))
+	}
+
+	_, err := s.doc.WriteTo(s.w)
+	return err
+}
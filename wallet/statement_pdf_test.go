@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func renderPDF(t *testing.T, head Statement, lines []StatementLine, closing Money) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewStatementWriter("pdf", &buf)
+	if err := w.Begin(head); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lines {
+		if err := w.Line(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.End(closing); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStatementPDF(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	head := Statement{
+		Username:    "alice",
+		Currency:    currencies["USD"],
+		From:        from,
+		To:          from.AddDate(0, 1, 0),
+		Opening:     MustParseMoney("1000"),
+		GeneratedAt: from.AddDate(0, 1, 1),
+	}
+
+	// enough to run over to a second page
+	var lines []StatementLine
+	balance := head.Opening
+	for i := 1; i <= 60; i++ {
+		l := StatementLine{ID: 100 + i, EntryID: 50 + i, Date: from.Add(time.Duration(i) * 11 * time.Hour), Type: "deposit", Status: TransactionCompleted, Amount: MustParseMoney("12.5")}
+		switch i % 3 {
+		case 1:
+			l.Type, l.Counterparty, l.Memo, l.Amount = "transfer", "bob", fmt.Sprintf("invoice %d for a rather long description of services", i), MustParseMoney("-20")
+		case 2:
+			l.Type, l.Status, l.Amount = "withdraw", "reversed", MustParseMoney("-3.75")
+		}
+		balance = balance.Add(l.Amount)
+		l.Balance = balance
+		lines = append(lines, l)
+	}
+
+	cases := []struct {
+		name  string
+		lines []StatementLine
+	}{
+		{"statement.pdf", lines},
+		{"statement-empty.pdf", nil},
+	}
+	for _, c := range cases {
+		closing := head.Opening
+		if len(c.lines) > 0 {
+			closing = c.lines[len(c.lines)-1].Balance
+		}
+		got := renderPDF(t, head, c.lines, closing)
+
+		path := filepath.Join("testdata", c.name)
+		if *update {
+			if err := os.WriteFile(path, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from the output, run go test -update to review the change", path)
+		}
+	}
+}
+
+func TestStatementPDF_Period(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := StatementQuery{From: from, To: from.Add(MaxPDFStatementPeriod + time.Second), Format: "pdf"}
+	if err := (Service{}).WriteStatement(context.Background(), "user1", q, nil); !errors.Is(err, ErrPeriodTooLong) {
+		t.Error("expect ErrPeriodTooLong, got", err)
+	}
+}
@@ -0,0 +1,146 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// StatementQuery selects the period and currency of a statement. Format is
+// csv, jsonl, ofx or pdf; when empty it is negotiated from the Accept header.
+type StatementQuery struct {
+	// From is inclusive, To exclusive.
+	From time.Time `form:"from" binding:"required_without=Month" time_format:"2006-01-02T15:04:05Z07:00"`
+	To   time.Time `form:"to" binding:"required_without=Month" time_format:"2006-01-02T15:04:05Z07:00"`
+	// Month (2024-03) selects a calendar month (UTC) instead of From and To.
+	Month    string `form:"month" binding:"omitempty,datetime=2006-01,excluded_with=From"`
+	Currency string `form:"currency" binding:"omitempty,currency"`
+	Format   string `form:"format" binding:"omitempty,oneof=csv jsonl ofx pdf"`
+}
+
+// MaxPDFStatementPeriod bounds the period of a PDF statement, which unlike
+// the other formats is laid out in memory before it is sent.
+const MaxPDFStatementPeriod = 31 * 24 * time.Hour
+
+// Statement heads a statement: whose account it covers, over which period,
+// and the ledger balance it opened with.
+type Statement struct {
+	Username    string
+	Currency    Currency
+	From        time.Time
+	To          time.Time
+	Opening     Money
+	GeneratedAt time.Time
+}
+
+// StatementLine is a posting completed during the statement period, with
+// the ledger balance right after it.
+type StatementLine struct {
+	ID           int
+	EntryID      int
+	Date         time.Time
+	Type         string
+	Status       string
+	Counterparty string
+	Memo         string
+	Reference    string
+	Amount       Money
+	Balance      Money
+}
+
+// StatementWriter renders a statement as it is read: Begin once, Line for
+// every posting in order, then End with the closing balance.
+type StatementWriter interface {
+	Begin(Statement) error
+	Line(StatementLine) error
+	End(closing Money) error
+}
+
+// statementSQL lists the postings to the account of user $1 in currency $2
+// completed from $3 until $4, in the order they moved its balance.
+const statementSQL = `SELECT p.id, p.entry_id, e.completed_at, e.entry_type, e.status, p.amount,
+    COALESCE(CASE WHEN t.from_user_id = a.user_id THEN tu.username ELSE fu.username END, ''),
+    COALESCE(t.memo, ''), COALESCE(t.reference, '')
+FROM postings p
+JOIN accounts a ON a.id = p.account_id
+JOIN journal_entries e ON e.id = p.entry_id
+LEFT JOIN transfers t ON t.id = e.transfer_id
+LEFT JOIN users fu ON fu.id = t.from_user_id
+LEFT JOIN users tu ON tu.id = t.to_user_id
+WHERE a.user_id = $1 AND a.currency = $2 AND e.completed_at >= $3 AND e.completed_at < $4
+ORDER BY e.completed_at, p.id`
+
+// WriteStatement writes the statement of username selected by q to w, row
+// by row as they are read, so a long history is never held in memory; only
+// a PDF is, and its period is bounded by MaxPDFStatementPeriod. The opening
+// balance and the postings are read from one snapshot of the ledger.
+// Nothing is written unless the user exists and q is valid.
+func (s Service) WriteStatement(ctx context.Context, username string, q StatementQuery, w StatementWriter) error {
+	from, to := q.From.UTC(), q.To.UTC()
+	if q.Month != "" {
+		from, _ = time.Parse("2006-01", q.Month)
+		to = from.AddDate(0, 1, 0)
+	}
+	if !from.Before(to) {
+		return errors.Wrap(ErrInvalidRequest, "from must be before to")
+	}
+	if q.Format == "pdf" && to.Sub(from) > MaxPDFStatementPeriod {
+		return errors.Wrapf(ErrPeriodTooLong, "a PDF statement covers at most %d days", MaxPDFStatementPeriod/(24*time.Hour))
+	}
+	currency, err := ParseCurrency(q.Currency)
+	if err != nil {
+		return err
+	}
+
+	d, err := s.factory.DB()
+	if err != nil {
+		return err
+	}
+	tx, err := d.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.Wrapf(ErrAccountNotFound, "%q", username)
+	}
+	if err != nil {
+		return err
+	}
+
+	statement := Statement{Username: username, Currency: currency, From: from, To: to, GeneratedAt: time.Now().UTC()}
+	err = tx.QueryRowContext(ctx, balanceAtSQL+" AND a.currency = $3", userID, from, currency.Code).Scan(new(string), new(sql.NullTime), &statement.Opening)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, statementSQL, userID, currency.Code, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if err = w.Begin(statement); err != nil {
+		return err
+	}
+	balance := statement.Opening
+	for rows.Next() {
+		var line StatementLine
+		if err = rows.Scan(&line.ID, &line.EntryID, &line.Date, &line.Type, &line.Status, &line.Amount, &line.Counterparty, &line.Memo, &line.Reference); err != nil {
+			return err
+		}
+		balance = balance.Add(line.Amount)
+		line.Date, line.Balance = line.Date.UTC(), balance
+		if err = w.Line(line); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return w.End(balance)
+}
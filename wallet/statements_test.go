@@ -0,0 +1,113 @@
+package wallet_test
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func statement(username, query, accept string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/accounts/"+username+"/statement?"+query, nil)
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, request)
+	return resp
+}
+
+func TestStatement(t *testing.T) {
+	from := time.Now().UTC().Add(-time.Minute)
+	username := holdUser(t, "100")
+	other := holdUser(t, "1")
+	if resp := call(http.MethodPost, "/transfer", fmt.Sprintf(`{"from":%q,"to":%q,"amount":30,"memo":"rent"}`, username, other)); resp.Code != http.StatusOK {
+		t.Fatal("transfer failed", resp.Body.String())
+	}
+	if resp := call(http.MethodPost, "/withdraw", fmt.Sprintf(`{"username":%q,"amount":20}`, username)); resp.Code != http.StatusOK {
+		t.Fatal("withdraw failed", resp.Body.String())
+	}
+	to := time.Now().UTC().Add(time.Minute)
+	period := url.Values{"from": {from.Format(time.RFC3339)}, "to": {to.Format(time.RFC3339)}}
+
+	resp := statement(username, period.Encode(), "")
+	if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Header().Get("Content-Type"), "text/csv") {
+		t.Fatal("expect a CSV statement, got", resp.Code, resp.Header(), resp.Body.String())
+	}
+	if !strings.Contains(resp.Header().Get("Content-Disposition"), "attachment") {
+		t.Error("expect an attachment, got", resp.Header().Get("Content-Disposition"))
+	}
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var balances, types []string
+	for _, row := range rows[1:] {
+		types, balances = append(types, row[3]), append(balances, row[9])
+	}
+	if strings.Join(types, " ") != "opening_balance deposit transfer withdraw closing_balance" ||
+		strings.Join(balances, " ") != "0.00 100.00 70.00 50.00 50.00" {
+		t.Error("expect the running balance from the deposit, got", rows)
+	}
+	if rows[3][5] != other || rows[3][6] != "rent" {
+		t.Error("expect the transfer's counterparty and memo, got", rows[3])
+	}
+
+	// only what completed after the deposit: it opens with 100
+	resp = statement(username, period.Encode(), "application/x-ndjson")
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatal("expect a JSON Lines statement, got", resp.Code, resp.Header())
+	}
+	var records []map[string]any
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var record map[string]any
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 5 || records[0]["record"] != "opening" || records[4]["record"] != "closing" || records[4]["balance"] != "50.00" {
+		t.Error("expect opening, three transactions and closing, got", records)
+	}
+
+	period.Set("format", "ofx")
+	resp = statement(username, period.Encode(), "text/csv")
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/x-ofx" ||
+		strings.Count(resp.Body.String(), "<STMTTRN>") != 3 || !strings.Contains(resp.Body.String(), "<BALAMT>50.00</BALAMT>") {
+		t.Error("expect an OFX statement, got", resp.Code, resp.Body.String())
+	}
+
+	period.Del("format")
+	resp = statement(username, period.Encode(), "application/pdf")
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/pdf" || !strings.HasPrefix(resp.Body.String(), "%PDF-") {
+		t.Error("expect a PDF statement, got", resp.Code, resp.Header())
+	}
+	resp = statement(username, "format=jsonl&month="+from.Format("2006-01"), "")
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"from":"`+from.Format("2006-01")+`-01T00:00:00Z"`) {
+		t.Error("expect the statement of the month, got", resp.Code, resp.Body.String())
+	}
+
+	for _, c := range []struct {
+		username, query, accept string
+		code                    int
+	}{
+		{username, period.Encode(), "image/png", http.StatusNotAcceptable},
+		{username, url.Values{"from": {from.AddDate(0, -2, 0).Format(time.RFC3339)}, "to": period["to"]}.Encode(), "application/pdf", http.StatusUnprocessableEntity},
+		{"notfound", period.Encode(), "", http.StatusNotFound},
+		{username, url.Values{"from": period["to"], "to": period["from"]}.Encode(), "", http.StatusBadRequest},
+		{username, "from=" + url.QueryEscape(from.Format(time.RFC3339)), "", http.StatusUnprocessableEntity},
+		{username, period.Encode() + "&month=2024-03", "", http.StatusUnprocessableEntity},
+		{username, "month=March", "", http.StatusUnprocessableEntity},
+	} {
+		if resp = statement(c.username, c.query, c.accept); resp.Code != c.code {
+			t.Error(c.query, c.accept, "expect", c.code, "got", resp.Code, resp.Body.String())
+		}
+	}
+}
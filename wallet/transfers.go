@@ -0,0 +1,51 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+)
+
+const transferSQL = `SELECT t.id, e.id, fu.username, tu.username, t.amount, t.currency, t.to_amount, t.to_currency,
+    COALESCE((SELECT SUM(fp.amount) FROM journal_entries f JOIN postings fp ON fp.entry_id = f.id WHERE f.fee_for_id = e.id AND fp.amount > 0), 0),
+    e.rate, COALESCE(t.memo, ''), COALESCE(t.reference, ''), e.status, t.created_at
+FROM transfers t
+JOIN users fu ON fu.id = t.from_user_id
+JOIN users tu ON tu.id = t.to_user_id
+JOIN journal_entries e ON e.transfer_id = t.id
+WHERE t.id = $1`
+
+func scanTransfer(row *sql.Row) (Transfer, error) {
+	var t Transfer
+	err := row.Scan(&t.ID, &t.TransactionID, &t.From, &t.To, &t.Amount, &t.Currency, &t.ToAmount, &t.ToCurrency, &t.Fee, &t.Rate, &t.Memo, &t.Reference, &t.Status, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Transfer{}, ErrTransferNotFound
+	}
+	return t, err
+}
+
+// insertTransfer records a transfer of req.Amount in currency, crediting
+// conv, and returns its id. Its journal entry is posted separately.
+func insertTransfer(tx *sql.Tx, req TransferRequest, currency Currency, conv conversion) (int, error) {
+	var id int
+	err := tx.QueryRow(`INSERT INTO transfers (from_user_id, to_user_id, amount, currency, to_amount, to_currency, memo, reference)
+SELECT f.id, t.id, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, '')
+FROM users f, users t
+WHERE f.username = $1 AND t.username = $2
+RETURNING id`, req.From, req.To, req.Amount, currency.Code, conv.amount, conv.currency.Code, req.Memo, req.Reference).Scan(&id)
+	return id, err
+}
+
+// GetTransfer returns transfer id.
+func (s Service) GetTransfer(ctx context.Context, id int) (Transfer, error) {
+	db, err := s.factory.DB()
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	transfer, err := scanTransfer(db.QueryRowContext(ctx, transferSQL, id))
+	if errors.Is(err, ErrTransferNotFound) {
+		return Transfer{}, errors.Wrapf(err, "%d", id)
+	}
+	return transfer, err
+}
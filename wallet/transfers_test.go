@@ -0,0 +1,58 @@
+package wallet_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bitmyth/walletserivce/wallet"
+	"net/http"
+	"testing"
+)
+
+func TestGetTransfer(t *testing.T) {
+	call(http.MethodPost, "/deposit", `{"username":"user1","amount":3}`)
+	resp := call(http.MethodPost, "/transfer", `{"from":"user1","to":"user2","amount":2.5,"memo":"lunch","reference":"order-42"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+	var created wallet.Transfer
+	_ = json.Unmarshal(resp.Body.Bytes(), &created)
+	if created.ID == 0 || created.TransactionID == 0 {
+		t.Fatal("expect the transfer id", resp.Body.String())
+	}
+
+	resp = call(http.MethodGet, fmt.Sprintf("/transfers/%d", created.ID), "")
+	if resp.Code != http.StatusOK {
+		t.Fatal("code is not 200", resp.Body.String())
+	}
+	var transfer wallet.Transfer
+	_ = json.Unmarshal(resp.Body.Bytes(), &transfer)
+	if transfer.From != "user1" || transfer.To != "user2" || !transfer.Amount.Equal(wallet.MustParseMoney("2.5")) ||
+		transfer.Memo != "lunch" || transfer.Reference != "order-42" || transfer.Status != wallet.TransactionCompleted {
+		t.Error("unexpected transfer", resp.Body.String())
+	}
+
+	// both legs link the transfer and name the other side
+	for user, counterparty := range map[string]string{"user1": "user2", "user2": "user1"} {
+		resp = call(http.MethodGet, "/transactions/"+user+"?type=transfer&limit=100", "")
+		var page wallet.HistoryPage
+		_ = json.Unmarshal(resp.Body.Bytes(), &page)
+		found := false
+		for _, tx := range page.Transactions {
+			if tx.TransferID != nil && *tx.TransferID == created.ID {
+				found = true
+				if tx.Counterparty != counterparty {
+					t.Error(user, "expect counterparty", counterparty, "got", tx.Counterparty)
+				}
+			}
+		}
+		if !found {
+			t.Error(user, "history misses the transfer", resp.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/transfers/999999999", "/transfers/abc"} {
+		if resp = call(http.MethodGet, path, ""); resp.Code != http.StatusNotFound {
+			t.Error(path, "expect 404, got", resp.Code)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package wallet
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MaxAmount is the largest amount a single request may move. It keeps well
+// inside NUMERIC(20,4), so balances cannot overflow either.
+var MaxAmount = MustParseMoney("1000000000")
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{2,49}$`)
+
+var registerOnce sync.Once
+
+// registerValidators teaches gin's validator the wallet tags:
+//
+//	money     a positive amount of at most MaxAmount
+//	currency  a known ISO 4217 code, in any case
+//	username  3 to 50 letters, digits, '_', '.' or '-', starting alphanumeric
+//	cron      a five-field cron expression or descriptor (see parseCron)
+//
+// and checks amounts against the precision of the request's currency.
+func registerValidators() {
+	registerOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+
+		// report fields by their JSON or query parameter name
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			for _, key := range []string{"json", "form"} {
+				name := strings.SplitN(f.Tag.Get(key), ",", 2)[0]
+				if name != "" && name != "-" {
+					return name
+				}
+			}
+			return f.Name
+		})
+		// validate Money as its decimal text
+		v.RegisterCustomTypeFunc(func(f reflect.Value) any {
+			return f.Interface().(Money).String()
+		}, Money{})
+
+		_ = v.RegisterValidation("money", validateMoney)
+		_ = v.RegisterValidation("currency", validateCurrency)
+		_ = v.RegisterValidation("username", validateUsername)
+		_ = v.RegisterValidation("cron", validateCron)
+		v.RegisterStructValidation(validateRequest, Request{})
+		v.RegisterStructValidation(validateTransferRequest, TransferRequest{})
+		v.RegisterStructValidation(validateHoldRequest, HoldRequest{})
+		v.RegisterStructValidation(validateQuoteRequest, QuoteRequest{})
+		v.RegisterStructValidation(validateScheduleRequest, ScheduleRequest{})
+	})
+}
+
+func validateMoney(fl validator.FieldLevel) bool {
+	m, err := ParseMoney(fl.Field().String())
+	return err == nil && m.IsPositive() && !MaxAmount.LessThan(m)
+}
+
+func validateCurrency(fl validator.FieldLevel) bool {
+	_, err := ParseCurrency(fl.Field().String())
+	return err == nil
+}
+
+func validateUsername(fl validator.FieldLevel) bool {
+	return usernamePattern.MatchString(fl.Field().String())
+}
+
+func validateCron(fl validator.FieldLevel) bool {
+	_, err := parseCron(fl.Field().String())
+	return err == nil
+}
+
+// checkPrecision reports amount when it has more places than its currency.
+// An unknown currency is left to the currency tag.
+func checkPrecision(sl validator.StructLevel, amount Money, code string) {
+	if currency, err := ParseCurrency(code); err == nil && currency.Check(amount) != nil {
+		sl.ReportError(amount, "amount", "Amount", "precision", currency.Code)
+	}
+}
+
+func validateRequest(sl validator.StructLevel) {
+	req := sl.Current().Interface().(Request)
+	checkPrecision(sl, req.Amount, req.Currency)
+}
+
+func validateHoldRequest(sl validator.StructLevel) {
+	req := sl.Current().Interface().(HoldRequest)
+	checkPrecision(sl, req.Amount, req.Currency)
+}
+
+func validateQuoteRequest(sl validator.StructLevel) {
+	req := sl.Current().Interface().(QuoteRequest)
+	if req.Amount != nil {
+		checkPrecision(sl, *req.Amount, req.From)
+	}
+}
+
+// validateTransferRequest rejects a transfer back into the same account.
+// Converting between one's own currencies is fine.
+func validateTransferRequest(sl validator.StructLevel) {
+	req := sl.Current().Interface().(TransferRequest)
+	checkPrecision(sl, req.Amount, req.Currency)
+
+	from, _ := ParseCurrency(req.Currency)
+	to, _ := ParseCurrency(req.ToCurrency)
+	if req.From == req.To && (req.ToCurrency == "" || from == to) {
+		sl.ReportError(req.To, "to", "To", "nefield", "from")
+	}
+}
+
+// validateScheduleRequest asks for one of cron and interval_seconds, or a
+// start_at for a schedule paying once.
+func validateScheduleRequest(sl validator.StructLevel) {
+	req := sl.Current().Interface().(ScheduleRequest)
+	checkPrecision(sl, req.Amount, req.Currency)
+
+	if req.From == req.To {
+		sl.ReportError(req.To, "to", "To", "nefield", "from")
+	}
+	switch {
+	case req.Cron != "" && req.IntervalSeconds != 0:
+		sl.ReportError(req.IntervalSeconds, "interval_seconds", "IntervalSeconds", "excluded_with", "cron")
+	case req.Cron == "" && req.IntervalSeconds == 0 && req.StartAt == nil:
+		sl.ReportError(req.StartAt, "start_at", "StartAt", "required", "")
+	}
+}
+
+// FieldError describes one field of a request that failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var ruleMessages = map[string]string{
+	"required":         "is required",
+	"money":            "must be a positive amount of at most " + MaxAmount.String(),
+	"currency":         "must be a supported ISO 4217 currency code",
+	"username":         "must be 3 to 50 letters, digits, '_', '.' or '-', starting with a letter or digit",
+	"precision":        "has more decimal places than the currency allows",
+	"nefield":          "must differ from",
+	"cron":             "must be a cron expression of 5 fields or a descriptor like @monthly",
+	"excluded_with":    "must not be given with",
+	"required_without": "is required without",
+	"datetime":         "must be formatted as",
+	"min":              "must be at least",
+	"max":              "must be at most",
+	"oneof":            "must be one of",
+}
+
+// fieldPath names e by its path in the request, e.g. transfers[2].amount.
+func fieldPath(e validator.FieldError) string {
+	if _, path, ok := strings.Cut(e.Namespace(), "."); ok {
+		return path
+	}
+	return e.Field()
+}
+
+func fieldErrors(errs validator.ValidationErrors) []FieldError {
+	fields := make([]FieldError, 0, len(errs))
+	for _, e := range errs {
+		msg, ok := ruleMessages[e.Tag()]
+		if !ok {
+			msg = "failed the " + e.Tag() + " rule"
+		}
+		switch e.Tag() {
+		case "nefield", "min", "max", "oneof", "excluded_with", "required_without", "datetime":
+			msg += " " + e.Param()
+		}
+		fields = append(fields, FieldError{Field: fieldPath(e), Rule: e.Tag(), Message: msg})
+	}
+	return fields
+}
+
+// bind decodes and validates the JSON body into v. Malformed JSON is
+// ErrInvalidRequest; a body breaking the binding rules is returned as
+// validator.ValidationErrors and answered with the failing fields.
+func bind(ctx *gin.Context, v any) error {
+	return bindError(ctx.ShouldBindJSON(v))
+}
+
+// bindQuery is bind for the query string.
+func bindQuery(ctx *gin.Context, v any) error {
+	return bindError(ctx.ShouldBindQuery(v))
+}
+
+func bindError(err error) error {
+	var invalid validator.ValidationErrors
+	if err != nil && !errors.Is(err, ErrInvalidMoney) && !errors.As(err, &invalid) {
+		return errors.Wrap(ErrInvalidRequest, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package wallet
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func bindBody(body string, v any) error {
+	registerValidators()
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	return bind(ctx, v)
+}
+
+func TestBind_Request(t *testing.T) {
+	cases := map[string]string{
+		`{"username":"user1","amount":1.5}`:                    "",
+		`{"username":"user1","amount":"2","currency":"jpy"}`:   "",
+		`{"username":"user1","amount":-1}`:                     "amount/money",
+		`{"username":"user1","amount":0}`:                      "amount/money",
+		`{"username":"user1","amount":1000000000.01}`:          "amount/money",
+		`{"username":"user1"}`:                                 "amount/money",
+		`{"amount":1}`:                                         "username/required",
+		`{"username":"a;drop","amount":1}`:                     "username/username",
+		`{"username":"user1","amount":1,"currency":"ABC"}`:     "currency/currency",
+		`{"username":"user1","amount":1.5,"currency":"JPY"}`:   "amount/precision",
+		`{"username":"user1","amount":0.001}`:                  "amount/precision",
+		`{"username":"user1","amount":0.001,"currency":"KWD"}`: "",
+	}
+	for body, want := range cases {
+		var req Request
+		err := bindBody(body, &req)
+		if got := failed(err); got != want {
+			t.Errorf("bind(%s) failed %q, want %q (%v)", body, got, want, err)
+		}
+	}
+}
+
+func TestBind_TransferRequest(t *testing.T) {
+	cases := map[string]string{
+		`{"from":"user1","to":"user2","amount":1}`:                                           "",
+		`{"from":"user1","to":"user1","amount":1}`:                                           "to/nefield",
+		`{"from":"user1","to":"user1","amount":1,"currency":"usd","to_currency":"USD"}`:      "to/nefield",
+		`{"from":"user1","to":"user1","amount":1,"to_currency":"EUR","quote_id":"q"}`:        "",
+		`{"from":"user1","to":"user2","amount":1,"to_currency":"XYZ"}`:                       "to_currency/currency",
+		`{"from":"user1","to":"user2","amount":1,"memo":"rent","reference":"inv-7"}`:         "",
+		`{"from":"user1","to":"user2","amount":1,"memo":"` + strings.Repeat("x", 141) + `"}`: "memo/max",
+	}
+	for body, want := range cases {
+		var req TransferRequest
+		err := bindBody(body, &req)
+		if got := failed(err); got != want {
+			t.Errorf("bind(%s) failed %q, want %q (%v)", body, got, want, err)
+		}
+	}
+}
+
+func TestBind_BatchTransferRequest(t *testing.T) {
+	cases := map[string]string{
+		`{"from":"payroll","transfers":[{"to":"user1","amount":1},{"to":"user2","amount":2}]}`: "",
+		`{"transfers":[{"from":"user1","to":"user2","amount":1}],"mode":"best_effort"}`:        "",
+		`{"transfers":[{"from":"user1","to":"user2","amount":1}],"mode":"maybe"}`:              "mode/oneof",
+		`{"transfers":[]}`:                          "transfers/min",
+		`{"from":"payroll"}`:                        "transfers/required",
+		`{"transfers":[{"to":"user1","amount":1}]}`: "transfers[0].from/required",
+		`{"from":"payroll","transfers":[{"to":"user1","amount":1},{"to":"user2","amount":0}]}`: "transfers[1].amount/money",
+		`{"from":"payroll","transfers":[{"to":"payroll","amount":1}]}`:                         "transfers[0].to/nefield",
+		`{"from":"payroll","transfers":[{"to":"user1","amount":0.001}]}`:                       "transfers[0].amount/precision",
+	}
+	for body, want := range cases {
+		var req BatchTransferRequest
+		err := bindBody(body, &req)
+		if got := failed(err); got != want {
+			t.Errorf("bind(%s) failed %q, want %q (%v)", body, got, want, err)
+		}
+	}
+
+	var req BatchTransferRequest
+	_ = bindBody(`{"from":"payroll","transfers":[{"to":"user1","amount":1},{"from":"user2","to":"user1","amount":1}]}`, &req)
+	if req.Transfers[0].From != "payroll" || req.Transfers[1].From != "user2" {
+		t.Error("the batch payer should only fill in missing payers", req.Transfers)
+	}
+}
+
+func TestBind_ScheduleRequest(t *testing.T) {
+	cases := map[string]string{
+		`{"from":"user1","to":"user2","amount":50,"cron":"0 0 1 * *"}`:                      "",
+		`{"from":"user1","to":"user2","amount":50,"interval_seconds":86400}`:                "",
+		`{"from":"user1","to":"user2","amount":50,"start_at":"2030-01-01T00:00:00Z"}`:       "",
+		`{"from":"user1","to":"user2","amount":50}`:                                         "start_at/required",
+		`{"from":"user1","to":"user2","amount":50,"cron":"monthly"}`:                        "cron/cron",
+		`{"from":"user1","to":"user2","amount":50,"cron":"@daily","interval_seconds":3600}`: "interval_seconds/excluded_with",
+		`{"from":"user1","to":"user2","amount":50,"interval_seconds":5}`:                    "interval_seconds/min",
+		`{"from":"user1","to":"user1","amount":50,"cron":"@daily"}`:                         "to/nefield",
+		`{"from":"user1","to":"user2","amount":50,"cron":"@daily","max_retries":11}`:        "max_retries/max",
+	}
+	for body, want := range cases {
+		var req ScheduleRequest
+		err := bindBody(body, &req)
+		if got := failed(err); got != want {
+			t.Errorf("bind(%s) failed %q, want %q (%v)", body, got, want, err)
+		}
+	}
+}
+
+func TestBind_QuoteRequest(t *testing.T) {
+	cases := map[string]string{
+		`{"from":"USD","to":"eur"}`:                 "",
+		`{"from":"USD","to":"EUR","amount":"10.5"}`: "",
+		`{"from":"USD"}`:                            "to/required",
+		`{"from":"USD","to":"XXX"}`:                 "to/currency",
+		`{"from":"USD","to":"EUR","amount":0}`:      "amount/money",
+		`{"from":"USD","to":"EUR","amount":-1}`:     "amount/money",
+		`{"from":"JPY","to":"EUR","amount":1.5}`:    "amount/precision",
+	}
+	for body, want := range cases {
+		var req QuoteRequest
+		err := bindBody(body, &req)
+		if got := failed(err); got != want {
+			t.Errorf("bind(%s) failed %q, want %q (%v)", body, got, want, err)
+		}
+	}
+}
+
+func TestBind_Malformed(t *testing.T) {
+	var req Request
+	if err := bindBody(`{a:"2"}`, &req); !errors.Is(err, ErrInvalidRequest) {
+		t.Error("expect ErrInvalidRequest, got", err)
+	}
+	if err := bindBody(`{"username":"user1","amount":"NaN"}`, &req); !errors.Is(err, ErrInvalidMoney) {
+		t.Error("expect ErrInvalidMoney, got", err)
+	}
+}
+
+// failed returns "field/rule" of the only validation failure in err.
+func failed(err error) string {
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		if err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+	fields := fieldErrors(invalid)
+	if len(fields) != 1 {
+		return "several"
+	}
+	return fields[0].Field + "/" + fields[0].Rule
+}